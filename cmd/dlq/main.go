@@ -0,0 +1,102 @@
+// Command dlq inspects and manages tasks in the dead-letter queue.
+//
+// Usage:
+//
+//	dlq list   [-host example.com] [-class timeout] [-limit 100] [-after ID]
+//	dlq purge  [-host example.com] [-class timeout]
+//	dlq replay [-host example.com] [-class timeout] [-target high|medium]
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/NesterovYehor/Crawler/internal/config"
+	"github.com/NesterovYehor/Crawler/internal/queue"
+	"github.com/redis/go-redis/v9"
+)
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: dlq <list|purge|replay> [flags]")
+	}
+	cmd := args[0]
+
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	host := fs.String("host", "", "only dead letters for this host")
+	class := fs.String("class", "", "only dead letters with this error class")
+	limit := fs.Int("limit", 0, "maximum number of dead letters to list (0 means all)")
+	after := fs.String("after", "", "only dead letters after this ID, to list the next page")
+	target := fs.String("target", "medium", "queue to replay into: high or medium")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	filter := queue.DeadLetterFilter{Host: *host, ErrorClass: *class, Limit: *limit, After: *after}
+
+	cfg, err := config.NewConfig()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	client := redis.NewClient(&redis.Options{Addr: cfg.Cache.Addr})
+	defer client.Close()
+	q, err := queue.NewQueue(ctx, client, cfg.Queue)
+	if err != nil {
+		return err
+	}
+
+	switch cmd {
+	case "list":
+		letters, err := q.ListDeadLetters(ctx, filter)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tHOST\tCLASS\tATTEMPTS\tDEAD AT\tURL\tLAST ERROR")
+		for _, dl := range letters {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\t%s\n",
+				dl.ID, dl.Host, dl.ErrorClass, len(dl.Task.Attempts),
+				dl.DeadAt.Format(time.RFC3339), dl.Task.URL, dl.LastError)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		if *limit > 0 && len(letters) == *limit {
+			fmt.Fprintf(os.Stderr, "next page: -after %s\n", letters[len(letters)-1].ID)
+		}
+	case "purge":
+		n, err := q.PurgeDeadLetters(ctx, filter)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("purged %d dead letters\n", n)
+	case "replay":
+		dest := queue.MediumPriorityQueue
+		if *target == "high" {
+			dest = queue.HighPriorityQueue
+		} else if *target != "medium" {
+			return fmt.Errorf("unknown replay target %q", *target)
+		}
+		n, err := q.ReplayDeadLetters(ctx, filter, dest)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("replayed %d dead letters into %s\n", n, dest)
+	default:
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+// Command jobs creates and controls crawl jobs.
+//
+// Usage:
+//
+//	jobs create -seeds urls.txt [-name docs] [-scope example.com,example.org] [-max-depth 3] [-max-pages 10000]
+//	            [-product-token Bot] [-user-agent "Bot/2.0"] [-contact-url https://example.com/bot] [-from bot@example.com]
+//	            [-proxy-strategy sticky]
+//	jobs list
+//	jobs pause  <job-id>
+//	jobs resume <job-id>
+//	jobs cancel <job-id>
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/NesterovYehor/Crawler/internal/config"
+	"github.com/NesterovYehor/Crawler/internal/identity"
+	"github.com/NesterovYehor/Crawler/internal/jobs"
+	"github.com/NesterovYehor/Crawler/internal/loader"
+	"github.com/NesterovYehor/Crawler/internal/models"
+	"github.com/NesterovYehor/Crawler/internal/proxy"
+	"github.com/NesterovYehor/Crawler/internal/queue"
+	"github.com/redis/go-redis/v9"
+)
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: jobs <create|list|pause|resume|cancel> [flags]")
+	}
+	cmd := args[0]
+
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	name := fs.String("name", "", "human readable job name")
+	seeds := fs.String("seeds", "", "seed file with one URL per line")
+	scope := fs.String("scope", "", "comma separated hosts the job may crawl (empty means any)")
+	maxDepth := fs.Int("max-depth", -1, "max link depth from a seed (default crawl.max_depth)")
+	maxPages := fs.Int64("max-pages", 0, "stop discovering links after this many pages (0 means unlimited)")
+	var ident identity.Identity
+	fs.StringVar(&ident.ProductToken, "product-token", "", "robots.txt agent and User-Agent token (default identity.product_token)")
+	fs.StringVar(&ident.UserAgent, "user-agent", "", "full User-Agent header (default identity.user_agent)")
+	fs.StringVar(&ident.ContactURL, "contact-url", "", "contact URL in the User-Agent (default identity.contact_url)")
+	fs.StringVar(&ident.From, "from", "", "From header (default identity.from)")
+	proxyStrategy := fs.String("proxy-strategy", "", "sticky, round_robin or least_failures (default proxies.strategy)")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	cfg, err := config.NewConfig()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	client := redis.NewClient(&redis.Options{Addr: cfg.Cache.Addr})
+	defer client.Close()
+	q, err := queue.NewQueue(ctx, client, cfg.Queue)
+	if err != nil {
+		return err
+	}
+	store := jobs.NewStore(client)
+
+	if cmd == "create" {
+		if *proxyStrategy != "" && !proxy.ValidStrategy(*proxyStrategy) {
+			return fmt.Errorf("unknown proxy strategy %q", *proxyStrategy)
+		}
+		depth := *maxDepth
+		if depth < 0 {
+			depth = cfg.Crawl.MaxDepth
+		}
+		return create(ctx, store, q, &models.Job{
+			Name:          *name,
+			Scope:         splitList(*scope),
+			MaxDepth:      depth,
+			MaxPages:      *maxPages,
+			Identity:      jobIdentity(ident),
+			ProxyStrategy: *proxyStrategy,
+		}, *seeds)
+	}
+	if cmd == "list" {
+		return list(ctx, store)
+	}
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: jobs %s <job-id>", cmd)
+	}
+	id := fs.Arg(0)
+	switch cmd {
+	case "pause":
+		if err := store.SetStatus(ctx, id, models.JobPaused); err != nil {
+			return err
+		}
+		fmt.Printf("paused job %s\n", id)
+	case "resume":
+		if err := store.SetStatus(ctx, id, models.JobActive); err != nil {
+			return err
+		}
+		tasks, err := store.Unpark(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err := q.Add(tasks); err != nil {
+			return err
+		}
+		fmt.Printf("resumed job %s, requeued %d parked tasks\n", id, len(tasks))
+	case "cancel":
+		if err := store.SetStatus(ctx, id, models.JobCancelled); err != nil {
+			return err
+		}
+		queued, err := q.PurgeJob(ctx, id)
+		if err != nil {
+			return err
+		}
+		parked, err := store.DropParked(ctx, id)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("cancelled job %s, purged %d queued and %d parked tasks\n", id, queued, parked)
+	default:
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+	return nil
+}
+
+func create(ctx context.Context, store jobs.Interface, q queue.Interface, job *models.Job, seedFile string) error {
+	tasks, err := loader.SeedTasks(seedFile, job.MaxDepth)
+	if err != nil {
+		return err
+	}
+	if len(tasks) == 0 {
+		return fmt.Errorf("seed file %s has no URLs", seedFile)
+	}
+	for _, t := range tasks {
+		job.Seeds = append(job.Seeds, t.URL)
+	}
+	if err := store.Create(ctx, job); err != nil {
+		return err
+	}
+	for _, t := range tasks {
+		t.JobID = job.ID
+	}
+	if err := q.Add(tasks); err != nil {
+		return err
+	}
+	fmt.Printf("created job %s with %d seeds\n", job.ID, len(tasks))
+	return nil
+}
+
+func list(ctx context.Context, store jobs.Interface) error {
+	all, err := store.List(ctx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tSTATUS\tSEEDS\tSCOPE\tMAX DEPTH\tMAX PAGES\tCREATED")
+	for _, j := range all {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%d\t%d\t%s\n",
+			j.ID, j.Name, j.Status, len(j.Seeds), strings.Join(j.Scope, ","),
+			j.MaxDepth, j.MaxPages, j.CreatedAt.Format(time.RFC3339))
+	}
+	return w.Flush()
+}
+
+// jobIdentity returns the identity flags, or nil when none is set and the
+// job uses the configured identity.
+func jobIdentity(id identity.Identity) *identity.Identity {
+	if id == (identity.Identity{}) {
+		return nil
+	}
+	return &id
+}
+
+func splitList(s string) []string {
+	var res []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			res = append(res, part)
+		}
+	}
+	return res
+}
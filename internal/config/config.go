@@ -2,7 +2,9 @@ package config
 
 import (
 	"fmt"
+	"time"
 
+	"github.com/NesterovYehor/Crawler/internal/identity"
 	"github.com/spf13/viper"
 )
 
@@ -18,9 +20,15 @@ type Metrics struct {
 	Port string `mapstructure:"port"`
 }
 
+// Admin is the address of the worker pool's admin endpoint. An empty port
+// disables it.
+type Admin struct {
+	Port string `mapstructure:"port"`
+}
+
 type Fetch struct {
 	HighPrioretyCount int `mapstructure:"high_priority_count"`
-	MedPrioretyCount  int `mapstructure :"med_priority_count"`
+	MedPrioretyCount  int `mapstructure:"med_priority_count"`
 	LowPrioretyCount  int `mapstructure:"low_priority_count"`
 }
 
@@ -29,16 +37,195 @@ type Scripts struct {
 	Update string `mapstructure:"update"`
 }
 
+// Lane is a named task stream together with the number of workers that
+// serve it and the weight used when idle workers pick another lane.
+// MaxLen caps the number of tasks the lane may hold; zero means unlimited.
+// MinWorkers and MaxWorkers bound the autoscaler; a lane with neither keeps
+// Workers fixed.
+type Lane struct {
+	Name       string `mapstructure:"name"`
+	Workers    int    `mapstructure:"workers"`
+	Weight     int    `mapstructure:"weight"`
+	MaxLen     int64  `mapstructure:"max_len"`
+	MinWorkers int    `mapstructure:"min_workers"`
+	MaxWorkers int    `mapstructure:"max_workers"`
+}
+
+// Backpressure controls what happens to discovered links when their lane is
+// close to its MaxLen.
+type Backpressure struct {
+	Policy        string        `mapstructure:"policy"`
+	HighWatermark float64       `mapstructure:"high_watermark"`
+	LowWatermark  float64       `mapstructure:"low_watermark"`
+	SpillDir      string        `mapstructure:"spill_dir"`
+	BlockTimeout  time.Duration `mapstructure:"block_timeout"`
+}
+
 type Queue struct {
-	Stream     string `mapstructure:"stream"`
-	GroupName  string `mapstructure:"group_name"`
-	ConsumerID string `mapstructure:"consumer_id"`
+	Backend           string        `mapstructure:"backend"`
+	Stream            string        `mapstructure:"stream"`
+	GroupName         string        `mapstructure:"group_name"`
+	ConsumerID        string        `mapstructure:"consumer_id"`
+	VisibilityTimeout time.Duration `mapstructure:"visibility_timeout"`
+	ReclaimInterval   time.Duration `mapstructure:"reclaim_interval"`
+	HostDelay         time.Duration `mapstructure:"host_delay"`
+	RetrySets         []string      `mapstructure:"retry_sets"`
+	Lanes             []Lane        `mapstructure:"lanes"`
+	Backpressure      Backpressure  `mapstructure:"backpressure"`
 }
 
+// Workers sizes the worker pool. TaskTimeout is the deadline of a single
+// task, including its requests. ShutdownTimeout is how long in-flight tasks
+// may run after shutdown starts before they are cancelled and requeued.
 type Workers struct {
-	Upload Upload `mapstructure:"upload"`
-	Fetch  Fetch  `mapstructure:"fetch"`
-	Total  int    `mapstructure:"total"`
+	Upload          Upload        `mapstructure:"upload"`
+	Fetch           Fetch         `mapstructure:"fetch"`
+	Total           int           `mapstructure:"total"`
+	TaskTimeout     time.Duration `mapstructure:"task_timeout"`
+	ShutdownTimeout time.Duration `mapstructure:"shutdown_timeout"`
+	Autoscale       Autoscale     `mapstructure:"autoscale"`
+}
+
+// Autoscale controls how the worker pool resizes lanes between their
+// MinWorkers and MaxWorkers. A lane grows while its backlog exceeds
+// BacklogPerWorker tasks per worker and shrinks once its workers are idle for
+// IdleThreshold of the time or its p95 task latency exceeds MaxLatency.
+type Autoscale struct {
+	Enabled          bool          `mapstructure:"enabled"`
+	Interval         time.Duration `mapstructure:"interval"`
+	BacklogPerWorker int64         `mapstructure:"backlog_per_worker"`
+	IdleThreshold    float64       `mapstructure:"idle_threshold"`
+	MaxLatency       time.Duration `mapstructure:"max_latency"`
+}
+
+// Crawl holds limits that apply to every seed unless the seed file
+// overrides them. A MaxDepth of zero leaves the depth unlimited.
+type Crawl struct {
+	MaxDepth int `mapstructure:"max_depth"`
+}
+
+// Response limits what the HTTP client reads. Bodies longer than
+// MaxBodyBytes are cut off and marked as truncated. Responses whose media
+// type is not in AllowedContentTypes are rejected before their body is
+// read; entries may be wildcards such as "text/*", and an empty list
+// allows every type.
+type Response struct {
+	MaxBodyBytes        int64    `mapstructure:"max_body_bytes"`
+	AllowedContentTypes []string `mapstructure:"allowed_content_types"`
+}
+
+// Sitemaps bounds the sitemaps read for each domain. MaxDepth is how deep
+// sitemap indexes are followed below the sitemaps robots.txt lists,
+// MaxFiles how many sitemaps are fetched and MaxURLs how many pages are
+// taken from them in all.
+type Sitemaps struct {
+	MaxDepth int `mapstructure:"max_depth"`
+	MaxFiles int `mapstructure:"max_files"`
+	MaxURLs  int `mapstructure:"max_urls"`
+}
+
+// Proxies is the pool of outbound HTTP and SOCKS5 proxies. Strategy picks
+// the proxy of each request: "sticky" keeps a host on one proxy,
+// "round_robin" rotates through them and "least_failures" prefers the one
+// that failed least. Domains override the strategy for a domain and its
+// subdomains, and jobs may override both. A proxy that fails MaxFailures
+// times in a row is ejected for Cooldown. Without URLs, requests go direct
+// or through the proxy set in the environment.
+type Proxies struct {
+	URLs        []string      `mapstructure:"urls"`
+	Strategy    string        `mapstructure:"strategy"`
+	MaxFailures int           `mapstructure:"max_failures"`
+	Cooldown    time.Duration `mapstructure:"cooldown"`
+	Domains     []DomainProxy `mapstructure:"domains"`
+}
+
+type DomainProxy struct {
+	Domain   string `mapstructure:"domain"`
+	Strategy string `mapstructure:"strategy"`
+}
+
+// Profile is how requests to a domain and its subdomains present
+// themselves: extra headers, credentials and whether cookies are kept.
+// Credentials are read from the environment or from files, never from the
+// config itself.
+type Profile struct {
+	Domain  string            `mapstructure:"domain"`
+	Headers map[string]string `mapstructure:"headers"`
+	Basic   BasicAuth         `mapstructure:"basic"`
+	Bearer  Secret            `mapstructure:"bearer"`
+	Cookies bool              `mapstructure:"cookies"`
+}
+
+type BasicAuth struct {
+	Username string `mapstructure:"username"`
+	Password Secret `mapstructure:"password"`
+}
+
+// Secret names the environment variable or the file a credential is read
+// from.
+type Secret struct {
+	Env  string `mapstructure:"env"`
+	File string `mapstructure:"file"`
+}
+
+// DNS configures the HTTP client's caching resolver. Answers are cached
+// for their TTL clamped to [MinTTL, MaxTTL]; answers from the system
+// resolver, which carry no TTL, for MinTTL. Failed lookups are cached for
+// NegativeTTL. At most MaxEntries hosts are cached.
+type DNS struct {
+	Cache       bool          `mapstructure:"cache"`
+	MinTTL      time.Duration `mapstructure:"min_ttl"`
+	MaxTTL      time.Duration `mapstructure:"max_ttl"`
+	NegativeTTL time.Duration `mapstructure:"negative_ttl"`
+	MaxEntries  int           `mapstructure:"max_entries"`
+}
+
+// Politeness caps the load the crawler puts on each origin, on top of the
+// per-domain delay. Like the delay, the caps are shared through Redis.
+// MaxConcurrent caps the requests in flight and MinInterval spaces out
+// their starts; zero disables either. With ByIP the caps also apply to the
+// server IP each request connects to, so virtual hosts on one server share
+// its budget.
+type Politeness struct {
+	ByIP          bool          `mapstructure:"by_ip"`
+	MaxConcurrent int           `mapstructure:"max_concurrent"`
+	MinInterval   time.Duration `mapstructure:"min_interval"`
+}
+
+// Redirects is the redirect policy of the HTTP client. It follows at most
+// MaxHops redirects of a fetch, and only those within Scope: "any",
+// "same_host" or "same_site", the registrable domain of the first URL.
+// Redirects out of a job's scope are never followed. With Enqueue the
+// client follows none and the crawler queues each target as a task of its
+// own, so every final URL is deduplicated separately.
+type Redirects struct {
+	MaxHops int    `mapstructure:"max_hops"`
+	Scope   string `mapstructure:"scope"`
+	Enqueue bool   `mapstructure:"enqueue"`
+}
+
+// RetryRule is how a failed task is retried. MaxAttempts counts the first
+// attempt. Backoff is "exponential", "linear" or "constant"; the delay
+// starts at BaseDelay, grows by Multiplier or BaseDelay per attempt, stops
+// at MaxDelay and is spread by up to Jitter times itself either way.
+type RetryRule struct {
+	MaxAttempts int           `mapstructure:"max_attempts"`
+	Backoff     string        `mapstructure:"backoff"`
+	BaseDelay   time.Duration `mapstructure:"base_delay"`
+	Multiplier  float64       `mapstructure:"multiplier"`
+	MaxDelay    time.Duration `mapstructure:"max_delay"`
+	Jitter      float64       `mapstructure:"jitter"`
+}
+
+// Retry is the retry policy. A failed task's rule is Default, overridden by
+// the rule of its topic and then by the rule of its error class; zero
+// fields are inherited. A Retry-After header lengthens the delay, up to
+// MaxRetryAfter.
+type Retry struct {
+	Default       RetryRule            `mapstructure:"default"`
+	Topics        map[string]RetryRule `mapstructure:"topics"`
+	Classes       map[string]RetryRule `mapstructure:"classes"`
+	MaxRetryAfter time.Duration        `mapstructure:"max_retry_after"`
 }
 
 type Cache struct {
@@ -46,13 +233,24 @@ type Cache struct {
 }
 
 type Config struct {
-	Metrics        *Metrics `mapstructure:"metrics"`
-	Scripts        *Scripts `mapstructure:"scripts_path"`
-	Workers        Workers  `mapstructure:"workers"`
-	Queue          *Queue   `mapstructure:"queue"`
-	Cache          *Cache   `mapstructure:"cache"`
-	MaxConcurrency int      `mapstructure:"max_concurrency"`
-	DB             *DB      `mapstructure:"db"`
+	Metrics        *Metrics          `mapstructure:"metrics"`
+	Admin          *Admin            `mapstructure:"admin"`
+	Scripts        *Scripts          `mapstructure:"scripts_path"`
+	Workers        Workers           `mapstructure:"workers"`
+	Queue          *Queue            `mapstructure:"queue"`
+	Cache          *Cache            `mapstructure:"cache"`
+	Crawl          *Crawl            `mapstructure:"crawl"`
+	Identity       identity.Identity `mapstructure:"identity"`
+	Response       Response          `mapstructure:"response"`
+	Proxies        Proxies           `mapstructure:"proxies"`
+	DNS            DNS               `mapstructure:"dns"`
+	Politeness     Politeness        `mapstructure:"politeness"`
+	Retry          Retry             `mapstructure:"retry"`
+	Redirects      Redirects         `mapstructure:"redirects"`
+	Sitemaps       Sitemaps          `mapstructure:"sitemaps"`
+	Profiles       []Profile         `mapstructure:"profiles"`
+	MaxConcurrency int               `mapstructure:"max_concurrency"`
+	DB             *DB               `mapstructure:"db"`
 }
 
 func NewConfig() (*Config, error) {
@@ -80,9 +278,66 @@ func NewConfig() (*Config, error) {
 func setDefault() {
 	viper.SetDefault("max_concurrency", 5)
 
+	viper.SetDefault("queue.backend", "redis")
 	viper.SetDefault("queue.stream", "tasks")
 	viper.SetDefault("queue.group_name", "default_group")     // Added default for group_name
 	viper.SetDefault("queue.consumer_id", "default_consumer") // Added default for consumer_id
+	viper.SetDefault("queue.visibility_timeout", "5m")
+	viper.SetDefault("queue.reclaim_interval", "30s")
+	viper.SetDefault("queue.host_delay", "1s")
+	viper.SetDefault("queue.backpressure.policy", "spill")
+	viper.SetDefault("queue.backpressure.high_watermark", 0.9)
+	viper.SetDefault("queue.backpressure.low_watermark", 0.7)
+	viper.SetDefault("queue.backpressure.block_timeout", "30s")
+
+	viper.SetDefault("admin.port", "127.0.0.1:2113")
+
+	viper.SetDefault("workers.task_timeout", "1m")
+	viper.SetDefault("workers.shutdown_timeout", "30s")
+	viper.SetDefault("workers.autoscale.enabled", false)
+	viper.SetDefault("workers.autoscale.interval", "10s")
+	viper.SetDefault("workers.autoscale.backlog_per_worker", 50)
+	viper.SetDefault("workers.autoscale.idle_threshold", 0.5)
+	viper.SetDefault("workers.autoscale.max_latency", "0s")
+
+	viper.SetDefault("crawl.max_depth", 0)
+
+	viper.SetDefault("identity.product_token", identity.DefaultProductToken)
+
+	viper.SetDefault("retry.default.max_attempts", 5)
+	viper.SetDefault("retry.default.backoff", "exponential")
+	viper.SetDefault("retry.default.base_delay", "2s")
+	viper.SetDefault("retry.default.multiplier", 2)
+	viper.SetDefault("retry.default.max_delay", "10m")
+	viper.SetDefault("retry.default.jitter", 0.2)
+	viper.SetDefault("retry.max_retry_after", "1h")
+
+	viper.SetDefault("sitemaps.max_depth", 2)
+	viper.SetDefault("sitemaps.max_files", 100)
+	viper.SetDefault("sitemaps.max_urls", 200000)
+
+	viper.SetDefault("redirects.max_hops", 10)
+	viper.SetDefault("redirects.scope", "any")
+	viper.SetDefault("redirects.enqueue", false)
+
+	viper.SetDefault("dns.cache", true)
+	viper.SetDefault("dns.min_ttl", "30s")
+	viper.SetDefault("dns.max_ttl", "10m")
+	viper.SetDefault("dns.negative_ttl", "30s")
+	viper.SetDefault("dns.max_entries", 10000)
+
+	viper.SetDefault("politeness.by_ip", false)
+	viper.SetDefault("politeness.max_concurrent", 0)
+	viper.SetDefault("politeness.min_interval", "0s")
+
+	viper.SetDefault("proxies.strategy", "round_robin")
+	viper.SetDefault("proxies.max_failures", 3)
+	viper.SetDefault("proxies.cooldown", "1m")
+
+	viper.SetDefault("response.max_body_bytes", 10<<20)
+	viper.SetDefault("response.allowed_content_types", []string{
+		"text/html", "application/xhtml+xml", "text/plain", "text/xml", "application/xml", "application/gzip", "application/x-gzip",
+	})
 
 	viper.SetDefault("cache.addr", "localhost:9042")
 
@@ -0,0 +1,384 @@
+package dns
+
+import (
+	"container/list"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NesterovYehor/Crawler/internal/config"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	defaultMinTTL      = 30 * time.Second
+	defaultMaxTTL      = 10 * time.Minute
+	defaultNegativeTTL = 30 * time.Second
+	defaultMaxEntries  = 10000
+	queryTimeout       = 5 * time.Second
+	resolvConf         = "/etc/resolv.conf"
+)
+
+var errNoAnswer = errors.New("no addresses in DNS answer")
+
+// lookupFunc resolves host and returns its addresses and how long they
+// may be cached. A zero TTL means the source did not say.
+type lookupFunc func(ctx context.Context, host string) ([]net.IP, time.Duration, error)
+
+type entry struct {
+	host    string
+	elem    *list.Element
+	ips     []net.IP
+	err     error
+	expires time.Time
+	// ready is closed once the lookup that fills the entry is done.
+	ready chan struct{}
+}
+
+// Resolver caches DNS lookups for their TTL, clamped to [MinTTL, MaxTTL].
+// Failed lookups are cached for NegativeTTL. Concurrent lookups of the same
+// host share one query. At most MaxEntries hosts are cached; the least
+// recently used are evicted first.
+type Resolver struct {
+	minTTL      time.Duration
+	maxTTL      time.Duration
+	negativeTTL time.Duration
+	maxEntries  int
+	servers     []string
+	lookup      lookupFunc
+
+	mu    sync.Mutex
+	cache map[string]*entry
+	// lru orders the entries from most to least recently used.
+	lru *list.List
+
+	now func() time.Time
+}
+
+// NewResolver returns a caching resolver that queries the nameservers in
+// /etc/resolv.conf, and the system resolver for names they do not know,
+// such as those in /etc/hosts.
+func NewResolver(cfg *config.DNS) *Resolver {
+	r := &Resolver{
+		minTTL:      cfg.MinTTL,
+		maxTTL:      cfg.MaxTTL,
+		negativeTTL: cfg.NegativeTTL,
+		maxEntries:  cfg.MaxEntries,
+		servers:     nameservers(resolvConf),
+		cache:       make(map[string]*entry),
+		lru:         list.New(),
+		now:         time.Now,
+	}
+	if r.minTTL <= 0 {
+		r.minTTL = defaultMinTTL
+	}
+	if r.maxTTL < r.minTTL {
+		r.maxTTL = max(defaultMaxTTL, r.minTTL)
+	}
+	if r.negativeTTL <= 0 {
+		r.negativeTTL = defaultNegativeTTL
+	}
+	if r.maxEntries <= 0 {
+		r.maxEntries = defaultMaxEntries
+	}
+	r.lookup = r.lookupHost
+	return r
+}
+
+// LookupIP returns the addresses of host. IP literals are returned as they
+// are.
+func (r *Resolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	r.mu.Lock()
+	e, ok := r.cache[host]
+	if ok {
+		r.lru.MoveToFront(e.elem)
+		select {
+		case <-e.ready:
+			if r.now().Before(e.expires) {
+				r.mu.Unlock()
+				return e.ips, e.err
+			}
+			ok = false
+		default:
+		}
+	}
+	if !ok {
+		e = r.add(host)
+		r.mu.Unlock()
+		r.fill(ctx, host, e)
+		return e.ips, e.err
+	}
+	r.mu.Unlock()
+
+	select {
+	case <-e.ready:
+		return e.ips, e.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// add caches a new entry for host, evicting the least recently used ones
+// beyond maxEntries. r.mu must be held.
+func (r *Resolver) add(host string) *entry {
+	if old, ok := r.cache[host]; ok {
+		r.lru.Remove(old.elem)
+	}
+	e := &entry{host: host, ready: make(chan struct{})}
+	e.elem = r.lru.PushFront(e)
+	r.cache[host] = e
+	for r.lru.Len() > r.maxEntries {
+		oldest := r.lru.Remove(r.lru.Back()).(*entry)
+		delete(r.cache, oldest.host)
+	}
+	return e
+}
+
+func (r *Resolver) fill(ctx context.Context, host string, e *entry) {
+	defer close(e.ready)
+	// The shared query must not fail for the other waiters just because
+	// this caller gave up.
+	ips, ttl, err := r.lookup(context.WithoutCancel(ctx), host)
+	if err == nil && len(ips) == 0 {
+		err = errNoAnswer
+	}
+	switch {
+	case err != nil:
+		// Callers classify lookup failures by *net.DNSError.
+		var dnsErr *net.DNSError
+		if !errors.As(err, &dnsErr) {
+			err = &net.DNSError{Err: err.Error(), Name: host, UnwrapErr: err}
+		}
+		e.err = fmt.Errorf("failed to resolve %s: %w", host, err)
+		ttl = r.negativeTTL
+	case ttl < r.minTTL:
+		ttl = r.minTTL
+	case ttl > r.maxTTL:
+		ttl = r.maxTTL
+	}
+	e.ips, e.expires = ips, r.now().Add(ttl)
+}
+
+// DialContext returns a dial function for http.Transport that connects to
+// the cached addresses of the host, in order, until one accepts.
+func (r *Resolver) DialContext(d *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		ips, err := r.LookupIP(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		var firstErr error
+		for _, ip := range ips {
+			conn, err := d.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+			if err == nil {
+				return conn, nil
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		return nil, firstErr
+	}
+}
+
+// lookupHost asks the nameservers for A and AAAA records, whose TTLs are
+// known, and falls back to the system resolver, whose are not.
+func (r *Resolver) lookupHost(ctx context.Context, host string) ([]net.IP, time.Duration, error) {
+	for _, server := range r.servers {
+		ips, ttl, err := queryServer(ctx, server, host)
+		if err == nil {
+			return ips, ttl, nil
+		}
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, 0, err
+	}
+	ips := make([]net.IP, len(addrs))
+	for i, a := range addrs {
+		ips[i] = a.IP
+	}
+	return ips, 0, nil
+}
+
+// queryServer resolves host with one server and returns the smallest TTL
+// of the answers, CNAMEs included.
+func queryServer(ctx context.Context, server, host string) ([]net.IP, time.Duration, error) {
+	var ips []net.IP
+	var ttl uint32
+	found := false
+	for _, qtype := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+		got, t, err := query(ctx, server, host, qtype)
+		if err != nil {
+			// Many servers handle AAAA queries badly; IPv4 alone will do.
+			if qtype == dnsmessage.TypeAAAA && found {
+				break
+			}
+			return nil, 0, err
+		}
+		if len(got) > 0 {
+			if !found || t < ttl {
+				ttl = t
+			}
+			found = true
+			ips = append(ips, got...)
+		}
+	}
+	if !found {
+		return nil, 0, errNoAnswer
+	}
+	return ips, time.Duration(ttl) * time.Second, nil
+}
+
+func query(ctx context.Context, server, host string, qtype dnsmessage.Type) ([]net.IP, uint32, error) {
+	name, err := dnsmessage.NewName(host + ".")
+	if err != nil {
+		return nil, 0, err
+	}
+	id := uint16(rand.Uint32())
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: name, Type: qtype, Class: dnsmessage.ClassINET}},
+	}
+	packed, err := msg.Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+	resp, err := exchange(ctx, "udp", server, packed)
+	if err != nil {
+		return nil, 0, err
+	}
+	var p dnsmessage.Parser
+	h, err := p.Start(resp)
+	if err != nil {
+		return nil, 0, err
+	}
+	// A truncated answer is asked again over TCP, which has no size limit.
+	if h.Truncated {
+		if resp, err = exchange(ctx, "tcp", server, packed); err != nil {
+			return nil, 0, err
+		}
+		if h, err = p.Start(resp); err != nil {
+			return nil, 0, err
+		}
+	}
+	if h.ID != id {
+		return nil, 0, fmt.Errorf("mismatched DNS response id")
+	}
+	if h.RCode != dnsmessage.RCodeSuccess {
+		return nil, 0, fmt.Errorf("DNS query for %s failed: %v", host, h.RCode)
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return nil, 0, err
+	}
+
+	var ips []net.IP
+	var ttl uint32
+	for i := 0; ; i++ {
+		ah, err := p.AnswerHeader()
+		if errors.Is(err, dnsmessage.ErrSectionDone) {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		if i == 0 || ah.TTL < ttl {
+			ttl = ah.TTL
+		}
+		switch ah.Type {
+		case dnsmessage.TypeA:
+			a, err := p.AResource()
+			if err != nil {
+				return nil, 0, err
+			}
+			ips = append(ips, net.IP(a.A[:]))
+		case dnsmessage.TypeAAAA:
+			a, err := p.AAAAResource()
+			if err != nil {
+				return nil, 0, err
+			}
+			ips = append(ips, net.IP(a.AAAA[:]))
+		default:
+			if err := p.SkipAnswer(); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+	return ips, ttl, nil
+}
+
+// exchange sends a packed DNS message to server and returns the response.
+// Over TCP messages are prefixed with their length.
+func exchange(ctx context.Context, network, server string, msg []byte) ([]byte, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if network == "udp" {
+		if _, err := conn.Write(msg); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, 4096)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+
+	framed := binary.BigEndian.AppendUint16(nil, uint16(len(msg)))
+	if _, err := conn.Write(append(framed, msg...)); err != nil {
+		return nil, err
+	}
+	var size [2]byte
+	if _, err := io.ReadFull(conn, size[:]); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, binary.BigEndian.Uint16(size[:]))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// nameservers returns the nameserver addresses listed in a resolv.conf.
+func nameservers(path string) []string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var servers []string
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "nameserver" && net.ParseIP(fields[1]) != nil {
+			servers = append(servers, net.JoinHostPort(fields[1], "53"))
+		}
+	}
+	return servers
+}
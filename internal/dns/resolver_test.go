@@ -0,0 +1,280 @@
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/NesterovYehor/Crawler/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func newTestResolver(lookup lookupFunc) (*Resolver, *time.Time) {
+	r := NewResolver(&config.DNS{MinTTL: time.Minute, MaxTTL: time.Hour, NegativeTTL: 10 * time.Second})
+	r.lookup = lookup
+	now := time.Unix(0, 0)
+	r.now = func() time.Time { return now }
+	return r, &now
+}
+
+func TestResolverCachesForTTL(t *testing.T) {
+	var calls atomic.Int32
+	ttl := 5 * time.Minute
+	r, now := newTestResolver(func(context.Context, string) ([]net.IP, time.Duration, error) {
+		calls.Add(1)
+		return []net.IP{net.ParseIP("10.0.0.1")}, ttl, nil
+	})
+	ctx := context.Background()
+
+	ips, err := r.LookupIP(ctx, "Example.com.")
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.1", ips[0].String())
+	_, err = r.LookupIP(ctx, "example.com")
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, calls.Load(), "names are normalized")
+
+	*now = now.Add(ttl - time.Second)
+	r.LookupIP(ctx, "example.com")
+	assert.EqualValues(t, 1, calls.Load())
+	*now = now.Add(time.Second)
+	r.LookupIP(ctx, "example.com")
+	assert.EqualValues(t, 2, calls.Load(), "expired after the TTL")
+
+	// TTLs are clamped to [MinTTL, MaxTTL].
+	ttl = 0
+	r.LookupIP(ctx, "short.example")
+	*now = now.Add(time.Minute - time.Second)
+	r.LookupIP(ctx, "short.example")
+	assert.EqualValues(t, 3, calls.Load())
+
+	ttl = 24 * time.Hour
+	r.LookupIP(ctx, "long.example")
+	*now = now.Add(time.Hour)
+	r.LookupIP(ctx, "long.example")
+	assert.EqualValues(t, 5, calls.Load())
+
+	ips, err = r.LookupIP(ctx, "192.0.2.1")
+	require.NoError(t, err)
+	assert.Equal(t, "192.0.2.1", ips[0].String())
+	assert.EqualValues(t, 5, calls.Load(), "IP literals are not looked up")
+}
+
+func TestResolverCachesFailures(t *testing.T) {
+	var calls atomic.Int32
+	failure := errors.New("no such host")
+	r, now := newTestResolver(func(context.Context, string) ([]net.IP, time.Duration, error) {
+		calls.Add(1)
+		return nil, 0, failure
+	})
+	ctx := context.Background()
+
+	_, err := r.LookupIP(ctx, "missing.example")
+	assert.ErrorIs(t, err, failure)
+	*now = now.Add(9 * time.Second)
+	_, err = r.LookupIP(ctx, "missing.example")
+	assert.ErrorIs(t, err, failure)
+	assert.EqualValues(t, 1, calls.Load())
+
+	*now = now.Add(time.Second)
+	r.LookupIP(ctx, "missing.example")
+	assert.EqualValues(t, 2, calls.Load(), "failures expire after NegativeTTL")
+}
+
+func TestResolverSharesLookups(t *testing.T) {
+	var calls atomic.Int32
+	unblock := make(chan struct{})
+	r, _ := newTestResolver(func(context.Context, string) ([]net.IP, time.Duration, error) {
+		calls.Add(1)
+		<-unblock
+		return []net.IP{net.ParseIP("10.0.0.1")}, time.Minute, nil
+	})
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ips, err := r.LookupIP(context.Background(), "example.com")
+			assert.NoError(t, err)
+			assert.Len(t, ips, 1)
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(unblock)
+	wg.Wait()
+	assert.EqualValues(t, 1, calls.Load())
+}
+
+func TestResolverEvictsLeastRecentlyUsed(t *testing.T) {
+	var calls atomic.Int32
+	r, _ := newTestResolver(func(context.Context, string) ([]net.IP, time.Duration, error) {
+		calls.Add(1)
+		return []net.IP{net.ParseIP("10.0.0.1")}, time.Hour, nil
+	})
+	r.maxEntries = 2
+	ctx := context.Background()
+
+	r.LookupIP(ctx, "a.example")
+	r.LookupIP(ctx, "b.example")
+	r.LookupIP(ctx, "a.example")
+	r.LookupIP(ctx, "c.example")
+	assert.Len(t, r.cache, 2)
+	assert.EqualValues(t, 3, calls.Load())
+
+	r.LookupIP(ctx, "a.example")
+	assert.EqualValues(t, 3, calls.Load(), "a was used more recently than b")
+	r.LookupIP(ctx, "b.example")
+	assert.EqualValues(t, 4, calls.Load(), "b was evicted")
+}
+
+func TestQueryServerFallsBackToTCP(t *testing.T) {
+	addr := startTruncatingDNSServer(t, [4]byte{10, 0, 0, 3})
+	ips, _, err := queryServer(context.Background(), addr, "example.com")
+	require.NoError(t, err)
+	require.Len(t, ips, 1)
+	assert.Equal(t, "10.0.0.3", ips[0].String())
+}
+
+func TestQueryServer(t *testing.T) {
+	addr := startDNSServer(t, map[dnsmessage.Type][]dnsmessage.Resource{
+		dnsmessage.TypeA: {
+			{
+				Header: dnsmessage.ResourceHeader{Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 300},
+				Body:   &dnsmessage.AResource{A: [4]byte{10, 0, 0, 1}},
+			},
+			{
+				Header: dnsmessage.ResourceHeader{Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 120},
+				Body:   &dnsmessage.AResource{A: [4]byte{10, 0, 0, 2}},
+			},
+		},
+	})
+
+	ips, ttl, err := queryServer(context.Background(), addr, "example.com")
+	require.NoError(t, err)
+	require.Len(t, ips, 2)
+	assert.Equal(t, "10.0.0.1", ips[0].String())
+	assert.Equal(t, "10.0.0.2", ips[1].String())
+	assert.Equal(t, 120*time.Second, ttl, "the smallest TTL wins")
+
+	empty := startDNSServer(t, nil)
+	_, _, err = queryServer(context.Background(), empty, "example.com")
+	assert.ErrorIs(t, err, errNoAnswer)
+}
+
+func TestNameservers(t *testing.T) {
+	path := t.TempDir() + "/resolv.conf"
+	require.NoError(t, os.WriteFile(path, []byte("# comment\nnameserver 10.0.0.53\nsearch example.com\nnameserver ::1\nnameserver bogus\n"), 0o644))
+	assert.Equal(t, []string{"10.0.0.53:53", "[::1]:53"}, nameservers(path))
+	assert.Empty(t, nameservers(path+".missing"))
+}
+
+// startDNSServer answers queries over UDP with the records for their type.
+func startDNSServer(t *testing.T, records map[dnsmessage.Type][]dnsmessage.Resource) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var req dnsmessage.Message
+			if err := req.Unpack(buf[:n]); err != nil || len(req.Questions) != 1 {
+				continue
+			}
+			q := req.Questions[0]
+			resp := dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: req.ID, Response: true, RecursionAvailable: true},
+				Questions: req.Questions,
+			}
+			for _, rr := range records[q.Type] {
+				rr.Header.Name = q.Name
+				resp.Answers = append(resp.Answers, rr)
+			}
+			packed, err := resp.Pack()
+			if err != nil {
+				continue
+			}
+			conn.WriteTo(packed, from)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+// startTruncatingDNSServer answers A queries with a truncated response over
+// UDP and in full over TCP on the same port.
+func startTruncatingDNSServer(t *testing.T, a [4]byte) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	conn, err := net.ListenPacket("udp", ln.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	answer := func(req dnsmessage.Message, truncated bool) []byte {
+		resp := dnsmessage.Message{
+			Header:    dnsmessage.Header{ID: req.ID, Response: true, Truncated: truncated},
+			Questions: req.Questions,
+		}
+		if !truncated && req.Questions[0].Type == dnsmessage.TypeA {
+			resp.Answers = []dnsmessage.Resource{{
+				Header: dnsmessage.ResourceHeader{Name: req.Questions[0].Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+				Body:   &dnsmessage.AResource{A: a},
+			}}
+		}
+		packed, _ := resp.Pack()
+		return packed
+	}
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var req dnsmessage.Message
+			if req.Unpack(buf[:n]) == nil && len(req.Questions) == 1 {
+				conn.WriteTo(answer(req, true), from)
+			}
+		}
+	}()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				var size [2]byte
+				if _, err := io.ReadFull(c, size[:]); err != nil {
+					return
+				}
+				buf := make([]byte, binary.BigEndian.Uint16(size[:]))
+				if _, err := io.ReadFull(c, buf); err != nil {
+					return
+				}
+				var req dnsmessage.Message
+				if req.Unpack(buf) != nil || len(req.Questions) != 1 {
+					return
+				}
+				packed := answer(req, false)
+				c.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(packed))), packed...))
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
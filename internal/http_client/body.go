@@ -0,0 +1,69 @@
+package httpclient
+
+import (
+	"io"
+	"mime"
+	"strings"
+)
+
+const defaultMaxBodyBytes = 10 << 20
+
+// limitedBody reads at most limit bytes of a response body. Reading past
+// the limit reports io.EOF and marks the body as truncated. onClose, if
+// set, runs once the body is closed.
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+	truncated bool
+	onClose   func()
+}
+
+func (b *limitedBody) Close() error {
+	err := b.ReadCloser.Close()
+	if b.onClose != nil {
+		b.onClose()
+		b.onClose = nil
+	}
+	return err
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		// One more byte tells a body of exactly the limit from a longer one.
+		if !b.truncated {
+			var one [1]byte
+			n, _ := io.ReadFull(b.ReadCloser, one[:])
+			b.truncated = n > 0
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	return n, err
+}
+
+// contentTypeAllowed reports whether the media type of a Content-Type
+// header matches one of allowed. A response without the header and an
+// empty list are allowed.
+func contentTypeAllowed(header string, allowed []string) bool {
+	if header == "" || len(allowed) == 0 {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(header)
+	if err != nil {
+		return false
+	}
+	for _, a := range allowed {
+		a = strings.ToLower(a)
+		if a == mediaType {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(a, "/*"); ok && strings.HasPrefix(mediaType, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,101 @@
+package httpclient
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"mime"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/transform"
+)
+
+// sniffLen is how much of a body is inspected for a BOM or meta charset.
+const sniffLen = 1024
+
+// transcodedTypes are the media types whose bodies are converted to UTF-8.
+// XML declares its own encoding, which the decoder would then contradict.
+var transcodedTypes = map[string]bool{
+	"text/html":             true,
+	"application/xhtml+xml": true,
+	"text/plain":            true,
+}
+
+// decodeBody returns body converted to UTF-8 and the name of the charset it
+// was in. The charset comes from a BOM, the Content-Type header or a meta
+// tag in the first kilobyte. Without any of them a body that is not plain
+// ASCII is tested for valid UTF-8 and otherwise read as windows-1252.
+func decodeBody(body io.Reader, contentType string) (io.Reader, string) {
+	br := bufio.NewReaderSize(body, sniffLen)
+	peek, _ := br.Peek(sniffLen)
+
+	enc, name, certain := charset.DetermineEncoding(peek, contentType)
+	// A page that starts out ASCII and declares nothing is far more likely
+	// UTF-8 than windows-1252, the HTML spec's legacy default. A meta tag
+	// declaring windows-1252 or latin1 yields the same answer, so it is
+	// looked for separately.
+	if !certain && name == "windows-1252" && isASCII(peek) && !declaresCharset(peek) {
+		return br, "utf-8"
+	}
+	if enc == encoding.Nop || name == "utf-8" {
+		return br, "utf-8"
+	}
+	return transform.NewReader(br, enc.NewDecoder()), name
+}
+
+func transcoded(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && transcodedTypes[mediaType]
+}
+
+// declaresCharset reports whether head has a meta tag declaring a known
+// charset, either with a charset attribute or as an http-equiv
+// Content-Type.
+func declaresCharset(head []byte) bool {
+	z := html.NewTokenizer(bytes.NewReader(head))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return false
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			if string(name) != "meta" {
+				continue
+			}
+			var httpEquiv, content string
+			for hasAttr {
+				var key, val []byte
+				key, val, hasAttr = z.TagAttr()
+				switch string(key) {
+				case "charset":
+					if enc, _ := charset.Lookup(string(val)); enc != nil {
+						return true
+					}
+				case "http-equiv":
+					httpEquiv = string(val)
+				case "content":
+					content = string(val)
+				}
+			}
+			if strings.EqualFold(httpEquiv, "content-type") {
+				if _, params, err := mime.ParseMediaType(content); err == nil {
+					if enc, _ := charset.Lookup(params["charset"]); enc != nil {
+						return true
+					}
+				}
+			}
+		}
+	}
+}
+
+func isASCII(b []byte) bool {
+	for _, c := range b {
+		if c >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
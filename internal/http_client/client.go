@@ -1,41 +1,149 @@
 package httpclient
 
 import (
+	"context"
 	"crypto/tls"
-	"encoding/xml"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/NesterovYehor/Crawler/internal/config"
+	"github.com/NesterovYehor/Crawler/internal/dns"
+	"github.com/NesterovYehor/Crawler/internal/identity"
+	"github.com/NesterovYehor/Crawler/internal/politeness"
+	"github.com/NesterovYehor/Crawler/internal/profiles"
+	"github.com/NesterovYehor/Crawler/internal/proxy"
+	"github.com/NesterovYehor/Crawler/internal/sitemap"
 	"github.com/NesterovYehor/Crawler/internal/utils"
 	"github.com/temoto/robotstxt"
 )
 
-type Urlset struct {
-	URLs []URL `xml:"url"`
+// FetchResult is the response to a fetch. Body is nil when Fetch returns an
+// error; otherwise the caller must close it. Body ends at the client's size
+// limit; Truncated tells whether there was more. HTML and plain text bodies
+// are converted to UTF-8 from Charset, the encoding they were sent in.
+// FinalURL is where the response came from; Redirects lists the URLs that
+// redirected to it, starting with URL. RedirectTo is the target of a
+// redirect that was not followed.
+type FetchResult struct {
+	URL         string
+	FinalURL    string
+	Redirects   []string
+	RedirectTo  string
+	StatusCode  int
+	Header      http.Header
+	ContentType string
+	Body        io.ReadCloser
+	// ContentLength is the body length the response declared, at most the
+	// size limit, or -1 if it declared none. A converted body may differ
+	// from it.
+	ContentLength int64
+	FetchedAt     time.Time
+	// Duration is the time until the response headers arrived.
+	Duration time.Duration
+	// ETag and LastModified are the validators to send when the page is
+	// fetched again.
+	ETag         string
+	LastModified string
+	Charset      string
+
+	limit *limitedBody
+}
+
+// Validators are the ETag and Last-Modified values of an earlier response.
+// A conditional fetch sends them as If-None-Match and If-Modified-Since.
+type Validators struct {
+	ETag         string
+	LastModified string
+}
+
+func (v Validators) IsZero() bool {
+	return v.ETag == "" && v.LastModified == ""
+}
+
+// Truncated reports whether the body was cut off at the size limit. It is
+// only known once the body has been read to its end.
+func (r *FetchResult) Truncated() bool {
+	return r.limit != nil && r.limit.truncated
 }
 
-type URL struct {
-	Loc string `xml:"loc"`
+// Close closes the body, if there is one.
+func (r *FetchResult) Close() error {
+	if r == nil || r.Body == nil {
+		return nil
+	}
+	return r.Body.Close()
 }
 
 type Interface interface {
-	FetchBody(rawURL string) (io.ReadCloser, error)
-	FetchRules(baseURL string) ([]byte, bool, []string, error)
+	// Fetch GETs rawURL, following the redirects the redirect policy
+	// allows. A redirect it stops returns a *utils.RedirectError. Responses with status 408, 429
+	// or 5xx return utils.ErrRetryLater and other 4xx responses an invalid
+	// status code error, along with the result so the status and headers can
+	// be inspected. So does a response whose content type is not allowed,
+	// with utils.ErrInvalidContentType.
+	Fetch(ctx context.Context, rawURL string) (*FetchResult, error)
+	// FetchIfModified is a conditional Fetch. A 304 response returns
+	// utils.ErrNotModified along with the result.
+	FetchIfModified(ctx context.Context, rawURL string, v Validators) (*FetchResult, error)
+	// FetchRules fetches the robots.txt of baseURL and reads the sitemaps
+	// it lists, or /sitemap.xml if it lists none. A robots.txt answered
+	// with a 4xx status has no rules. The bool reports whether a failure
+	// is worth retrying.
+	FetchRules(ctx context.Context, baseURL string) ([]byte, bool, []sitemap.Entry, error)
 }
 
 type HTTP struct {
-	client    *http.Client
-	Benchmark []time.Duration
-	Count     int
+	client       *http.Client
+	maxBodyBytes int64
+	allowedTypes []string
+	proxies      *proxy.Pool
+	resolver     *dns.Resolver
+	origins      *politeness.OriginLimiter
+	profiles     *profiles.Set
+	redirects    *RedirectPolicy
+	sitemaps     config.Sitemaps
+}
+
+type ClientOpts struct {
+	IdleConns int
+	Response  config.Response
+	// Proxies routes requests through a proxy pool instead of the proxy
+	// set in the environment.
+	Proxies *proxy.Pool
+	// Resolver, if set, resolves the hosts the client connects to.
+	Resolver *dns.Resolver
+	// Origins, if set, limits the requests to each host, and to each server
+	// IP if it limits by IP. The limits hold until the body is closed.
+	Origins *politeness.OriginLimiter
+	// Profiles, if set, adds the headers, credentials and cookies of the
+	// request profile of each host.
+	Profiles *profiles.Set
+	// Redirects decides which redirects are followed. Nil follows up to 10
+	// to any host.
+	Redirects *RedirectPolicy
+	// Sitemaps bounds the sitemaps FetchRules reads; zero limits take
+	// sitemap.Defaults.
+	Sitemaps config.Sitemaps
 }
 
 func NewHTTPClient(idleConns int) Interface {
+	return NewClient(&ClientOpts{IdleConns: idleConns})
+}
+
+// NewClient returns a client that enforces the response limits in opts. A
+// MaxBodyBytes of zero means 10 MiB.
+func NewClient(opts *ClientOpts) Interface {
+	idleConns := opts.IdleConns
 	dialer := &net.Dialer{
 		Timeout:   10 * time.Second,
 		KeepAlive: 30 * time.Second,
@@ -57,119 +165,337 @@ func NewHTTPClient(idleConns int) Interface {
 		},
 	}
 
-	return &HTTP{
-		client: &http.Client{
-			Timeout:   15 * time.Second, // Keep this at 15 seconds
-			Transport: transport,
-		},
+	if opts.Proxies != nil {
+		transport.Proxy = opts.Proxies.ProxyFunc()
+		transport.OnProxyConnectResponse = opts.Proxies.OnConnectResponse
+	}
+	if opts.Resolver != nil {
+		transport.DialContext = opts.Resolver.DialContext(dialer)
 	}
+
+	maxBodyBytes := opts.Response.MaxBodyBytes
+	if maxBodyBytes <= 0 {
+		maxBodyBytes = defaultMaxBodyBytes
+	}
+	client := &http.Client{
+		Timeout:   15 * time.Second, // Keep this at 15 seconds
+		Transport: transport,
+	}
+	redirects := opts.Redirects
+	if redirects == nil {
+		redirects = &RedirectPolicy{maxHops: defaultMaxRedirects, scope: RedirectScopeAny}
+	}
+	c := &HTTP{
+		client:       client,
+		maxBodyBytes: maxBodyBytes,
+		allowedTypes: opts.Response.AllowedContentTypes,
+		proxies:      opts.Proxies,
+		resolver:     opts.Resolver,
+		origins:      opts.Origins,
+		profiles:     opts.Profiles,
+		redirects:    redirects,
+		sitemaps:     opts.Sitemaps,
+	}
+	client.CheckRedirect = c.checkRedirect
+	return c
+}
+
+func (c *HTTP) Fetch(ctx context.Context, rawURL string) (*FetchResult, error) {
+	return c.FetchIfModified(ctx, rawURL, Validators{})
 }
 
-func (c *HTTP) FetchBody(rawURL string) (io.ReadCloser, error) {
+func (c *HTTP) FetchIfModified(ctx context.Context, rawURL string, v Validators) (*FetchResult, error) {
+	return c.fetch(ctx, rawURL, v, fetchOpts{maxBodyBytes: c.maxBodyBytes, allowedTypes: c.allowedTypes})
+}
+
+// fetchOpts are the response limits of one fetch.
+type fetchOpts struct {
+	maxBodyBytes int64
+	allowedTypes []string
+}
+
+func (c *HTTP) fetch(ctx context.Context, rawURL string, v Validators, opts fetchOpts) (*FetchResult, error) {
 	start := time.Now()
-	req, err := http.NewRequest("GET", rawURL, nil)
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/125.0.0.0 Safari/537.36")
+	reqCtx, reportProxy := ctx, func(bool, time.Duration) {}
+	if c.proxies != nil {
+		reqCtx, reportProxy = c.proxies.Track(ctx)
+	}
+	req, err := http.NewRequestWithContext(reqCtx, "GET", rawURL, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request for %s: %w", rawURL, err)
 	}
+	identity.FromContext(ctx).SetHeaders(req.Header)
+	client := c.client
+	if c.profiles != nil {
+		c.profiles.Apply(req)
+		// Clients with a jar share the transport and so its connections.
+		if jar := c.profiles.Jar(ctx); jar != nil {
+			withJar := *c.client
+			withJar.Jar = jar
+			client = &withJar
+		}
+	}
+	if v.ETag != "" {
+		req.Header.Set("If-None-Match", v.ETag)
+	}
+	if v.LastModified != "" {
+		req.Header.Set("If-Modified-Since", v.LastModified)
+	}
+
+	req, release, err := c.limitOrigin(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", rawURL, err)
+	}
 
-	resp, err := c.client.Do(req)
+	resp, err := client.Do(req)
+	// A request cut off by its own context says nothing about the proxy.
+	status := 0
+	if err == nil {
+		status = resp.StatusCode
+	}
+	reportProxy(ctx.Err() == nil && proxy.Failed(err, status), time.Since(start))
 	if err != nil {
+		release()
 		return nil, fmt.Errorf("failed to fetch %s: %w", rawURL, err)
 	}
 
-	c.Benchmark = append(c.Benchmark, time.Since(start))
+	limit := &limitedBody{ReadCloser: resp.Body, remaining: opts.maxBodyBytes, onClose: release}
+	res := &FetchResult{
+		URL:           rawURL,
+		FinalURL:      resp.Request.URL.String(),
+		Redirects:     redirectChain(resp),
+		StatusCode:    resp.StatusCode,
+		Header:        resp.Header,
+		ContentType:   resp.Header.Get("Content-Type"),
+		Body:          limit,
+		ContentLength: min(resp.ContentLength, opts.maxBodyBytes),
+		FetchedAt:     start,
+		Duration:      time.Since(start),
+		ETag:          resp.Header.Get("ETag"),
+		LastModified:  resp.Header.Get("Last-Modified"),
+		limit:         limit,
+	}
 
-	if resp.StatusCode == 408 || resp.StatusCode == 429 {
-		return nil, utils.ErrRetryLater
+	if redirect := c.stoppedRedirect(ctx, req.URL, resp, len(res.Redirects)); redirect != nil {
+		limit.Close()
+		res.Body = nil
+		res.RedirectTo = redirect.To
+		return res, redirect
+	}
+	if resp.StatusCode == http.StatusNotModified {
+		limit.Close()
+		res.Body = nil
+		return res, utils.ErrNotModified
+	}
+	if err := statusError(resp); err != nil {
+		limit.Close()
+		res.Body = nil
+		return res, err
 	}
-	if resp.StatusCode >= 400 && resp.StatusCode <= 499 {
-		return nil, utils.ErrInvalidStatusCode(resp.StatusCode)
+	// The body of an unwanted type is never read.
+	if !contentTypeAllowed(res.ContentType, opts.allowedTypes) {
+		limit.Close()
+		res.Body = nil
+		return res, fmt.Errorf("%w: %q", utils.ErrInvalidContentType, res.ContentType)
+	}
+	if transcoded(res.ContentType) {
+		var decoded io.Reader
+		decoded, res.Charset = decodeBody(limit, res.ContentType)
+		res.Body = struct {
+			io.Reader
+			io.Closer
+		}{decoded, limit}
 	}
 
-	if time.Since(start) >= 500*time.Millisecond {
-		fmt.Println("URL TOOK:", time.Since(start))
-		c.Count++
+	if res.Duration >= 500*time.Millisecond {
+		slog.DebugContext(ctx, "slow response", "url", res.FinalURL, "duration", res.Duration)
 	}
 
-	return resp.Body, nil
+	return res, nil
 }
 
-func (c *HTTP) FetchRules(baseURL string) ([]byte, bool, []string, error) {
-	rulesUrl, err := url.Parse(baseURL + "/robots.txt")
-	if err != nil {
-		return nil, false, nil, err
+// limitOrigin waits for the origin limit of the request's host. When
+// limiting by IP it also arranges for the limit of the server IP to be
+// taken once the connection is known: the IP the transport dialed, or for
+// a proxied request, which the proxy dials, the first address the host
+// resolves to. Redirects are not limited again. The returned function
+// releases both limits.
+func (c *HTTP) limitOrigin(ctx context.Context, req *http.Request) (*http.Request, func(), error) {
+	if c.origins == nil {
+		return req, func() {}, nil
 	}
-	rulesUrl.Scheme = "https"
-
-	resp, err := c.FetchBody(rulesUrl.String())
+	host := req.URL.Hostname()
+	release, err := c.origins.Acquire(ctx, "host:"+host)
 	if err != nil {
-		fmt.Println(rulesUrl.String())
-		if errors.Is(err, utils.ErrRetryLater) {
-			return nil, true, nil, nil
-		}
-		return nil, false, nil, err
+		return nil, nil, err
 	}
-	defer func() {
-		if err := resp.Close(); err != nil {
-			slog.Error(err.Error())
-		}
-	}()
-	body, err := io.ReadAll(resp)
-	if err != nil {
-		return nil, false, nil, fmt.Errorf("failed to read response body: %w", err)
+	if !c.origins.ByIP() {
+		return req, release, nil
 	}
 
-	rules, err := robotstxt.FromBytes(body)
-	if err != nil {
-		return nil, false, nil, err
+	lease := &originLease{releases: []func(){release}}
+	proxied := c.proxied(req)
+	var once sync.Once
+	trace := &httptrace.ClientTrace{GotConn: func(info httptrace.GotConnInfo) {
+		once.Do(func() {
+			ip := remoteIP(info.Conn)
+			if proxied || ip == nil {
+				var err error
+				// A failed lookup is reported by the request itself.
+				if ip, err = c.lookupIP(ctx, host); err != nil {
+					return
+				}
+			}
+			if release, err := c.origins.Acquire(ctx, "ip:"+ip.String()); err == nil {
+				lease.add(release)
+			}
+		})
+	}}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace)), lease.release, nil
+}
+
+// proxied reports whether req goes through a proxy.
+func (c *HTTP) proxied(req *http.Request) bool {
+	if c.proxies != nil {
+		return true
 	}
-	if len(rules.Sitemaps) != 0 {
-		urls, err := c.fetchSitemapURLs(rules.Sitemaps)
-		if err != nil {
-			return nil, false, nil, err
-		}
-		return body, false, urls, nil
+	u, err := http.ProxyFromEnvironment(req)
+	return err == nil && u != nil
+}
+
+func remoteIP(conn net.Conn) net.IP {
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		return addr.IP
 	}
+	return nil
+}
 
-	return body, false, nil, nil
+// originLease holds the origin limits taken for one fetch.
+type originLease struct {
+	mu       sync.Mutex
+	releases []func()
+	released bool
 }
 
-func (c *HTTP) fetchSitemapURLs(urls []string) ([]string, error) {
-	if len(urls) == 0 {
-		return nil, utils.ErrNoURLsProvided
+func (l *originLease) add(release func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.released {
+		release()
+		return
 	}
+	l.releases = append(l.releases, release)
+}
 
-	var allURLs []string
+func (l *originLease) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, release := range l.releases {
+		release()
+	}
+	l.releases, l.released = nil, true
+}
 
-	for _, sitemapURL := range urls {
-		resp, err := c.FetchBody(sitemapURL)
+func (c *HTTP) lookupIP(ctx context.Context, host string) (net.IP, error) {
+	if c.resolver != nil {
+		ips, err := c.resolver.LookupIP(ctx, host)
 		if err != nil {
 			return nil, err
 		}
-		defer func() {
-			if closer, ok := resp.(io.Closer); ok {
-				if err := closer.Close(); err != nil {
-					slog.Error(err.Error())
-				}
-			}
-		}()
+		return ips[0], nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	return addrs[0].IP, nil
+}
 
-		body, err := io.ReadAll(resp)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read response body: %w", err)
-		}
+// statusError returns a *utils.StatusError for a 4xx or 5xx response,
+// with the delay its Retry-After header asks for.
+func statusError(resp *http.Response) error {
+	if resp.StatusCode < 400 {
+		return nil
+	}
+	return &utils.StatusError{Code: resp.StatusCode, RetryAfter: retryAfter(resp.Header.Get("Retry-After"), time.Now())}
+}
 
-		var urlset Urlset
-		if err := xml.Unmarshal(body, &urlset); err != nil {
-			fmt.Printf("error decoding XML from %s: %v\n", sitemapURL, err)
-			return nil, nil
-		}
+// retryAfter parses a Retry-After value, either a number of seconds or an
+// HTTP date.
+func retryAfter(v string, now time.Time) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(strings.TrimSpace(v)); err == nil {
+		return max(time.Duration(secs)*time.Second, 0)
+	}
+	if at, err := http.ParseTime(v); err == nil {
+		return max(at.Sub(now), 0)
+	}
+	return 0
+}
+
+func (c *HTTP) FetchRules(ctx context.Context, baseURL string) ([]byte, bool, []sitemap.Entry, error) {
+	rulesUrl, err := url.Parse(baseURL + "/robots.txt")
+	if err != nil {
+		return nil, false, nil, err
+	}
+	rulesUrl.Scheme = "https"
 
-		for _, u := range urlset.URLs {
-			allURLs = append(allURLs, u.Loc)
+	body, err := c.fetchRobots(ctx, rulesUrl.String())
+	var statusErr *utils.StatusError
+	switch {
+	case errors.As(err, &statusErr) && statusErr.Code < 500 && !utils.Retryable(err):
+		// A robots.txt the site does not serve places no restrictions, and
+		// its sitemaps are still worth looking for.
+		body = nil
+	case err != nil:
+		return nil, utils.Retryable(err), nil, err
+	}
+
+	rules, err := robotstxt.FromBytes(body)
+	if err != nil {
+		return nil, false, nil, fmt.Errorf("%w: %w", utils.ErrInvalidRobots, err)
+	}
+
+	roots, logLevel := rules.Sitemaps, slog.LevelWarn
+	if len(roots) == 0 {
+		// Most sites without a Sitemap line have none, so the fallback
+		// failing is not worth a warning.
+		roots = []string{strings.TrimSuffix(rulesUrl.String(), "/robots.txt") + "/sitemap.xml"}
+		logLevel = slog.LevelDebug
+	}
+	entries, err := sitemap.Collect(ctx, c.fetchSitemap, roots, c.sitemaps)
+	if err != nil {
+		slog.Log(ctx, logLevel, "failed to read sitemaps", "domain", baseURL, "error", err)
+	}
+	return body, false, entries, nil
+}
+
+func (c *HTTP) fetchRobots(ctx context.Context, rawURL string) ([]byte, error) {
+	res, err := c.Fetch(ctx, rawURL)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := res.Close(); err != nil {
+			slog.Error(err.Error())
 		}
+	}()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
 	}
+	return body, nil
+}
 
-	return allURLs, nil
+// fetchSitemap returns the body of a sitemap, of any content type. The body
+// may run one byte past the protocol's size limit, so that the parser can
+// tell a file at the limit from one beyond it.
+func (c *HTTP) fetchSitemap(ctx context.Context, rawURL string) (io.ReadCloser, error) {
+	res, err := c.fetch(ctx, rawURL, Validators{}, fetchOpts{maxBodyBytes: sitemap.MaxFileBytes + 1})
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
 }
@@ -0,0 +1,166 @@
+package httpclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/NesterovYehor/Crawler/internal/config"
+	"github.com/NesterovYehor/Crawler/internal/utils"
+	"golang.org/x/net/publicsuffix"
+)
+
+// Redirect scopes limit where a fetch may be redirected to, relative to the
+// URL it started at.
+const (
+	RedirectScopeAny      = "any"
+	RedirectScopeSameHost = "same_host"
+	RedirectScopeSameSite = "same_site"
+)
+
+// Reasons a redirect is not followed, as set on utils.RedirectError.
+const (
+	RedirectMaxHops  = "max_hops"
+	RedirectOffScope = "off_scope"
+	RedirectOffJob   = "off_job"
+	// RedirectQueued is a redirect handed back to a caller that queues its
+	// target as a task of its own.
+	RedirectQueued = "queued"
+)
+
+const defaultMaxRedirects = 10
+
+// RedirectPolicy decides which redirects a fetch follows.
+type RedirectPolicy struct {
+	maxHops int
+	scope   string
+	enqueue bool
+}
+
+// NewRedirectPolicy returns the policy described by cfg. A MaxHops of zero
+// means 10 and an empty Scope any.
+func NewRedirectPolicy(cfg config.Redirects) (*RedirectPolicy, error) {
+	p := &RedirectPolicy{maxHops: cfg.MaxHops, scope: cfg.Scope, enqueue: cfg.Enqueue}
+	if p.maxHops < 0 {
+		return nil, fmt.Errorf("negative redirect max_hops %d", p.maxHops)
+	}
+	if p.maxHops == 0 {
+		p.maxHops = defaultMaxRedirects
+	}
+	switch p.scope {
+	case "":
+		p.scope = RedirectScopeAny
+	case RedirectScopeAny, RedirectScopeSameHost, RedirectScopeSameSite:
+	default:
+		return nil, fmt.Errorf("unknown redirect scope %q", p.scope)
+	}
+	return p, nil
+}
+
+// stop returns why the hop-th redirect of a fetch that started at first,
+// to to, is not followed, or "" if it is.
+func (p *RedirectPolicy) stop(ctx context.Context, first, to *url.URL, hop int) string {
+	switch {
+	case p.enqueue && queuesRedirects(ctx):
+		return RedirectQueued
+	case hop > p.maxHops:
+		return RedirectMaxHops
+	case !p.inScope(first, to):
+		return RedirectOffScope
+	}
+	if inScope, ok := ctx.Value(scopeKey{}).(func(string) bool); ok && !inScope(to.String()) {
+		return RedirectOffJob
+	}
+	return ""
+}
+
+func (p *RedirectPolicy) inScope(first, to *url.URL) bool {
+	switch p.scope {
+	case RedirectScopeSameHost:
+		return strings.EqualFold(first.Hostname(), to.Hostname())
+	case RedirectScopeSameSite:
+		return site(first.Hostname()) == site(to.Hostname())
+	}
+	return true
+}
+
+// site returns the registrable domain of host, or host itself for IPs and
+// names without a public suffix.
+func site(host string) string {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if s, err := publicsuffix.EffectiveTLDPlusOne(host); err == nil {
+		return s
+	}
+	return host
+}
+
+// checkRedirect is the client's CheckRedirect. A redirect the policy stops
+// is not an error: the redirect response itself is returned, and
+// FetchIfModified reports it.
+func (c *HTTP) checkRedirect(req *http.Request, via []*http.Request) error {
+	if c.redirects.stop(req.Context(), via[0].URL, req.URL, len(via)) != "" {
+		return http.ErrUseLastResponse
+	}
+	if c.profiles != nil {
+		return c.profiles.CheckRedirect(req, via)
+	}
+	return nil
+}
+
+// stoppedRedirect describes a redirect response the client did not follow,
+// or returns nil if resp is not one. first is the URL the fetch
+// started at and hops the redirects it followed.
+func (c *HTTP) stoppedRedirect(ctx context.Context, first *url.URL, resp *http.Response, hops int) *utils.RedirectError {
+	switch resp.StatusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		return nil
+	}
+	to, err := resp.Location()
+	if err != nil {
+		return nil
+	}
+	reason := c.redirects.stop(ctx, first, to, hops+1)
+	if reason == "" {
+		return nil
+	}
+	return &utils.RedirectError{To: to.String(), Reason: reason}
+}
+
+// redirectChain returns the URLs that redirected the request of resp, from
+// the first one on.
+func redirectChain(resp *http.Response) []string {
+	var chain []string
+	for r := resp.Request.Response; r != nil; r = r.Request.Response {
+		chain = append(chain, r.Request.URL.String())
+	}
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+	return chain
+}
+
+type scopeKey struct{}
+
+// WithScope returns a context whose fetches do not follow redirects to URLs
+// inScope rejects.
+func WithScope(ctx context.Context, inScope func(string) bool) context.Context {
+	return context.WithValue(ctx, scopeKey{}, inScope)
+}
+
+type queueKey struct{}
+
+// QueueRedirects returns a context whose fetches, under a policy that
+// enqueues redirects, return each redirect with a utils.RedirectError
+// instead of following it, so the caller can queue its target.
+func QueueRedirects(ctx context.Context) context.Context {
+	return context.WithValue(ctx, queueKey{}, true)
+}
+
+func queuesRedirects(ctx context.Context) bool {
+	q, _ := ctx.Value(queueKey{}).(bool)
+	return q
+}
@@ -0,0 +1,91 @@
+package identity
+
+import (
+	"context"
+	"net/http"
+)
+
+const (
+	DefaultProductToken = "MyCrawler"
+	defaultVersion      = "1.0"
+)
+
+// Identity is how the crawler presents itself: in the User-Agent and From
+// headers of every request, and as the agent matched against robots.txt.
+// UserAgent overrides the header built from ProductToken and ContactURL.
+type Identity struct {
+	ProductToken string `mapstructure:"product_token" json:"product_token,omitempty"`
+	UserAgent    string `mapstructure:"user_agent" json:"user_agent,omitempty"`
+	ContactURL   string `mapstructure:"contact_url" json:"contact_url,omitempty"`
+	From         string `mapstructure:"from" json:"from,omitempty"`
+}
+
+// Default is used by requests made outside of a task.
+var Default = Identity{ProductToken: DefaultProductToken}
+
+// Token returns the product token, the name looked up in robots.txt.
+func (id Identity) Token() string {
+	if id.ProductToken == "" {
+		return DefaultProductToken
+	}
+	return id.ProductToken
+}
+
+// UserAgentHeader returns UserAgent, or "<token>/1.0 (+<contact>)" when it
+// is empty.
+func (id Identity) UserAgentHeader() string {
+	if id.UserAgent != "" {
+		return id.UserAgent
+	}
+	ua := id.Token() + "/" + defaultVersion
+	if id.ContactURL != "" {
+		ua += " (+" + id.ContactURL + ")"
+	}
+	return ua
+}
+
+// Override returns id with the fields o sets. A new product token without
+// a UserAgent drops the inherited UserAgent, so the header names the token
+// that robots.txt is matched against.
+func (id Identity) Override(o *Identity) Identity {
+	if o == nil {
+		return id
+	}
+	if o.ProductToken != "" {
+		id.ProductToken = o.ProductToken
+		id.UserAgent = ""
+	}
+	if o.UserAgent != "" {
+		id.UserAgent = o.UserAgent
+	}
+	if o.ContactURL != "" {
+		id.ContactURL = o.ContactURL
+	}
+	if o.From != "" {
+		id.From = o.From
+	}
+	return id
+}
+
+// SetHeaders sets the User-Agent and, if there is one, the From header.
+func (id Identity) SetHeaders(h http.Header) {
+	h.Set("User-Agent", id.UserAgentHeader())
+	if id.From != "" {
+		h.Set("From", id.From)
+	}
+}
+
+type ctxKey struct{}
+
+// NewContext returns a context whose requests use id.
+func NewContext(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// FromContext returns the identity set with NewContext, or Default.
+func FromContext(ctx context.Context) Identity {
+	if id, ok := ctx.Value(ctxKey{}).(Identity); ok {
+		return id
+	}
+	return Default
+}
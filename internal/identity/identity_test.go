@@ -0,0 +1,43 @@
+package identity
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserAgentHeader(t *testing.T) {
+	assert.Equal(t, "MyCrawler/1.0", Identity{}.UserAgentHeader())
+	assert.Equal(t, "Bot/1.0 (+https://example.com/bot)", Identity{ProductToken: "Bot", ContactURL: "https://example.com/bot"}.UserAgentHeader())
+	assert.Equal(t, "Custom/2.0", Identity{ProductToken: "Bot", UserAgent: "Custom/2.0"}.UserAgentHeader())
+}
+
+func TestOverride(t *testing.T) {
+	base := Identity{ProductToken: "Bot", UserAgent: "Bot/2.0", ContactURL: "https://example.com/bot", From: "bot@example.com"}
+
+	assert.Equal(t, base, base.Override(nil))
+
+	id := base.Override(&Identity{ProductToken: "JobBot"})
+	assert.Equal(t, "JobBot", id.Token())
+	assert.Equal(t, "JobBot/1.0 (+https://example.com/bot)", id.UserAgentHeader(), "the header follows the new token")
+	assert.Equal(t, "bot@example.com", id.From)
+
+	id = base.Override(&Identity{From: "ops@example.com"})
+	assert.Equal(t, "Bot/2.0", id.UserAgentHeader())
+	assert.Equal(t, "ops@example.com", id.From)
+}
+
+func TestContext(t *testing.T) {
+	assert.Equal(t, Default, FromContext(context.Background()))
+
+	id := Identity{ProductToken: "Bot", From: "bot@example.com"}
+	ctx := NewContext(context.Background(), id)
+	assert.Equal(t, id, FromContext(ctx))
+
+	h := http.Header{}
+	FromContext(ctx).SetHeaders(h)
+	assert.Equal(t, "Bot/1.0", h.Get("User-Agent"))
+	assert.Equal(t, "bot@example.com", h.Get("From"))
+}
@@ -0,0 +1,20 @@
+package jobs
+
+import (
+	"context"
+
+	"github.com/NesterovYehor/Crawler/internal/models"
+)
+
+// Interface stores crawl jobs, their page counters and the tasks parked
+// while a job is paused.
+type Interface interface {
+	Create(ctx context.Context, job *models.Job) error
+	Get(ctx context.Context, id string) (*models.Job, error)
+	List(ctx context.Context) ([]*models.Job, error)
+	SetStatus(ctx context.Context, id, status string) error
+	CountPage(ctx context.Context, id string) (int64, error)
+	Park(ctx context.Context, id string, tasks []*models.Task) error
+	Unpark(ctx context.Context, id string) ([]*models.Task, error)
+	DropParked(ctx context.Context, id string) (int, error)
+}
@@ -0,0 +1,239 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/NesterovYehor/Crawler/internal/models"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	jobsKey      = "jobs"
+	pagesPrefix  = "job:pages:"
+	parkedPrefix = "job:parked:"
+	seenPrefix   = "url_filter:"
+	jarPrefix    = "cookies:"
+
+	// seenErrorRate and the capacities below size a job's seen-set. A job
+	// without a page budget gets the capacity of the global seen-set.
+	seenErrorRate       = 0.01
+	seenMinCapacity     = int64(1000)
+	seenDefaultCapacity = int64(100000)
+
+	ErrJobNotFound = errors.New("job not found")
+)
+
+// SeenFilter returns the key of the bloom filter holding the URLs a job
+// has crawled. Tasks without a job share the global filter.
+func SeenFilter(id string) string {
+	if id == "" {
+		return "url_filter"
+	}
+	return seenPrefix + id
+}
+
+// seenCapacity sizes a job's seen-set from its page budget.
+// CookieJar returns the key of the hash holding the cookies a job's
+// requests keep. Tasks without a job share one jar.
+func CookieJar(id string) string {
+	if id == "" {
+		return "cookies"
+	}
+	return jarPrefix + id
+}
+
+func seenCapacity(job *models.Job) int64 {
+	if job.MaxPages <= 0 {
+		return seenDefaultCapacity
+	}
+	return max(int64(job.MaxPages), seenMinCapacity)
+}
+
+// NewID returns a random job ID.
+func NewID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func validStatus(status string) bool {
+	switch status {
+	case models.JobActive, models.JobPaused, models.JobCancelled:
+		return true
+	}
+	return false
+}
+
+// NewStore returns a job store backed by Redis. Jobs are kept as JSON in a
+// single hash keyed by job ID.
+func NewStore(client *redis.Client) Interface {
+	return &store{client: client}
+}
+
+type store struct {
+	client *redis.Client
+}
+
+func (s *store) Create(ctx context.Context, job *models.Job) error {
+	if job.ID == "" {
+		job.ID = NewID()
+	}
+	now := time.Now()
+	job.Status = models.JobActive
+	job.CreatedAt, job.UpdatedAt = now, now
+	raw, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("failed to encode job: %w", err)
+	}
+	created, err := s.client.HSetNX(ctx, jobsKey, job.ID, raw).Result()
+	if err != nil {
+		return fmt.Errorf("failed to store job: %w", err)
+	}
+	if !created {
+		return fmt.Errorf("job %s already exists", job.ID)
+	}
+	// Reserve the seen-set up front; BF.ADD would otherwise create it with
+	// the server's default capacity of 100 and its false positives would
+	// hide pages of the job.
+	err = s.client.BFReserve(ctx, SeenFilter(job.ID), seenErrorRate, seenCapacity(job)).Err()
+	if err != nil && !strings.Contains(err.Error(), "item exists") {
+		return fmt.Errorf("failed to reserve seen-set of job %s: %w", job.ID, err)
+	}
+	return nil
+}
+
+func (s *store) Get(ctx context.Context, id string) (*models.Job, error) {
+	raw, err := s.client.HGet(ctx, jobsKey, id).Bytes()
+	if err == redis.Nil {
+		return nil, ErrJobNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read job %s: %w", id, err)
+	}
+	job := &models.Job{}
+	if err := json.Unmarshal(raw, job); err != nil {
+		return nil, fmt.Errorf("failed to decode job %s: %w", id, err)
+	}
+	return job, nil
+}
+
+func (s *store) List(ctx context.Context) ([]*models.Job, error) {
+	all, err := s.client.HGetAll(ctx, jobsKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list jobs: %w", err)
+	}
+	res := make([]*models.Job, 0, len(all))
+	for id, raw := range all {
+		job := &models.Job{}
+		if err := json.Unmarshal([]byte(raw), job); err != nil {
+			return nil, fmt.Errorf("failed to decode job %s: %w", id, err)
+		}
+		res = append(res, job)
+	}
+	sortJobs(res)
+	return res, nil
+}
+
+// SetStatus updates a job's status. A cancelled job can not be resumed and
+// its page count, seen-set and cookie jar are deleted with it.
+func (s *store) SetStatus(ctx context.Context, id, status string) error {
+	if !validStatus(status) {
+		return fmt.Errorf("unknown job status %q", status)
+	}
+	return s.client.Watch(ctx, func(tx *redis.Tx) error {
+		raw, err := tx.HGet(ctx, jobsKey, id).Bytes()
+		if err == redis.Nil {
+			return ErrJobNotFound
+		} else if err != nil {
+			return err
+		}
+		job := &models.Job{}
+		if err := json.Unmarshal(raw, job); err != nil {
+			return fmt.Errorf("failed to decode job %s: %w", id, err)
+		}
+		if err := transition(job, status); err != nil {
+			return err
+		}
+		raw, err = json.Marshal(job)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, jobsKey, id, raw)
+			if status == models.JobCancelled {
+				pipe.Del(ctx, pagesPrefix+id, SeenFilter(id), CookieJar(id))
+			}
+			return nil
+		})
+		return err
+	}, jobsKey)
+}
+
+func (s *store) CountPage(ctx context.Context, id string) (int64, error) {
+	n, err := s.client.Incr(ctx, pagesPrefix+id).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count page for job %s: %w", id, err)
+	}
+	return n, nil
+}
+
+func (s *store) Park(ctx context.Context, id string, tasks []*models.Task) error {
+	if len(tasks) == 0 {
+		return nil
+	}
+	vals := make([]any, 0, len(tasks))
+	for _, t := range tasks {
+		raw, err := t.Marshal()
+		if err != nil {
+			return fmt.Errorf("failed to encode parked task: %w", err)
+		}
+		vals = append(vals, raw)
+	}
+	if err := s.client.RPush(ctx, parkedPrefix+id, vals...).Err(); err != nil {
+		return fmt.Errorf("failed to park tasks of job %s: %w", id, err)
+	}
+	return nil
+}
+
+func (s *store) Unpark(ctx context.Context, id string) ([]*models.Task, error) {
+	pipe := s.client.TxPipeline()
+	get := pipe.LRange(ctx, parkedPrefix+id, 0, -1)
+	pipe.Del(ctx, parkedPrefix+id)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to unpark tasks of job %s: %w", id, err)
+	}
+	var tasks []*models.Task
+	for _, raw := range get.Val() {
+		t, err := models.UnmarshalTask([]byte(raw))
+		if err != nil {
+			continue
+		}
+		tasks = append(tasks, t)
+	}
+	return tasks, nil
+}
+
+func (s *store) DropParked(ctx context.Context, id string) (int, error) {
+	pipe := s.client.TxPipeline()
+	n := pipe.LLen(ctx, parkedPrefix+id)
+	pipe.Del(ctx, parkedPrefix+id)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("failed to drop parked tasks of job %s: %w", id, err)
+	}
+	return int(n.Val()), nil
+}
+
+func transition(job *models.Job, status string) error {
+	if job.Status == models.JobCancelled && status != models.JobCancelled {
+		return fmt.Errorf("job %s is cancelled", job.ID)
+	}
+	job.Status = status
+	job.UpdatedAt = time.Now()
+	return nil
+}
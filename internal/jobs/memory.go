@@ -0,0 +1,134 @@
+package jobs
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/NesterovYehor/Crawler/internal/models"
+)
+
+// NewMemoryStore returns an in-process job store for single process crawls
+// and tests.
+func NewMemoryStore() Interface {
+	return &memoryStore{
+		jobs:   make(map[string]*models.Job),
+		pages:  make(map[string]int64),
+		parked: make(map[string][]*models.Task),
+	}
+}
+
+type memoryStore struct {
+	mu     sync.Mutex
+	jobs   map[string]*models.Job
+	pages  map[string]int64
+	parked map[string][]*models.Task
+}
+
+func (s *memoryStore) Create(ctx context.Context, job *models.Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if job.ID == "" {
+		job.ID = NewID()
+	}
+	if _, ok := s.jobs[job.ID]; ok {
+		return fmt.Errorf("job %s already exists", job.ID)
+	}
+	now := time.Now()
+	job.Status = models.JobActive
+	job.CreatedAt, job.UpdatedAt = now, now
+	c := *job
+	s.jobs[job.ID] = &c
+	return nil
+}
+
+func (s *memoryStore) Get(ctx context.Context, id string) (*models.Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	c := *job
+	return &c, nil
+}
+
+func (s *memoryStore) List(ctx context.Context) ([]*models.Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := make([]*models.Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		c := *job
+		res = append(res, &c)
+	}
+	sortJobs(res)
+	return res, nil
+}
+
+func (s *memoryStore) SetStatus(ctx context.Context, id, status string) error {
+	if !validStatus(status) {
+		return fmt.Errorf("unknown job status %q", status)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return ErrJobNotFound
+	}
+	if err := transition(job, status); err != nil {
+		return err
+	}
+	if status == models.JobCancelled {
+		delete(s.pages, id)
+	}
+	return nil
+}
+
+func (s *memoryStore) CountPage(ctx context.Context, id string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pages[id]++
+	return s.pages[id], nil
+}
+
+func (s *memoryStore) Park(ctx context.Context, id string, tasks []*models.Task) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range tasks {
+		c := *t
+		s.parked[id] = append(s.parked[id], &c)
+	}
+	return nil
+}
+
+func (s *memoryStore) Unpark(ctx context.Context, id string) ([]*models.Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tasks := s.parked[id]
+	delete(s.parked, id)
+	for _, t := range tasks {
+		t.ID, t.Deliveries = "", 0
+	}
+	return tasks, nil
+}
+
+func (s *memoryStore) DropParked(ctx context.Context, id string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := len(s.parked[id])
+	delete(s.parked, id)
+	return n, nil
+}
+
+func sortJobs(jobs []*models.Job) {
+	slices.SortFunc(jobs, func(a, b *models.Job) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.ID, b.ID))
+	})
+}
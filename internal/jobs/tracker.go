@@ -0,0 +1,60 @@
+package jobs
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/NesterovYehor/Crawler/internal/models"
+)
+
+const defaultTrackerTTL = 2 * time.Second
+
+// Tracker caches jobs for workers, which look a job up for every task. A
+// pause or cancel reaches the workers within the cache TTL.
+type Tracker struct {
+	store Interface
+	ttl   time.Duration
+
+	mu      sync.Mutex
+	entries map[string]trackedJob
+}
+
+type trackedJob struct {
+	job       *models.Job
+	fetchedAt time.Time
+}
+
+func NewTracker(store Interface, ttl time.Duration) *Tracker {
+	if ttl <= 0 {
+		ttl = defaultTrackerTTL
+	}
+	return &Tracker{
+		store:   store,
+		ttl:     ttl,
+		entries: make(map[string]trackedJob),
+	}
+}
+
+func (t *Tracker) Job(ctx context.Context, id string) (*models.Job, error) {
+	t.mu.Lock()
+	e, ok := t.entries[id]
+	t.mu.Unlock()
+	if ok && time.Since(e.fetchedAt) < t.ttl {
+		return e.job, nil
+	}
+
+	job, err := t.store.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	t.entries[id] = trackedJob{job: job, fetchedAt: time.Now()}
+	t.mu.Unlock()
+	return job, nil
+}
+
+// Store returns the store the tracker reads from.
+func (t *Tracker) Store() Interface {
+	return t.store
+}
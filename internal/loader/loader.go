@@ -5,6 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/NesterovYehor/Crawler/internal/models"
 	"github.com/NesterovYehor/Crawler/internal/queue"
@@ -12,20 +14,16 @@ import (
 
 const initialTopic = "fetch_rules"
 
-func FillQueue(filePath string, q queue.Interface) error {
-	if filePath == "" {
-		return errors.New("file path is empty")
-	}
+type seed struct {
+	url      string
+	maxDepth int
+}
 
-	urls, err := loadURLs(filePath)
+// FillQueue adds a seed task for every line of the seed file.
+func FillQueue(filePath string, q queue.Interface, maxDepth int) error {
+	msgs, err := SeedTasks(filePath, maxDepth)
 	if err != nil {
-		return fmt.Errorf("failed to load URLs from file: %w", err)
-	}
-
-	msgs := make([]*models.Task, len(urls))
-	for i, url := range urls {
-		m := models.NewTask(initialTopic, url, queue.HighPriorityQueue, "")
-		msgs[i] = m
+		return err
 	}
 
 	if err := q.Add(msgs); err != nil {
@@ -34,18 +32,54 @@ func FillQueue(filePath string, q queue.Interface) error {
 	return nil
 }
 
-func loadURLs(path string) ([]string, error) {
+// SeedTasks reads a seed file and returns a seed task per line. A line holds
+// a URL, optionally followed by the max crawl depth for that seed; seeds
+// without one use maxDepth.
+func SeedTasks(filePath string, maxDepth int) ([]*models.Task, error) {
+	if filePath == "" {
+		return nil, errors.New("file path is empty")
+	}
+
+	seeds, err := loadSeeds(filePath, maxDepth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load URLs from file: %w", err)
+	}
+
+	msgs := make([]*models.Task, len(seeds))
+	for i, s := range seeds {
+		msgs[i] = models.NewSeedTask(initialTopic, s.url, queue.HighPriorityQueue, s.maxDepth)
+	}
+	return msgs, nil
+}
+
+func loadSeeds(path string, maxDepth int) ([]seed, error) {
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
+	defer f.Close()
 
-	urls := []string{}
+	seeds := []seed{}
 
 	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		urls = append(urls, scanner.Text())
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		s := seed{url: fields[0], maxDepth: maxDepth}
+		if len(fields) > 1 {
+			depth, err := strconv.Atoi(fields[1])
+			if err != nil || depth < 0 {
+				return nil, fmt.Errorf("line %d: invalid max depth %q", line, fields[1])
+			}
+			s.maxDepth = depth
+		}
+		seeds = append(seeds, s)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
 	}
 
-	return urls, nil
+	return seeds, nil
 }
@@ -3,6 +3,7 @@ package metrics
 import (
 	"sync"
 
+	"github.com/NesterovYehor/Crawler/internal/queue"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
@@ -18,6 +19,9 @@ func NewMetrics() *Metrics {
 			Crawler: newCrawlerMetrics(),
 			Queue:   newQueueMetrics(),
 			Store:   newStoreMetrics(),
+			Jobs:    newJobMetrics(),
+			Workers: newWorkerMetrics(),
+			Proxy:   newProxyMetrics(),
 		}
 	})
 	return instance
@@ -63,6 +67,86 @@ func newCrawlerMetrics() CrawlerMetrics {
 			Name:      "crawl_duration_seconds",
 			Help:      "Histogram of durations for crawling pages.",
 		}),
+		pagesUnchangedTotal: promauto.NewCounter(prometheus.CounterOpts{
+			Namespace: "crawler",
+			Name:      "pages_unchanged_total",
+			Help:      "Total number of re-crawled pages answered with 304 Not Modified.",
+		}),
+	}
+}
+
+func newJobMetrics() JobMetrics {
+	return &JobPrometheusMetrics{
+		pagesTotal: promauto.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "crawler",
+			Subsystem: "job",
+			Name:      "pages_total",
+			Help:      "Total number of pages crawled or failed per job.",
+		}, []string{"job", "result"}),
+		tasksTotal: promauto.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "crawler",
+			Subsystem: "job",
+			Name:      "tasks_total",
+			Help:      "Total number of job tasks by outcome: queued, out_of_scope, over_depth, over_budget, parked or cancelled.",
+		}, []string{"job", "outcome"}),
+	}
+}
+
+func newProxyMetrics() ProxyMetrics {
+	return &ProxyPrometheusMetrics{
+		requests: promauto.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "crawler",
+			Subsystem: "proxy",
+			Name:      "requests_total",
+			Help:      "Total requests sent through each proxy, by result.",
+		}, []string{"proxy", "result"}),
+		latency: promauto.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "crawler",
+			Subsystem: "proxy",
+			Name:      "request_duration_seconds",
+			Help:      "Time until response headers for requests through each proxy.",
+		}, []string{"proxy"}),
+		ejections: promauto.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "crawler",
+			Subsystem: "proxy",
+			Name:      "ejections_total",
+			Help:      "Total number of times each proxy was ejected for failing.",
+		}, []string{"proxy"}),
+		healthy: promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "crawler",
+			Subsystem: "proxy",
+			Name:      "healthy",
+			Help:      "Whether each proxy is in rotation (1) or cooling down (0).",
+		}, []string{"proxy"}),
+	}
+}
+
+func newWorkerMetrics() WorkerMetrics {
+	return &WorkerPrometheusMetrics{
+		workers: promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "crawler",
+			Subsystem: "workers",
+			Name:      "current",
+			Help:      "Current number of workers per lane.",
+		}, []string{"lane"}),
+		decisions: promauto.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "crawler",
+			Subsystem: "workers",
+			Name:      "scaling_decisions_total",
+			Help:      "Total number of autoscaling decisions per lane: up, down or hold, with the signal that caused them.",
+		}, []string{"lane", "decision", "reason"}),
+		panics: promauto.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "crawler",
+			Subsystem: "workers",
+			Name:      "panics_total",
+			Help:      "Total number of recovered worker panics per lane and task topic.",
+		}, []string{"lane", "topic"}),
+		retries: promauto.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "crawler",
+			Subsystem: "workers",
+			Name:      "retries_total",
+			Help:      "Total number of failed tasks per topic and error class, by what the retry policy did: backoff, retry_after or exhausted.",
+		}, []string{"topic", "class", "trigger"}),
 	}
 }
 
@@ -168,50 +252,59 @@ func newBloomFilterMetrics() BloomFilterMetrics {
 
 func newQueueMetrics() QueueMetrics {
 	return &QueuePrometheusMetrics{
-		lengthHigh: promauto.NewGauge(prometheus.GaugeOpts{
+		length: promauto.NewGaugeVec(prometheus.GaugeOpts{
 			Namespace: "queue",
-			Name:      "high_priority_length_current",
-			Help:      "Current number of tasks in the high priority queue.",
-		}),
-		lengthMedium: promauto.NewGauge(prometheus.GaugeOpts{
+			Name:      "length_current",
+			Help:      "Current number of tasks in each queue lane.",
+		}, []string{"queue"}),
+		fetchTotal: promauto.NewCounterVec(prometheus.CounterOpts{
 			Namespace: "queue",
-			Name:      "medium_priority_length_current",
-			Help:      "Current number of tasks in the medium priority queue.",
-		}),
-		lengthLow: promauto.NewGauge(prometheus.GaugeOpts{
+			Name:      "fetch_total",
+			Help:      "Total number of tasks fetched from each queue lane.",
+		}, []string{"queue"}),
+		totalFails: promauto.NewCounter(prometheus.CounterOpts{
 			Namespace: "queue",
-			Name:      "low_priority_length_current",
-			Help:      "Current number of tasks in the low priority queue.",
+			Name:      "failures_total",
+			Help:      "Total number of queue request failures.",
 		}),
-		lengthStore: promauto.NewGauge(prometheus.GaugeOpts{
+		fill: promauto.NewGaugeVec(prometheus.GaugeOpts{
 			Namespace: "queue",
-			Name:      "store_queue_length_current",
-			Help:      "Current number of tasks in the store queue.",
-		}),
-		fetchTotalHigh: promauto.NewCounter(prometheus.CounterOpts{
+			Subsystem: "backpressure",
+			Name:      "fill_ratio",
+			Help:      "Length of each capped queue lane relative to its max_len.",
+		}, []string{"queue"}),
+		saturated: promauto.NewGaugeVec(prometheus.GaugeOpts{
 			Namespace: "queue",
-			Name:      "fetch_high_priority_total",
-			Help:      "Total number of tasks fetched from the high priority queue.",
-		}),
-		fetchTotalMedium: promauto.NewCounter(prometheus.CounterOpts{
+			Subsystem: "backpressure",
+			Name:      "saturated",
+			Help:      "Whether backpressure is currently applied to a queue lane (1) or not (0).",
+		}, []string{"queue"}),
+		shedTotal: promauto.NewCounterVec(prometheus.CounterOpts{
 			Namespace: "queue",
-			Name:      "fetch_medium_priority_total",
-			Help:      "Total number of tasks fetched from the medium priority queue.",
-		}),
-		fetchTotalLow: promauto.NewCounter(prometheus.CounterOpts{
-			Namespace: "queue",
-			Name:      "fetch_low_priority_total",
-			Help:      "Total number of tasks fetched from the low priority queue.",
-		}),
-		fetchTotalStore: promauto.NewCounter(prometheus.CounterOpts{
+			Subsystem: "backpressure",
+			Name:      "tasks_total",
+			Help:      "Total number of tasks spilled, dropped, held or restored because of backpressure.",
+		}, []string{"queue", "action"}),
+		legacy: map[string]legacyLaneMetrics{
+			queue.HighPriorityQueue:   newLegacyLaneMetrics("high_priority_length_current", "fetch_high_priority_total"),
+			queue.MediumPriorityQueue: newLegacyLaneMetrics("medium_priority_length_current", "fetch_medium_priority_total"),
+			queue.RetryPriorityQueue:  newLegacyLaneMetrics("low_priority_length_current", "fetch_low_priority_total"),
+			queue.StoreQueue:          newLegacyLaneMetrics("store_queue_length_current", "fetch_store_queue_total"),
+		},
+	}
+}
+
+func newLegacyLaneMetrics(length, fetchTotal string) legacyLaneMetrics {
+	return legacyLaneMetrics{
+		length: promauto.NewGauge(prometheus.GaugeOpts{
 			Namespace: "queue",
-			Name:      "fetch_store_queue_total",
-			Help:      "Total number of tasks fetched from the store queue.",
+			Name:      length,
+			Help:      "Deprecated: use queue_length_current{queue=...}.",
 		}),
-		totalFails: promauto.NewCounter(prometheus.CounterOpts{
+		fetchTotal: promauto.NewCounter(prometheus.CounterOpts{
 			Namespace: "queue",
-			Name:      "failures_total",
-			Help:      "Total number of queue request failures.",
+			Name:      fetchTotal,
+			Help:      "Deprecated: use queue_fetch_total{queue=...}.",
 		}),
 	}
 }
@@ -33,6 +33,8 @@ type BloomFilterMetrics interface {
 
 type CrawlerMetrics interface {
 	Update(failed bool, dur time.Duration)
+	// ObserveUnchanged counts a re-crawl answered with 304 Not Modified.
+	ObserveUnchanged()
 }
 
 // === Queue ===
@@ -41,4 +43,31 @@ type QueueMetrics interface {
 	ObserveAdd(source string)
 	ObserveFailure()
 	ObserveFetch(source string)
+	ObserveBackpressure(source string, level float64, saturated bool)
+	ObserveShed(source, action string, count int)
+}
+
+// === Jobs ===
+
+type JobMetrics interface {
+	ObservePage(job string, failed bool)
+	ObserveTasks(job, outcome string, count int)
+}
+
+// === Workers ===
+
+type WorkerMetrics interface {
+	ObserveScale(lane, decision, reason string, workers int)
+	ObservePanic(lane, topic string)
+	// ObserveRetry counts a failed task by what the retry policy did with
+	// it: backoff, retry_after or exhausted.
+	ObserveRetry(topic, class, trigger string)
+}
+
+// === Proxies ===
+
+type ProxyMetrics interface {
+	ObserveRequest(proxy string, failed bool, dur time.Duration)
+	ObserveEjection(proxy string)
+	SetHealthy(proxy string, healthy bool)
 }
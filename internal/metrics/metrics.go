@@ -3,7 +3,6 @@ package metrics
 import (
 	"time"
 
-	"github.com/NesterovYehor/Crawler/internal/queue"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -11,6 +10,9 @@ type Metrics struct {
 	Crawler CrawlerMetrics
 	Queue   QueueMetrics
 	Store   StoreMetrics
+	Jobs    JobMetrics
+	Workers WorkerMetrics
+	Proxy   ProxyMetrics
 }
 
 type StorePrometheusMetrics struct {
@@ -42,6 +44,7 @@ type CrawlerPrometheusMetrics struct {
 	pagesCrawledTotal    prometheus.Counter
 	pagesFailedTotal     prometheus.Counter
 	crawlDurationSeconds prometheus.Histogram
+	pagesUnchangedTotal  prometheus.Counter
 }
 
 func (m *CrawlerPrometheusMetrics) Update(failed bool, dur time.Duration) {
@@ -53,6 +56,75 @@ func (m *CrawlerPrometheusMetrics) Update(failed bool, dur time.Duration) {
 	m.pagesFailedTotal.Inc()
 }
 
+func (m *CrawlerPrometheusMetrics) ObserveUnchanged() {
+	m.pagesUnchangedTotal.Inc()
+}
+
+type JobPrometheusMetrics struct {
+	pagesTotal *prometheus.CounterVec
+	tasksTotal *prometheus.CounterVec
+}
+
+func (m *JobPrometheusMetrics) ObservePage(job string, failed bool) {
+	result := "crawled"
+	if failed {
+		result = "failed"
+	}
+	m.pagesTotal.WithLabelValues(job, result).Inc()
+}
+
+func (m *JobPrometheusMetrics) ObserveTasks(job, outcome string, count int) {
+	m.tasksTotal.WithLabelValues(job, outcome).Add(float64(count))
+}
+
+type WorkerPrometheusMetrics struct {
+	workers   *prometheus.GaugeVec
+	decisions *prometheus.CounterVec
+	panics    *prometheus.CounterVec
+	retries   *prometheus.CounterVec
+}
+
+func (m *WorkerPrometheusMetrics) ObserveScale(lane, decision, reason string, workers int) {
+	m.workers.WithLabelValues(lane).Set(float64(workers))
+	m.decisions.WithLabelValues(lane, decision, reason).Inc()
+}
+
+func (m *WorkerPrometheusMetrics) ObservePanic(lane, topic string) {
+	m.panics.WithLabelValues(lane, topic).Inc()
+}
+
+func (m *WorkerPrometheusMetrics) ObserveRetry(topic, class, trigger string) {
+	m.retries.WithLabelValues(topic, class, trigger).Inc()
+}
+
+type ProxyPrometheusMetrics struct {
+	requests  *prometheus.CounterVec
+	latency   *prometheus.HistogramVec
+	ejections *prometheus.CounterVec
+	healthy   *prometheus.GaugeVec
+}
+
+func (m *ProxyPrometheusMetrics) ObserveRequest(proxy string, failed bool, dur time.Duration) {
+	if failed {
+		m.requests.WithLabelValues(proxy, "failed").Inc()
+		return
+	}
+	m.requests.WithLabelValues(proxy, "ok").Inc()
+	m.latency.WithLabelValues(proxy).Observe(dur.Seconds())
+}
+
+func (m *ProxyPrometheusMetrics) ObserveEjection(proxy string) {
+	m.ejections.WithLabelValues(proxy).Inc()
+}
+
+func (m *ProxyPrometheusMetrics) SetHealthy(proxy string, healthy bool) {
+	v := 0.0
+	if healthy {
+		v = 1
+	}
+	m.healthy.WithLabelValues(proxy).Set(v)
+}
+
 type DBPrometheusMetrics struct {
 	cassandraWritesTotal      prometheus.Counter
 	cassandraWriteErrorsTotal prometheus.Counter
@@ -71,27 +143,27 @@ func (m *DBPrometheusMetrics) Update(failed bool, dur time.Duration) {
 }
 
 type QueuePrometheusMetrics struct {
-	totalFails       prometheus.Counter
-	lengthHigh       prometheus.Gauge
-	lengthMedium     prometheus.Gauge
-	lengthStore      prometheus.Gauge
-	lengthLow        prometheus.Gauge
-	fetchTotalHigh   prometheus.Counter
-	fetchTotalMedium prometheus.Counter
-	fetchTotalLow    prometheus.Counter
-	fetchTotalStore  prometheus.Counter
+	totalFails prometheus.Counter
+	length     *prometheus.GaugeVec
+	fetchTotal *prometheus.CounterVec
+	fill       *prometheus.GaugeVec
+	saturated  *prometheus.GaugeVec
+	shedTotal  *prometheus.CounterVec
+	legacy     map[string]legacyLaneMetrics
+}
+
+// legacyLaneMetrics are the per-lane metrics that predate the queue label.
+// They are only kept for the built-in lanes, so existing dashboards keep
+// working, and will be removed in a later release.
+type legacyLaneMetrics struct {
+	length     prometheus.Gauge
+	fetchTotal prometheus.Counter
 }
 
 func (m *QueuePrometheusMetrics) ObserveAdd(source string) {
-	switch source {
-	case queue.HighPriorityQueue:
-		m.lengthHigh.Inc()
-	case queue.MediumPriorityQueue:
-		m.lengthMedium.Inc()
-	case queue.StoreQueue:
-		m.lengthStore.Inc()
-	case queue.RetryPriorityQueue:
-		m.lengthLow.Inc()
+	m.length.WithLabelValues(source).Inc()
+	if l, ok := m.legacy[source]; ok {
+		l.length.Inc()
 	}
 }
 
@@ -100,22 +172,27 @@ func (m *QueuePrometheusMetrics) ObserveFailure() {
 }
 
 func (m *QueuePrometheusMetrics) ObserveFetch(source string) {
-	switch source {
-	case queue.HighPriorityQueue:
-		m.fetchTotalHigh.Inc()
-		m.lengthHigh.Dec()
-	case queue.MediumPriorityQueue:
-		m.fetchTotalMedium.Inc()
-		m.lengthMedium.Dec()
-	case queue.StoreQueue:
-		m.fetchTotalStore.Inc()
-		m.lengthStore.Dec()
-	case queue.RetryPriorityQueue:
-		m.fetchTotalLow.Inc()
-		m.lengthLow.Dec()
+	m.fetchTotal.WithLabelValues(source).Inc()
+	m.length.WithLabelValues(source).Dec()
+	if l, ok := m.legacy[source]; ok {
+		l.fetchTotal.Inc()
+		l.length.Dec()
 	}
 }
 
+func (m *QueuePrometheusMetrics) ObserveBackpressure(source string, level float64, saturated bool) {
+	m.fill.WithLabelValues(source).Set(level)
+	if saturated {
+		m.saturated.WithLabelValues(source).Set(1)
+	} else {
+		m.saturated.WithLabelValues(source).Set(0)
+	}
+}
+
+func (m *QueuePrometheusMetrics) ObserveShed(source, action string, count int) {
+	m.shedTotal.WithLabelValues(source, action).Add(float64(count))
+}
+
 type CachePrometheusMetrics struct {
 	Redis       RedisMetrics
 	BloomFilter BloomFilterMetrics
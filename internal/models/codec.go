@@ -0,0 +1,167 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/NesterovYehor/Crawler/internal/utils"
+)
+
+// TaskCodecVersion is the version of the task wire format written by
+// Marshal and Encode. Every queue path - streams, the frontier, retry sets,
+// dead letters, spill files and parked job tasks - stores tasks in it.
+//
+// Version 1 is the legacy format: a flat field map in streams (see
+// decodeLegacyMap) or the Go field names of Task in JSON. It is still read,
+// never written.
+const TaskCodecVersion = 2
+
+// taskWire is the version 2 JSON schema. ID and Deliveries are not part of
+// it: they describe one delivery of a task and are set by the queue that
+// hands it out.
+type taskWire struct {
+	V             int        `json:"v"`
+	Topic         string     `json:"topic"`
+	URL           string     `json:"url"`
+	DataID        string     `json:"data_id,omitempty"`
+	Source        string     `json:"source,omitempty"`
+	Retries       int        `json:"retries,omitempty"`
+	NextAttemptAt int64      `json:"next_attempt_at,omitempty"`
+	Attempts      []Attempt  `json:"attempts,omitempty"`
+	MaxAttempts   int        `json:"max_attempts,omitempty"`
+	Depth         int        `json:"depth,omitempty"`
+	MaxDepth      int        `json:"max_depth,omitempty"`
+	ParentURL     string     `json:"parent_url,omitempty"`
+	SeedID        string     `json:"seed_id,omitempty"`
+	Discovery     string     `json:"discovery,omitempty"`
+	JobID         string     `json:"job_id,omitempty"`
+	LastMod       *time.Time `json:"lastmod,omitempty"`
+	ChangeFreq    string     `json:"changefreq,omitempty"`
+	Priority      float64    `json:"priority,omitempty"`
+}
+
+// Marshal encodes the task in the current wire format.
+func (t *Task) Marshal() ([]byte, error) {
+	data, err := json.Marshal(taskWire{
+		V:             TaskCodecVersion,
+		Topic:         t.Topic,
+		URL:           t.URL,
+		DataID:        t.DataID,
+		Source:        t.SourceName,
+		Retries:       t.Retries,
+		NextAttemptAt: t.NextAttemptAt,
+		Attempts:      t.Attempts,
+		MaxAttempts:   t.MaxAttempts,
+		Depth:         t.Depth,
+		MaxDepth:      t.MaxDepth,
+		ParentURL:     t.ParentURL,
+		SeedID:        t.SeedID,
+		Discovery:     t.Discovery,
+		JobID:         t.JobID,
+		LastMod:       lastModWire(t.LastMod),
+		ChangeFreq:    t.ChangeFreq,
+		Priority:      t.Priority,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode task: %w", err)
+	}
+	return data, nil
+}
+
+// lastModWire leaves a zero LastMod out of the wire format.
+func lastModWire(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// UnmarshalTask decodes a task written by Marshal or by a legacy encoder.
+func UnmarshalTask(data []byte) (*Task, error) {
+	var probe struct {
+		V int `json:"v"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, fmt.Errorf("failed to decode task: %w", err)
+	}
+
+	switch probe.V {
+	case 0:
+		// Version 1 tasks were encoded with the Go field names of Task.
+		t := &Task{}
+		if err := json.Unmarshal(data, t); err != nil {
+			return nil, fmt.Errorf("failed to decode legacy task: %w", err)
+		}
+		t.ID, t.Deliveries = "", 0
+		return t, nil
+	case TaskCodecVersion:
+		var w taskWire
+		if err := json.Unmarshal(data, &w); err != nil {
+			return nil, fmt.Errorf("failed to decode task: %w", err)
+		}
+		t := &Task{
+			Topic:         w.Topic,
+			URL:           w.URL,
+			DataID:        w.DataID,
+			SourceName:    w.Source,
+			Retries:       w.Retries,
+			NextAttemptAt: w.NextAttemptAt,
+			Attempts:      w.Attempts,
+			MaxAttempts:   w.MaxAttempts,
+			Depth:         w.Depth,
+			MaxDepth:      w.MaxDepth,
+			ParentURL:     w.ParentURL,
+			SeedID:        w.SeedID,
+			Discovery:     w.Discovery,
+			JobID:         w.JobID,
+			ChangeFreq:    w.ChangeFreq,
+			Priority:      w.Priority,
+		}
+		if w.LastMod != nil {
+			t.LastMod = *w.LastMod
+		}
+		return t, nil
+	default:
+		return nil, fmt.Errorf("unsupported task codec version %d", probe.V)
+	}
+}
+
+// Encode returns the stream entry fields for the task: the codec version
+// and the task itself in the current wire format.
+func (m *Task) Encode() (map[string]any, error) {
+	if !m.IsValid() {
+		return nil, utils.ErrInvalidTaskFormat(m)
+	}
+	data, err := m.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{
+		"v":    TaskCodecVersion,
+		"task": string(data),
+	}, nil
+}
+
+// Decode reads a stream entry written by Encode or by the legacy flat
+// encoder. The stream the entry was read from, when given, overrides the
+// source stored with the task.
+func (m *Task) Decode(val map[string]any, source string) error {
+	raw, ok := val["task"].(string)
+	if !ok {
+		return m.decodeLegacyMap(val, source)
+	}
+	if v := fmt.Sprint(val["v"]); v != strconv.Itoa(TaskCodecVersion) {
+		return fmt.Errorf("unsupported task codec version %s", v)
+	}
+	t, err := UnmarshalTask([]byte(raw))
+	if err != nil {
+		return err
+	}
+	*m = *t
+	if source != "" {
+		m.SourceName = source
+	}
+	return nil
+}
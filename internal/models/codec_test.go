@@ -0,0 +1,128 @@
+package models_test
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/NesterovYehor/Crawler/internal/models"
+	"github.com/NesterovYehor/Crawler/internal/queue"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fullTask returns a task with every field set, so a field the codec drops
+// shows up as a round-trip difference.
+func fullTask() *models.Task {
+	return &models.Task{
+		ID:            "1700000000000-0",
+		NextAttemptAt: 1700000042,
+		Topic:         "crawl_page",
+		Retries:       2,
+		URL:           "https://example.com/a?b=c",
+		DataID:        "data-123",
+		Depth:         3,
+		MaxDepth:      5,
+		ParentURL:     "https://example.com/",
+		SeedID:        "0123456789abcdef",
+		Discovery:     models.DiscoveryLink,
+		JobID:         "job-1",
+		SourceName:    queue.MediumPriorityQueue,
+		Deliveries:    2,
+		Attempts: []models.Attempt{
+			{At: time.Unix(1700000000, 0).UTC(), Error: "timeout", ErrorClass: "timeout"},
+			{At: time.Unix(1700000010, 0).UTC(), Error: "retry later", ErrorClass: "retry_later", Reason: "exponential backoff", Delay: 4 * time.Second},
+		},
+		MaxAttempts: 8,
+		LastMod:     time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		ChangeFreq:  "daily",
+		Priority:    0.8,
+	}
+}
+
+// withoutDelivery clears the fields that describe a single delivery and are
+// not part of the wire format.
+func withoutDelivery(t *models.Task) *models.Task {
+	c := *t
+	c.ID, c.Deliveries = "", 0
+	return &c
+}
+
+func TestFullTaskSetsEveryField(t *testing.T) {
+	v := reflect.ValueOf(*fullTask())
+	for i := range v.NumField() {
+		assert.False(t, v.Field(i).IsZero(), "fullTask must set %s", v.Type().Field(i).Name)
+	}
+}
+
+func TestTaskMarshalRoundTrip(t *testing.T) {
+	task := fullTask()
+	data, err := task.Marshal()
+	require.NoError(t, err)
+
+	got, err := models.UnmarshalTask(data)
+	require.NoError(t, err)
+	assert.Equal(t, withoutDelivery(task), got)
+}
+
+func TestTaskStreamRoundTrip(t *testing.T) {
+	task := fullTask()
+	val, err := task.Encode()
+	require.NoError(t, err)
+
+	// Redis hands every stream field back as a string.
+	fields := make(map[string]any, len(val))
+	for k, v := range val {
+		fields[k] = fmt.Sprint(v)
+	}
+
+	got := &models.Task{}
+	require.NoError(t, got.Decode(fields, queue.MediumPriorityQueue))
+	assert.Equal(t, withoutDelivery(task), got)
+
+	got = &models.Task{}
+	require.NoError(t, got.Decode(fields, queue.HighPriorityQueue))
+	assert.Equal(t, queue.HighPriorityQueue, got.SourceName, "the stream a task is read from wins")
+}
+
+func TestTaskDecodesLegacyEntries(t *testing.T) {
+	t.Run("flat stream map", func(t *testing.T) {
+		got := &models.Task{}
+		require.NoError(t, got.Decode(map[string]any{
+			"topic":    "crawl_page",
+			"retries":  "1",
+			"url":      "https://example.com",
+			"data_id":  "",
+			"backoff":  "1700000042",
+			"attempts": `[{"At":"2023-11-14T22:13:20Z","Error":"timeout","ErrorClass":"timeout"}]`,
+		}, queue.HighPriorityQueue))
+
+		assert.Equal(t, "crawl_page", got.Topic)
+		assert.Equal(t, 1, got.Retries)
+		assert.Equal(t, int64(1700000042), got.NextAttemptAt, "legacy backoff is the next attempt time")
+		assert.Equal(t, queue.HighPriorityQueue, got.SourceName)
+		require.Len(t, got.Attempts, 1)
+		assert.Equal(t, "timeout", got.Attempts[0].ErrorClass)
+	})
+
+	t.Run("go field name json", func(t *testing.T) {
+		got, err := models.UnmarshalTask([]byte(`{"ID":"1-0","NextAttemptAt":1700000042,"Topic":"crawl_page","Retries":5,"URL":"https://example.com","DataID":"","SourceName":"queue:fetch:medium","Deliveries":3}`))
+		require.NoError(t, err)
+
+		assert.Equal(t, "crawl_page", got.Topic)
+		assert.Equal(t, 5, got.Retries)
+		assert.Equal(t, int64(1700000042), got.NextAttemptAt)
+		assert.Equal(t, queue.MediumPriorityQueue, got.SourceName)
+		assert.Empty(t, got.ID)
+		assert.Zero(t, got.Deliveries)
+	})
+}
+
+func TestTaskRejectsUnknownCodecVersion(t *testing.T) {
+	_, err := models.UnmarshalTask([]byte(`{"v":99,"topic":"crawl_page","url":"https://example.com"}`))
+	assert.Error(t, err)
+
+	err = (&models.Task{}).Decode(map[string]any{"v": "99", "task": `{"v":99}`}, queue.HighPriorityQueue)
+	assert.Error(t, err)
+}
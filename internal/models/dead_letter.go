@@ -0,0 +1,73 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+type DeadLetter struct {
+	ID         string    `json:"-"`
+	Task       *Task     `json:"task"`
+	Host       string    `json:"host"`
+	LastError  string    `json:"last_error"`
+	ErrorClass string    `json:"error_class"`
+	FirstSeen  time.Time `json:"first_seen"`
+	DeadAt     time.Time `json:"dead_at"`
+}
+
+func NewDeadLetter(task *Task, host string) *DeadLetter {
+	dl := &DeadLetter{
+		Task:   task,
+		Host:   host,
+		DeadAt: time.Now(),
+	}
+	if last, ok := task.LastAttempt(); ok {
+		dl.LastError = last.Error
+		dl.ErrorClass = last.ErrorClass
+		dl.FirstSeen = task.Attempts[0].At
+	}
+	return dl
+}
+
+func (d *DeadLetter) Encode() (map[string]any, error) {
+	task, err := d.Task.Marshal()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode dead letter task: %w", err)
+	}
+	return map[string]any{
+		"task":        string(task),
+		"host":        d.Host,
+		"last_error":  d.LastError,
+		"error_class": d.ErrorClass,
+		"first_seen":  d.FirstSeen.Unix(),
+		"dead_at":     d.DeadAt.Unix(),
+	}, nil
+}
+
+func (d *DeadLetter) Decode(id string, val map[string]any) error {
+	raw, _ := val["task"].(string)
+	task, err := UnmarshalTask([]byte(raw))
+	if err != nil {
+		return fmt.Errorf("failed to decode dead letter task: %w", err)
+	}
+	d.ID = id
+	d.Task = task
+	d.Host, _ = val["host"].(string)
+	d.LastError, _ = val["last_error"].(string)
+	d.ErrorClass, _ = val["error_class"].(string)
+	d.FirstSeen = parseUnix(val["first_seen"])
+	d.DeadAt = parseUnix(val["dead_at"])
+	return nil
+}
+
+func parseUnix(v any) time.Time {
+	s, ok := v.(string)
+	if !ok {
+		return time.Time{}
+	}
+	var sec int64
+	if _, err := fmt.Sscan(s, &sec); err != nil || sec <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0)
+}
@@ -0,0 +1,60 @@
+package models
+
+import (
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/NesterovYehor/Crawler/internal/identity"
+)
+
+const (
+	JobActive    = "active"
+	JobPaused    = "paused"
+	JobCancelled = "cancelled"
+)
+
+// Job is an independently configured crawl. Every task, seen-set entry and
+// metadata row it produces is tagged with its ID.
+type Job struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Seeds     []string  `json:"seeds"`
+	Scope     []string  `json:"scope"`
+	MaxDepth  int       `json:"max_depth"`
+	MaxPages  int64     `json:"max_pages"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// Identity overrides fields of the crawler identity for this job.
+	Identity *identity.Identity `json:"identity,omitempty"`
+	// ProxyStrategy overrides how proxies are picked for this job's
+	// requests.
+	ProxyStrategy string `json:"proxy_strategy,omitempty"`
+}
+
+// InScope reports whether rawURL belongs to one of the job's scope hosts or
+// their subdomains. A job without a scope accepts every URL.
+func (j *Job) InScope(rawURL string) bool {
+	if len(j.Scope) == 0 {
+		return true
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, s := range j.Scope {
+		s = strings.ToLower(s)
+		if host == s || strings.HasSuffix(host, "."+s) {
+			return true
+		}
+	}
+	return false
+}
+
+// PageBudgetLeft reports whether the job may crawl more pages after having
+// crawled the given number.
+func (j *Job) PageBudgetLeft(crawled int64) bool {
+	return j.MaxPages <= 0 || crawled < j.MaxPages
+}
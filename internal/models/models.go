@@ -1,8 +1,10 @@
 package models
 
 import (
+	"bytes"
 	"encoding/hex"
 	"fmt"
+	"io"
 	"strconv"
 	"time"
 
@@ -21,7 +23,55 @@ type Metadata struct {
 	Latency    Latency   `json:"latency_ms"`
 	Timestamp  time.Time `json:"time"`
 	ContentLen int       `json:"content_length"`
+
+	Depth     int    `json:"depth"`
+	ParentURL string `json:"parent_url"`
+	SeedID    string `json:"seed_id"`
+	Discovery string `json:"discovery"`
+	JobID     string `json:"job_id"`
+
+	// ETag and LastModified are the validators of the response, sent back
+	// when the page is crawled again.
+	ETag         string `json:"etag"`
+	LastModified string `json:"last_modified"`
+	FetchStatus  string `json:"fetch_status"`
+	// Truncated marks content cut off at the response size limit.
+	Truncated bool `json:"truncated"`
+	// Charset is the encoding the page was sent in. Content is stored as
+	// UTF-8.
+	Charset string `json:"charset"`
+	// FinalURL is where the content came from after redirects; Redirects
+	// lists the URLs that redirected to it, starting with URL.
+	FinalURL  string   `json:"final_url"`
+	Redirects []string `json:"redirects"`
+}
+
+// Fetch statuses record the outcome of the latest crawl of a page.
+const (
+	FetchStatusFetched   = "fetched"
+	FetchStatusUnchanged = "unchanged"
+)
+
+// SetLineage records how the crawler reached the page described by m.
+func (m *Metadata) SetLineage(t *Task) {
+	m.Depth = t.Depth
+	m.ParentURL = t.ParentURL
+	m.SeedID = t.SeedID
+	m.Discovery = t.Discovery
+	m.JobID = t.JobID
 }
+
+// AtFinalURL returns m as the metadata of the URL the page finally came
+// from, so a later crawl of that URL finds its validators. It reports false
+// if the page was not redirected.
+func (m Metadata) AtFinalURL() (Metadata, bool) {
+	if m.FinalURL == "" || m.FinalURL == m.URL {
+		return m, false
+	}
+	m.URL, m.Redirects = m.FinalURL, nil
+	return m, true
+}
+
 type Latency time.Duration
 
 func (l Latency) MarshalJSON() ([]byte, error) {
@@ -45,12 +95,13 @@ func NewPageDataModel(url, host string, content []byte, latency time.Duration) (
 
 	hashString := hex.EncodeToString(htmlHahs[:])
 	metadata := Metadata{
-		URL:        url,
-		Host:       host,
-		HTMLHash:   hashString,
-		Latency:    Latency(latency),
-		Timestamp:  time.Now(),
-		ContentLen: len(content),
+		URL:         url,
+		Host:        host,
+		HTMLHash:    hashString,
+		Latency:     Latency(latency),
+		Timestamp:   time.Now(),
+		ContentLen:  len(content),
+		FetchStatus: FetchStatusFetched,
 	}
 
 	return &PageDataModel{
@@ -59,6 +110,43 @@ func NewPageDataModel(url, host string, content []byte, latency time.Duration) (
 	}, nil
 }
 
+// ReadPage builds a page from a response body in one pass. The body is
+// hashed as it is read and handed to parse, which sees the same stream, so
+// the page is not buffered again for hashing or parsing. Whatever parse
+// leaves unread is still hashed and stored. size is the expected length of
+// the body, or a negative number if it is not known; the content is then
+// read into a buffer allocated once.
+func ReadPage(url, host string, body io.Reader, size int64, latency time.Duration, parse func(io.Reader) error) (*PageDataModel, error) {
+	hasher := blake3.New()
+	var content bytes.Buffer
+	if size > 0 {
+		content.Grow(int(size))
+	}
+	stream := io.TeeReader(body, io.MultiWriter(hasher, &content))
+
+	if parse != nil {
+		if err := parse(stream); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := io.Copy(io.Discard, stream); err != nil {
+		return nil, fmt.Errorf("failed to read body: %w", err)
+	}
+
+	return &PageDataModel{
+		Metadata: Metadata{
+			URL:         url,
+			Host:        host,
+			HTMLHash:    hex.EncodeToString(hasher.Sum(nil)),
+			Latency:     Latency(latency),
+			Timestamp:   time.Now(),
+			ContentLen:  content.Len(),
+			FetchStatus: FetchStatusFetched,
+		},
+		Content: content.Bytes(),
+	}, nil
+}
+
 func (m *PageDataModel) IsValid() bool {
 	return m.Content != nil
 }
@@ -0,0 +1,34 @@
+package models_test
+
+import (
+	"io"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NesterovYehor/Crawler/internal/models"
+	"github.com/NesterovYehor/Crawler/internal/parser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadPage(t *testing.T) {
+	body := `<html><body><a href="/one">one</a></body></html>` + strings.Repeat(" ", 4096)
+	base, err := url.Parse("https://example.com")
+	require.NoError(t, err)
+
+	var links []string
+	page, err := models.ReadPage("https://example.com", "example.com", strings.NewReader(body), int64(len(body)), time.Second, func(r io.Reader) error {
+		links, err = parser.GetURLsFromHTML(r, base)
+		return err
+	})
+	require.NoError(t, err)
+
+	want, err := models.NewPageDataModel("https://example.com", "example.com", []byte(body), time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"https://example.com/one"}, links)
+	assert.Equal(t, body, string(page.Content))
+	assert.Equal(t, want.Metadata.HTMLHash, page.Metadata.HTMLHash, "hashing the stream matches hashing the content")
+	assert.Equal(t, len(body), page.Metadata.ContentLen)
+}
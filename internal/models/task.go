@@ -1,22 +1,38 @@
 package models
 
 import (
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"log"
-	"math"
 	"strconv"
 	"time"
 
 	"github.com/NesterovYehor/Crawler/internal/utils"
 	"github.com/go-viper/mapstructure/v2"
+	"github.com/zeebo/blake3"
 )
 
+// MaxRetries is the attempt limit of a task without a retry policy.
+const MaxRetries = 5
+
+// Discovery sources record how the crawler found a task's URL.
 const (
-	baseDelay  = 2
-	multiplier = 2
+	DiscoverySeed     = "seed"
+	DiscoveryLink     = "link"
+	DiscoverySitemap  = "sitemap"
+	DiscoveryRedirect = "redirect"
 )
 
+// Attempt is a failed attempt. Reason and Delay record why and when the
+// retry policy scheduled the next one.
+type Attempt struct {
+	At         time.Time
+	Error      string
+	ErrorClass string
+	Reason     string        `json:",omitempty"`
+	Delay      time.Duration `json:",omitempty"`
+}
+
 type Task struct {
 	ID            string `mapstructure:"id"`
 	NextAttemptAt int64  `mapstructure:"next_attempt_at"`
@@ -24,7 +40,23 @@ type Task struct {
 	Retries       int    `mapstructure:"retries"`
 	URL           string `mapstructure:"url"`
 	DataID        string `mapstructure:"data_id"`
+	Depth         int    `mapstructure:"depth"`
+	MaxDepth      int    `mapstructure:"max_depth"`
+	ParentURL     string `mapstructure:"parent_url"`
+	SeedID        string `mapstructure:"seed_id"`
+	Discovery     string `mapstructure:"discovery"`
+	JobID         string `mapstructure:"job_id"`
 	SourceName    string
+	Deliveries    int
+	Attempts      []Attempt
+	// MaxAttempts is the attempt limit the retry policy set for the task's
+	// last error; zero means MaxRetries.
+	MaxAttempts int
+	// LastMod, ChangeFreq and Priority are the hints of the sitemap entry
+	// the task was found in, if any.
+	LastMod    time.Time
+	ChangeFreq string
+	Priority   float64
 }
 
 func NewTask(topic, url string, source string, dataID string) *Task {
@@ -38,7 +70,63 @@ func NewTask(topic, url string, source string, dataID string) *Task {
 	}
 }
 
-func (m *Task) Decode(val map[string]any, source string) error {
+// NewSeedTask creates the root task of a crawl. Every task discovered from
+// it inherits its seed ID and max depth; a max depth of zero is unlimited.
+func NewSeedTask(topic, url string, source string, maxDepth int) *Task {
+	t := NewTask(topic, url, source, "")
+	t.SeedID = SeedID(url)
+	t.Discovery = DiscoverySeed
+	t.MaxDepth = maxDepth
+	return t
+}
+
+// SeedID derives a stable identifier for a seed URL.
+func SeedID(url string) string {
+	sum := blake3.Sum256([]byte(url))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Child creates a task for a URL discovered while processing t, one level
+// deeper in the same seed's crawl.
+func (t *Task) Child(topic, url string, source string, discovery string) *Task {
+	c := NewTask(topic, url, source, "")
+	c.Depth = t.Depth + 1
+	c.MaxDepth = t.MaxDepth
+	c.ParentURL = t.URL
+	c.SeedID = t.SeedID
+	c.Discovery = discovery
+	c.JobID = t.JobID
+	return c
+}
+
+// Next creates a fresh task for the same URL and lineage as t, used when a
+// URL moves on to its next processing stage.
+func (t *Task) Next(topic string, source string) *Task {
+	n := NewTask(topic, t.URL, source, "")
+	n.Depth = t.Depth
+	n.MaxDepth = t.MaxDepth
+	n.ParentURL = t.ParentURL
+	n.SeedID = t.SeedID
+	n.Discovery = t.Discovery
+	n.JobID = t.JobID
+	n.LastMod, n.ChangeFreq, n.Priority = t.LastMod, t.ChangeFreq, t.Priority
+	return n
+}
+
+// WithinDepth reports whether the task is within its seed's max depth.
+func (t *Task) WithinDepth() bool {
+	return t.MaxDepth <= 0 || t.Depth <= t.MaxDepth
+}
+
+// decodeLegacyMap reads the flat field map streams held before the
+// versioned codec. That encoder wrote the next attempt time as "backoff".
+func (m *Task) decodeLegacyMap(val map[string]any, source string) error {
+	if backoff, ok := val["backoff"]; ok {
+		if _, set := val["next_attempt_at"]; !set {
+			val["next_attempt_at"] = backoff
+		}
+		delete(val, "backoff")
+	}
 	if retries, ok := val["retries"].(string); ok {
 		r, err := strconv.Atoi(retries)
 		if err != nil {
@@ -46,6 +134,22 @@ func (m *Task) Decode(val map[string]any, source string) error {
 		}
 		val["retries"] = r
 	}
+	for _, key := range []string{"depth", "max_depth"} {
+		if v, ok := val[key].(string); ok {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("invalid %s value: %w", key, err)
+			}
+			val[key] = n
+		}
+	}
+	var attempts []Attempt
+	if a, ok := val["attempts"].(string); ok {
+		if err := json.Unmarshal([]byte(a), &attempts); err != nil {
+			return fmt.Errorf("invalid attempts value: %w", err)
+		}
+		delete(val, "attempts")
+	}
 	if n, ok := val["next_attempt_at"].(string); ok {
 		nextAttemptAt, err := strconv.Atoi(n)
 		if err != nil {
@@ -69,40 +173,55 @@ func (m *Task) Decode(val map[string]any, source string) error {
 		return fmt.Errorf("Task parsing failed: %w", err)
 	}
 	m.SourceName = source
+	m.Attempts = attempts
 	return nil
 }
 
-func (t *Task) EncodeToStr() (string, error) {
-	str, err := json.Marshal(t)
-	if err != nil {
-		return "", fmt.Errorf("failed to encode task: %v", err)
+func (m *Task) IsValid() bool {
+	if m == nil || m.Exhausted() || m.Retries < 0 || m.Topic == "" || m.URL == "" {
+		return false
 	}
-	return string(str), nil
+	return true
+}
+
+// Exhausted reports whether the task has used up all of its retries.
+func (t *Task) Exhausted() bool {
+	return t.Retries >= t.maxAttempts()
 }
 
-func (m *Task) Encode() (map[string]any, error) {
-	if !m.IsValid() {
-		return nil, utils.ErrInvalidTaskFormat(m)
+func (t *Task) maxAttempts() int {
+	if t.MaxAttempts > 0 {
+		return t.MaxAttempts
 	}
-	return map[string]any{
-		"topic":   m.Topic,
-		"retries": m.Retries,
-		"url":     m.URL,
-		"data_id": m.DataID,
-		"backoff": m.NextAttemptAt,
-	}, nil
+	return MaxRetries
 }
 
-func (m *Task) IsValid() bool {
-	if m == nil || m.Retries >= 5 || m.Retries < 0 || m.Topic == "" || m.URL == "" {
-		return false
+// RecordAttempt appends a failed attempt to the task history.
+func (t *Task) RecordAttempt(err error) {
+	if err == nil {
+		return
 	}
-	return true
+	t.Attempts = append(t.Attempts, Attempt{
+		At:         time.Now(),
+		Error:      err.Error(),
+		ErrorClass: utils.ClassifyError(err),
+	})
 }
 
-func (t *Task) CountNextAttemptAt() {
-	newBackoffDuration := time.Duration(int(float64(baseDelay)*math.Pow(float64(multiplier), float64(t.Retries-1)))) * time.Second
+// LastAttempt returns the most recent failed attempt, if any.
+func (t *Task) LastAttempt() (Attempt, bool) {
+	if len(t.Attempts) == 0 {
+		return Attempt{}, false
+	}
+	return t.Attempts[len(t.Attempts)-1], true
+}
 
-	t.NextAttemptAt = time.Now().Add(newBackoffDuration).Unix()
-	log.Printf("Next attempt at: %v And new backoff:%v", time.Unix(t.NextAttemptAt, 0), newBackoffDuration)
+// CountNextAttemptAt schedules the next attempt after the delay the retry
+// policy recorded with the last attempt. A task without one is due at once.
+func (t *Task) CountNextAttemptAt() {
+	var delay time.Duration
+	if last, ok := t.LastAttempt(); ok {
+		delay = last.Delay
+	}
+	t.NextAttemptAt = time.Now().Add(delay).Unix()
 }
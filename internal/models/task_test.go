@@ -22,11 +22,8 @@ func TestEncodeMessage(t *testing.T) {
 			msg:  models.NewTask("test-topic", "test.com", queue.HighPriorityQueue, "data-123"),
 			err:  nil,
 			expected: map[string]any{
-				"topic":   "test-topic",
-				"retries": 0,
-				"backoff": int64(0),
-				"url":     "test.com",
-				"data_id": "data-123",
+				"v":    models.TaskCodecVersion,
+				"task": `{"v":2,"topic":"test-topic","url":"test.com","data_id":"data-123","source":"queue:fetch:high"}`,
 			},
 		},
 		{
@@ -83,6 +80,31 @@ func TestDecodeMessage(t *testing.T) {
 			err:      nil,
 			expected: models.NewTask("test-topic", "test.com", queue.HighPriorityQueue, "data-123"),
 		},
+		{
+			name: "lineage from a stream entry",
+			input: map[string]any{
+				"topic":      "test-topic",
+				"retries":    "0",
+				"url":        "test.com/child",
+				"data_id":    "",
+				"depth":      "2",
+				"max_depth":  "3",
+				"parent_url": "test.com",
+				"seed_id":    "abc",
+				"discovery":  models.DiscoveryLink,
+			},
+			err: nil,
+			expected: &models.Task{
+				Topic:      "test-topic",
+				URL:        "test.com/child",
+				Depth:      2,
+				MaxDepth:   3,
+				ParentURL:  "test.com",
+				SeedID:     "abc",
+				Discovery:  models.DiscoveryLink,
+				SourceName: queue.HighPriorityQueue,
+			},
+		},
 		{
 			name: "invalid retries type",
 			input: map[string]any{
@@ -113,3 +135,23 @@ func TestDecodeMessage(t *testing.T) {
 		})
 	}
 }
+
+func TestTaskLineage(t *testing.T) {
+	seed := models.NewSeedTask("fetch_rules", "https://example.com", queue.HighPriorityQueue, 1)
+	assert.Equal(t, models.DiscoverySeed, seed.Discovery)
+	assert.Equal(t, models.SeedID("https://example.com"), seed.SeedID)
+	assert.True(t, seed.WithinDepth())
+
+	page := seed.Next("crawl_page", queue.MediumPriorityQueue)
+	assert.Equal(t, 0, page.Depth)
+	assert.Equal(t, seed.SeedID, page.SeedID)
+
+	child := page.Child("crawl_page", "https://example.com/a", queue.MediumPriorityQueue, models.DiscoveryLink)
+	assert.Equal(t, 1, child.Depth)
+	assert.Equal(t, "https://example.com", child.ParentURL)
+	assert.Equal(t, seed.SeedID, child.SeedID)
+	assert.True(t, child.WithinDepth())
+
+	grandchild := child.Child("crawl_page", "https://example.com/b", queue.MediumPriorityQueue, models.DiscoveryLink)
+	assert.False(t, grandchild.WithinDepth(), "max depth of the seed is inherited")
+}
@@ -1,6 +1,7 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net/url"
@@ -8,52 +9,59 @@ import (
 	"golang.org/x/net/html"
 )
 
+// GetURLsFromHTML returns the http and https links of the document in
+// htmlBody, resolved against base. The document is tokenized as it is read
+// rather than parsed into a tree, so only the current token is held in
+// memory.
 func GetURLsFromHTML(htmlBody io.Reader, base *url.URL) ([]string, error) {
-	node, err := html.Parse(htmlBody)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse HTML: %w", err)
-	}
-
 	urls := make([]string, 0)
 
-	var extractLinks func(*html.Node)
-	extractLinks = func(n *html.Node) {
-		if n == nil {
-			return
-		}
-
-		if n.Type == html.ElementNode && n.Data == "a" {
-			for _, attr := range n.Attr {
-				if attr.Key == "href" {
-					parsedURL, err := url.Parse(attr.Val)
-					if err != nil {
-						fmt.Printf("Skipping invalid URL: %v\n", err)
-						continue
-					}
-
-					if !parsedURL.IsAbs() {
-						parsedURL = base.ResolveReference(parsedURL)
-					}
-
-					if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
-						continue
-					}
-
-					if parsedURL.Host == "" {
-						continue
-					}
-
-					urls = append(urls, parsedURL.String())
-					break
+	z := html.NewTokenizer(htmlBody)
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			if errors.Is(z.Err(), io.EOF) {
+				return urls, nil
+			}
+			return nil, fmt.Errorf("failed to parse HTML: %w", z.Err())
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			if string(name) != "a" {
+				continue
+			}
+			for hasAttr {
+				var key, val []byte
+				key, val, hasAttr = z.TagAttr()
+				if string(key) != "href" {
+					continue
+				}
+				if u, ok := resolveLink(string(val), base); ok {
+					urls = append(urls, u)
 				}
+				break
 			}
 		}
+	}
+}
+
+func resolveLink(href string, base *url.URL) (string, bool) {
+	parsedURL, err := url.Parse(href)
+	if err != nil {
+		fmt.Printf("Skipping invalid URL: %v\n", err)
+		return "", false
+	}
+
+	if !parsedURL.IsAbs() {
+		parsedURL = base.ResolveReference(parsedURL)
+	}
 
-		extractLinks(n.FirstChild)
-		extractLinks(n.NextSibling)
+	if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
+		return "", false
 	}
 
-	extractLinks(node)
+	if parsedURL.Host == "" {
+		return "", false
+	}
 
-	return urls, nil
+	return parsedURL.String(), true
 }
@@ -0,0 +1,175 @@
+package politeness
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/NesterovYehor/Crawler/internal/config"
+	"github.com/redis/go-redis/v9"
+)
+
+// originSweepInterval is how often idle origins are forgotten.
+const originSweepInterval = time.Minute
+
+// OriginLimiter caps the requests in flight to an origin and spaces out
+// their starts. An origin is any key: a hostname, or the server IP it
+// resolves to, so that virtual hosts sharing a server share its budget.
+// With a Redis client the limits are shared by every process using it, like
+// the crawl delay of PolitenessManager; without one they hold per process.
+type OriginLimiter struct {
+	byIP          bool
+	maxConcurrent int
+	minInterval   time.Duration
+	shared        *redis.Client
+
+	mu      sync.Mutex
+	origins map[string]*origin
+	sweptAt time.Time
+	now     func() time.Time
+}
+
+type origin struct {
+	// slots holds a token per request in flight; nil means no cap.
+	slots chan struct{}
+	// next is the earliest start of the next request.
+	next time.Time
+	// refs counts the callers holding or waiting for the origin; an origin
+	// is only forgotten when it has none.
+	refs int
+}
+
+// NewOriginLimiter returns the limiter described by cfg, or nil if cfg sets
+// no limit. A nil client keeps the limits per process.
+func NewOriginLimiter(cfg *config.Politeness, client *redis.Client) *OriginLimiter {
+	if cfg.MaxConcurrent <= 0 && cfg.MinInterval <= 0 {
+		return nil
+	}
+	return &OriginLimiter{
+		byIP:          cfg.ByIP,
+		maxConcurrent: cfg.MaxConcurrent,
+		minInterval:   cfg.MinInterval,
+		shared:        client,
+		origins:       make(map[string]*origin),
+		now:           time.Now,
+	}
+}
+
+// ByIP reports whether requests are also limited by server IP.
+func (l *OriginLimiter) ByIP() bool {
+	return l.byIP
+}
+
+// Acquire waits until a request may start under the limits of every key
+// and returns the function that ends it. Keys are taken in sorted order so
+// that concurrent callers sharing keys cannot deadlock.
+func (l *OriginLimiter) Acquire(ctx context.Context, keys ...string) (func(), error) {
+	keys = slices.Compact(slices.Sorted(slices.Values(keys)))
+	if l.shared != nil {
+		return l.acquireShared(ctx, keys)
+	}
+	var held, taken []*origin
+	release := func() {
+		for _, o := range taken {
+			<-o.slots
+		}
+		l.unref(held)
+		held, taken = nil, nil
+	}
+
+	for _, key := range keys {
+		o := l.origin(key)
+		held = append(held, o)
+		if o.slots != nil {
+			select {
+			case o.slots <- struct{}{}:
+				taken = append(taken, o)
+			case <-ctx.Done():
+				release()
+				return nil, ctx.Err()
+			}
+		}
+	}
+
+	if l.minInterval > 0 {
+		if err := sleep(ctx, l.reserve(held)); err != nil {
+			release()
+			return nil, err
+		}
+	}
+	var once sync.Once
+	return func() { once.Do(release) }, nil
+}
+
+func (l *OriginLimiter) origin(key string) *origin {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep()
+	o, ok := l.origins[key]
+	if !ok {
+		o = &origin{}
+		if l.maxConcurrent > 0 {
+			o.slots = make(chan struct{}, l.maxConcurrent)
+		}
+		l.origins[key] = o
+	}
+	o.refs++
+	return o
+}
+
+func (l *OriginLimiter) unref(origins []*origin) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, o := range origins {
+		o.refs--
+	}
+}
+
+// sweep forgets the origins nobody holds whose interval has passed, so
+// the limiter does not grow with every host ever fetched. l.mu must be
+// held.
+func (l *OriginLimiter) sweep() {
+	now := l.now()
+	if now.Sub(l.sweptAt) < originSweepInterval {
+		return
+	}
+	l.sweptAt = now
+	for key, o := range l.origins {
+		if o.refs == 0 && !o.next.After(now) {
+			delete(l.origins, key)
+		}
+	}
+}
+
+// reserve books the next start allowed by all of origins and returns how
+// long to wait for it.
+func (l *OriginLimiter) reserve(origins []*origin) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	start := now
+	for _, o := range origins {
+		if o.next.After(start) {
+			start = o.next
+		}
+	}
+	for _, o := range origins {
+		o.next = start.Add(l.minInterval)
+	}
+	return start.Sub(now)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
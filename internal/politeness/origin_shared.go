@@ -0,0 +1,116 @@
+package politeness
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	originSlotsPrefix = "origin:slots:"
+	originNextPrefix  = "origin:next:"
+	// originLeaseTTL bounds how long a crashed process holds a slot. It is
+	// well above the client timeout, which covers reading the body.
+	originLeaseTTL = time.Minute
+	// originPollInterval is how often a full origin is asked again.
+	originPollInterval = 50 * time.Millisecond
+)
+
+// acquireOriginScript takes a slot of every origin, or none if one of them
+// is full, and books the next start allowed by all of them. KEYS are the
+// slot sets of the origins followed by their next start keys. Slots are
+// leases scored by their expiry, so those of a crashed process run out. It
+// returns -1 when an origin is full and otherwise how many milliseconds to
+// wait for the booked start. The time is the server's, so the clocks of
+// the processes sharing it do not matter.
+var acquireOriginScript = redis.NewScript(`
+local n = #KEYS / 2
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local lease, ttl, max, interval = ARGV[1], tonumber(ARGV[2]), tonumber(ARGV[3]), tonumber(ARGV[4])
+if max > 0 then
+    for i = 1, n do
+        redis.call("ZREMRANGEBYSCORE", KEYS[i], "-inf", now)
+        if redis.call("ZCARD", KEYS[i]) >= max then
+            return -1
+        end
+    end
+    for i = 1, n do
+        redis.call("ZADD", KEYS[i], now + ttl, lease)
+        redis.call("PEXPIRE", KEYS[i], ttl)
+    end
+end
+if interval <= 0 then
+    return 0
+end
+local start = now
+for i = n + 1, 2 * n do
+    local next = tonumber(redis.call("GET", KEYS[i]))
+    if next and next > start then
+        start = next
+    end
+end
+for i = n + 1, 2 * n do
+    redis.call("SET", KEYS[i], start + interval, "PX", start + interval - now)
+end
+return start - now
+`)
+
+// acquireShared is Acquire with the limits kept in Redis.
+func (l *OriginLimiter) acquireShared(ctx context.Context, keys []string) (func(), error) {
+	if len(keys) == 0 {
+		return func() {}, nil
+	}
+	redisKeys := make([]string, 0, 2*len(keys))
+	for _, key := range keys {
+		redisKeys = append(redisKeys, originSlotsPrefix+key)
+	}
+	for _, key := range keys {
+		redisKeys = append(redisKeys, originNextPrefix+key)
+	}
+	lease := newLease()
+
+	for {
+		wait, err := acquireOriginScript.Run(ctx, l.shared, redisKeys,
+			lease, originLeaseTTL.Milliseconds(), l.maxConcurrent, l.minInterval.Milliseconds(),
+		).Int64()
+		if err != nil {
+			return nil, fmt.Errorf("failed to acquire origin limit: %w", err)
+		}
+		if wait >= 0 {
+			release := func() {
+				if l.maxConcurrent <= 0 {
+					return
+				}
+				// The lease runs out by itself should this fail.
+				ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Second)
+				defer cancel()
+				pipe := l.shared.Pipeline()
+				for _, key := range redisKeys[:len(keys)] {
+					pipe.ZRem(ctx, key, lease)
+				}
+				pipe.Exec(ctx)
+			}
+			if err := sleep(ctx, time.Duration(wait)*time.Millisecond); err != nil {
+				release()
+				return nil, err
+			}
+			var once sync.Once
+			return func() { once.Do(release) }, nil
+		}
+		if err := sleep(ctx, originPollInterval); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func newLease() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,121 @@
+package politeness
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/NesterovYehor/Crawler/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewOriginLimiter(t *testing.T) {
+	assert.Nil(t, NewOriginLimiter(&config.Politeness{ByIP: true}, nil), "no limits means no limiter")
+	l := NewOriginLimiter(&config.Politeness{ByIP: true, MaxConcurrent: 1}, nil)
+	require.NotNil(t, l)
+	assert.True(t, l.ByIP())
+}
+
+func TestOriginLimiterCapsConcurrency(t *testing.T) {
+	l := NewOriginLimiter(&config.Politeness{MaxConcurrent: 2}, nil)
+	var inFlight, peak atomic.Int32
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Hosts a and b share one server; every request holds its IP.
+			host := "host:a"
+			if i%2 == 1 {
+				host = "host:b"
+			}
+			release, err := l.Acquire(context.Background(), host, "ip:10.0.0.1")
+			require.NoError(t, err)
+			defer release()
+			n := inFlight.Add(1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			inFlight.Add(-1)
+		}()
+	}
+	wg.Wait()
+	assert.EqualValues(t, 2, peak.Load())
+}
+
+func TestOriginLimiterSpacesRequests(t *testing.T) {
+	const interval = 50 * time.Millisecond
+	l := NewOriginLimiter(&config.Politeness{MinInterval: interval}, nil)
+	ctx := context.Background()
+
+	start := time.Now()
+	for range 3 {
+		release, err := l.Acquire(ctx, "host:a", "ip:10.0.0.1")
+		require.NoError(t, err)
+		release()
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 2*interval)
+
+	// Another host on the same server waits for the IP too.
+	release, err := l.Acquire(ctx, "host:b", "ip:10.0.0.1")
+	require.NoError(t, err)
+	release()
+	assert.GreaterOrEqual(t, time.Since(start), 3*interval)
+
+	// An unrelated origin does not.
+	before := time.Now()
+	release, err = l.Acquire(ctx, "host:c", "ip:10.0.0.2")
+	require.NoError(t, err)
+	release()
+	assert.Less(t, time.Since(before), interval)
+}
+
+func TestOriginLimiterGivesUpOnCancel(t *testing.T) {
+	l := NewOriginLimiter(&config.Politeness{MaxConcurrent: 1}, nil)
+	release, err := l.Acquire(context.Background(), "host:a", "ip:10.0.0.1")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = l.Acquire(ctx, "host:b", "ip:10.0.0.1")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// The cancelled caller released host:b, which it had taken first.
+	release2, err := l.Acquire(context.Background(), "host:b")
+	require.NoError(t, err)
+	release2()
+
+	release()
+	release()
+	release3, err := l.Acquire(context.Background(), "ip:10.0.0.1")
+	require.NoError(t, err)
+	release3()
+}
+
+func TestOriginLimiterForgetsIdleOrigins(t *testing.T) {
+	l := NewOriginLimiter(&config.Politeness{MaxConcurrent: 1, MinInterval: time.Second}, nil)
+	now := time.Unix(0, 0)
+	l.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	release, err := l.Acquire(ctx, "host:a")
+	require.NoError(t, err)
+	release()
+	held, err := l.Acquire(ctx, "host:b")
+	require.NoError(t, err)
+	defer held()
+
+	now = now.Add(originSweepInterval)
+	l.mu.Lock()
+	l.sweep()
+	l.mu.Unlock()
+	assert.NotContains(t, l.origins, "host:a")
+	assert.Contains(t, l.origins, "host:b", "an origin in use is kept")
+}
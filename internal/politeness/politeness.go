@@ -3,11 +3,14 @@ package politeness
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"math"
 	"time"
 
 	"github.com/NesterovYehor/Crawler/internal/config"
+	"github.com/NesterovYehor/Crawler/internal/identity"
 	"github.com/NesterovYehor/Crawler/internal/storage"
+	"github.com/redis/go-redis/v9"
 	"github.com/temoto/robotstxt"
 )
 
@@ -18,6 +21,9 @@ type PolitenessManager struct {
 type RateLimitResult struct {
 	Allowed bool
 	Rules   string
+	// delayUnknown is set until the crawl delay of the caller's product
+	// token is stored with the rules.
+	delayUnknown bool
 }
 
 func NewPM(st storage.Interface, cfg *config.Scripts) *PolitenessManager {
@@ -31,39 +37,48 @@ func NewPM(st storage.Interface, cfg *config.Scripts) *PolitenessManager {
 	}
 }
 
+// GetRules returns the robots.txt cached for key and whether its rate limit
+// lets a request through. Jobs of different product tokens share the rules,
+// so the crawl delay applied once the host's budget is spent is that of the
+// token on ctx. It is worked out from the rules the first time a token asks
+// and kept with them. It returns redis.Nil if no rules are cached.
 func (p *PolitenessManager) GetRules(key string, ctx context.Context) (*RateLimitResult, error) {
-	reply, err := p.st.RunScript(key, p.scripts["access"], ctx)
-	if err != nil {
+	token := identity.FromContext(ctx).Token()
+	reply, err := p.st.RunScript(key, p.scripts["access"], ctx, token)
+	if err == redis.Nil {
+		return nil, err
+	} else if err != nil {
 		return nil, fmt.Errorf("failed to get rules: %v", err)
 	}
 	res, err := parseScriptResult(reply)
 	if err != nil {
 		return nil, err
 	}
+	if res.delayUnknown {
+		if err := p.saveTokenDelay(ctx, key, token, res.Rules); err != nil {
+			slog.Warn("Politeness: failed to store crawl delay", "host", key, "token", token, "error", err)
+		}
+	}
 
 	return res, nil
 }
 
+// SaveRules caches the robots.txt of host with a fresh rate limit. The
+// rules are kept as they are, for GetRules to match against each reader's
+// product token; delay holds the crawl delay of the "*" group, for the
+// readers that have no token, such as the frontier, and the delay of the
+// token on ctx is stored along with it.
 func (p *PolitenessManager) SaveRules(ctx context.Context, host string, rawRules string) error {
 	rules, err := robotstxt.FromString(rawRules)
 	if err != nil {
 		return err
 	}
 
-	group := rules.FindGroup("MyCrawler")
-	if group == nil {
-		group = rules.FindGroup("*")
-	}
 	values := map[string]any{}
-
-	blockDelaySeconds := 1
-	if group.CrawlDelay != 0 {
-		blockDelaySeconds = int(math.Ceil(group.CrawlDelay.Seconds() * 1000))
-		if blockDelaySeconds == 0 {
-			blockDelaySeconds = 1
-		}
+	values["delay"] = crawlDelay(rules.FindGroup("*"))
+	if token := identity.FromContext(ctx).Token(); token != "" {
+		values[delayField(token)] = crawlDelay(rules.FindGroup(token))
 	}
-	values["delay"] = blockDelaySeconds
 
 	values["tokens_num"] = 0
 	values["max_tokens_num"] = 0
@@ -77,6 +92,26 @@ func (p *PolitenessManager) SaveRules(ctx context.Context, host string, rawRules
 	return nil
 }
 
+// saveTokenDelay stores the crawl delay of token's group in the rules of
+// host. rawRules are read back if the gate did not hand them out.
+func (p *PolitenessManager) saveTokenDelay(ctx context.Context, host, token, rawRules string) error {
+	if rawRules == "" {
+		var err error
+		if rawRules, err = p.st.GetCacheField(ctx, host, "rules"); err != nil {
+			return err
+		}
+	}
+	rules, err := robotstxt.FromString(rawRules)
+	if err != nil {
+		return err
+	}
+	return p.st.SaveToCache(ctx, host, map[string]any{delayField(token): crawlDelay(rules.FindGroup(token))})
+}
+
+func delayField(token string) string {
+	return "delay:" + token
+}
+
 func (p *PolitenessManager) UpdateHostLimit(key string, ctx context.Context) error {
 	_, err := p.st.RunScript(key, p.scripts["update"], ctx)
 	if err != nil {
@@ -85,9 +120,21 @@ func (p *PolitenessManager) UpdateHostLimit(key string, ctx context.Context) err
 	return nil
 }
 
+// crawlDelay returns the crawl delay of group in milliseconds.
+func crawlDelay(group *robotstxt.Group) int {
+	delay := 1
+	if group.CrawlDelay != 0 {
+		delay = int(math.Ceil(group.CrawlDelay.Seconds() * 1000))
+		if delay == 0 {
+			delay = 1
+		}
+	}
+	return delay
+}
+
 func parseScriptResult(result any) (*RateLimitResult, error) {
 	resSlice, ok := result.([]any)
-	if !ok || len(resSlice) != 2 {
+	if !ok || len(resSlice) < 2 {
 		return &RateLimitResult{Allowed: false}, nil
 	}
 
@@ -101,8 +148,15 @@ func parseScriptResult(result any) (*RateLimitResult, error) {
 		allowed = allowedVal == 1
 	}
 
+	var delayUnknown bool
+	if len(resSlice) > 2 {
+		known, _ := resSlice[2].(int64)
+		delayUnknown = known == 0
+	}
+
 	return &RateLimitResult{
-		Allowed: allowed,
-		Rules:   rules,
+		Allowed:      allowed,
+		Rules:        rules,
+		delayUnknown: delayUnknown,
 	}, nil
 }
@@ -0,0 +1,95 @@
+package profiles
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/net/publicsuffix"
+)
+
+// jarTTL is how long a jar nothing was stored in is kept. A cancelled job
+// deletes its jar at once.
+const jarTTL = 7 * 24 * time.Hour
+
+// sharedJar keeps a job's cookies in a Redis hash, so every worker sends
+// the cookies any of them received. Each field holds a cookie as it was
+// set; the cookies of a request are those of a jar the fields are replayed
+// into, oldest first, which applies the cookie rules of net/http/cookiejar.
+type sharedJar struct {
+	ctx    context.Context
+	client *redis.Client
+	key    string
+	now    func() time.Time
+}
+
+// storedCookie is a cookie and the URL that set it. MaxAge is turned into
+// Expires when the cookie is stored, since it is replayed later.
+type storedCookie struct {
+	URL    string       `json:"url"`
+	Cookie *http.Cookie `json:"cookie"`
+	At     int64        `json:"at"`
+}
+
+func (j *sharedJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	now := j.now()
+	from := url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path}
+	fields := make([]any, 0, 2*len(cookies))
+	for _, c := range cookies {
+		c := *c
+		if c.MaxAge > 0 {
+			c.Expires = now.Add(time.Duration(c.MaxAge) * time.Second)
+			c.MaxAge = 0
+		}
+		raw, err := json.Marshal(storedCookie{URL: from.String(), Cookie: &c, At: now.UnixNano()})
+		if err != nil {
+			continue
+		}
+		field := strings.Join([]string{strings.ToLower(u.Hostname()), strings.ToLower(c.Domain), c.Path, c.Name}, ";")
+		fields = append(fields, field, raw)
+	}
+	if len(fields) == 0 {
+		return
+	}
+	_, err := j.client.TxPipelined(j.ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(j.ctx, j.key, fields...)
+		pipe.Expire(j.ctx, j.key, jarTTL)
+		return nil
+	})
+	if err != nil {
+		slog.Warn("Profiles: failed to store cookies", "jar", j.key, "error", err)
+	}
+}
+
+func (j *sharedJar) Cookies(u *url.URL) []*http.Cookie {
+	raw, err := j.client.HVals(j.ctx, j.key).Result()
+	if err != nil {
+		slog.Warn("Profiles: failed to load cookies", "jar", j.key, "error", err)
+		return nil
+	}
+	stored := make([]storedCookie, 0, len(raw))
+	for _, r := range raw {
+		var s storedCookie
+		if json.Unmarshal([]byte(r), &s) == nil && s.Cookie != nil {
+			stored = append(stored, s)
+		}
+	}
+	slices.SortFunc(stored, func(a, b storedCookie) int { return cmp.Compare(a.At, b.At) })
+
+	// cookiejar.New only fails on invalid options.
+	jar, _ := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	for _, s := range stored {
+		if from, err := url.Parse(s.URL); err == nil {
+			jar.SetCookies(from, []*http.Cookie{s.Cookie})
+		}
+	}
+	return jar.Cookies(u)
+}
@@ -0,0 +1,262 @@
+package profiles
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NesterovYehor/Crawler/internal/config"
+	"github.com/NesterovYehor/Crawler/internal/jobs"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/net/publicsuffix"
+)
+
+// maxJars bounds the cookie jars kept for jobs; the least recently used is
+// dropped first.
+const maxJars = 1024
+
+// Set holds the request profiles of the configured domains and the cookie
+// jars of the jobs that use them.
+type Set struct {
+	profiles []*profile
+
+	// client holds the jars in Redis, shared by all workers. Without it
+	// they are kept in jars.
+	client *redis.Client
+
+	mu   sync.Mutex
+	jars map[string]*jobJar
+
+	now func() time.Time
+}
+
+type profile struct {
+	domain        string
+	headers       http.Header
+	authorization string
+	cookies       bool
+}
+
+type jobJar struct {
+	jar  *cookiejar.Jar
+	used time.Time
+}
+
+// New returns the profiles described by cfg, or nil if there are none. It
+// reads their credentials now, so a missing secret fails at startup. The
+// cookie jars are kept in Redis if client is set, and in memory otherwise.
+func New(cfg []config.Profile, client *redis.Client) (*Set, error) {
+	if len(cfg) == 0 {
+		return nil, nil
+	}
+	s := &Set{client: client, jars: make(map[string]*jobJar), now: time.Now}
+	for _, c := range cfg {
+		p, err := newProfile(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid profile for %q: %w", c.Domain, err)
+		}
+		s.profiles = append(s.profiles, p)
+	}
+	return s, nil
+}
+
+func newProfile(c config.Profile) (*profile, error) {
+	domain := strings.ToLower(strings.TrimSuffix(c.Domain, "."))
+	if domain == "" {
+		return nil, errors.New("no domain")
+	}
+	p := &profile{domain: domain, headers: make(http.Header), cookies: c.Cookies}
+	for k, v := range c.Headers {
+		p.headers.Set(k, v)
+	}
+
+	hasBasic := c.Basic.Username != "" || c.Basic.Password != (config.Secret{})
+	hasBearer := c.Bearer != (config.Secret{})
+	switch {
+	case hasBasic && hasBearer:
+		return nil, errors.New("both basic and bearer credentials")
+	case hasBasic:
+		password, err := readSecret(c.Basic.Password)
+		if err != nil {
+			return nil, fmt.Errorf("basic password: %w", err)
+		}
+		p.authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(c.Basic.Username+":"+password))
+	case hasBearer:
+		token, err := readSecret(c.Bearer)
+		if err != nil {
+			return nil, fmt.Errorf("bearer token: %w", err)
+		}
+		p.authorization = "Bearer " + token
+	}
+	if p.authorization != "" {
+		p.headers.Del("Authorization")
+	}
+	return p, nil
+}
+
+// readSecret returns the value of the variable or the contents of the file
+// s names, without a trailing newline.
+func readSecret(s config.Secret) (string, error) {
+	switch {
+	case s.Env != "" && s.File != "":
+		return "", errors.New("both env and file are set")
+	case s.Env != "":
+		v, ok := os.LookupEnv(s.Env)
+		if !ok || v == "" {
+			return "", fmt.Errorf("environment variable %s is not set", s.Env)
+		}
+		return v, nil
+	case s.File != "":
+		data, err := os.ReadFile(s.File)
+		if err != nil {
+			return "", err
+		}
+		v := strings.TrimRight(string(data), "\r\n")
+		if v == "" {
+			return "", fmt.Errorf("%s is empty", s.File)
+		}
+		return v, nil
+	}
+	return "", errors.New("no env or file set")
+}
+
+// match returns the profile of the most specific domain host belongs to.
+func (s *Set) match(host string) *profile {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	var best *profile
+	for _, p := range s.profiles {
+		if (host == p.domain || strings.HasSuffix(host, "."+p.domain)) && (best == nil || len(p.domain) > len(best.domain)) {
+			best = p
+		}
+	}
+	return best
+}
+
+// Apply sets the headers and credentials of the profile of req's host.
+func (s *Set) Apply(req *http.Request) {
+	p := s.match(req.URL.Hostname())
+	if p == nil {
+		return
+	}
+	for k, v := range p.headers {
+		req.Header[k] = v
+	}
+	if p.authorization != "" {
+		req.Header.Set("Authorization", p.authorization)
+	}
+}
+
+// CheckRedirect is called for each redirect the client follows. The client
+// copies the first request's headers to every redirect; once a redirect
+// leaves the first request's host or downgrades it to plain HTTP, the
+// headers and credentials its profile added are removed.
+func (s *Set) CheckRedirect(req *http.Request, via []*http.Request) error {
+	first := via[0]
+	if sameOrigin(first.URL, req.URL) {
+		return nil
+	}
+	if p := s.match(first.URL.Hostname()); p != nil {
+		for k := range p.headers {
+			req.Header.Del(k)
+		}
+		if p.authorization != "" {
+			req.Header.Del("Authorization")
+		}
+	}
+	return nil
+}
+
+func sameOrigin(from, to *url.URL) bool {
+	if !strings.EqualFold(from.Host, to.Host) {
+		return false
+	}
+	return from.Scheme == to.Scheme || to.Scheme == "https"
+}
+
+// Jar returns the cookie jar of the job set on ctx with WithJob, or nil if
+// no profile keeps cookies. It only stores and sends cookies for the hosts
+// of such profiles.
+func (s *Set) Jar(ctx context.Context) http.CookieJar {
+	if !s.keepsCookies() {
+		return nil
+	}
+	job, _ := ctx.Value(jobKey{}).(string)
+	if s.client != nil {
+		return &filteredJar{jar: &sharedJar{ctx: ctx, client: s.client, key: jobs.CookieJar(job), now: s.now}, set: s}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jars[job]
+	if !ok {
+		if len(s.jars) >= maxJars {
+			s.evictOldest()
+		}
+		// cookiejar.New only fails on invalid options.
+		jar, _ := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+		j = &jobJar{jar: jar}
+		s.jars[job] = j
+	}
+	j.used = s.now()
+	return &filteredJar{jar: j.jar, set: s}
+}
+
+func (s *Set) keepsCookies() bool {
+	for _, p := range s.profiles {
+		if p.cookies {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Set) evictOldest() {
+	var oldest string
+	var at time.Time
+	for job, j := range s.jars {
+		if at.IsZero() || j.used.Before(at) {
+			oldest, at = job, j.used
+		}
+	}
+	delete(s.jars, oldest)
+}
+
+// filteredJar keeps cookies only for the hosts of profiles with cookies.
+type filteredJar struct {
+	jar http.CookieJar
+	set *Set
+}
+
+func (j *filteredJar) allowed(u *url.URL) bool {
+	p := j.set.match(u.Hostname())
+	return p != nil && p.cookies
+}
+
+func (j *filteredJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	if j.allowed(u) {
+		j.jar.SetCookies(u, cookies)
+	}
+}
+
+func (j *filteredJar) Cookies(u *url.URL) []*http.Cookie {
+	if !j.allowed(u) {
+		return nil
+	}
+	return j.jar.Cookies(u)
+}
+
+type jobKey struct{}
+
+// WithJob returns a context whose requests use the cookie jar of job.
+// Requests outside of any job share one jar.
+func WithJob(ctx context.Context, job string) context.Context {
+	return context.WithValue(ctx, jobKey{}, job)
+}
@@ -0,0 +1,131 @@
+package profiles
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/NesterovYehor/Crawler/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewValidates(t *testing.T) {
+	t.Setenv("PROFILE_TEST_EMPTY", "")
+	for name, p := range map[string]config.Profile{
+		"domain":      {Headers: map[string]string{"X-Key": "v"}},
+		"unset env":   {Domain: "example.com", Bearer: config.Secret{Env: "PROFILE_TEST_UNSET"}},
+		"empty env":   {Domain: "example.com", Bearer: config.Secret{Env: "PROFILE_TEST_EMPTY"}},
+		"no source":   {Domain: "example.com", Basic: config.BasicAuth{Username: "crawler"}},
+		"both":        {Domain: "example.com", Basic: config.BasicAuth{Username: "u", Password: config.Secret{Env: "HOME"}}, Bearer: config.Secret{Env: "HOME"}},
+		"env or file": {Domain: "example.com", Bearer: config.Secret{Env: "HOME", File: "/etc/hostname"}},
+		"file":        {Domain: "example.com", Bearer: config.Secret{File: "/does/not/exist"}},
+	} {
+		_, err := New([]config.Profile{p}, nil)
+		assert.Error(t, err, name)
+	}
+
+	s, err := New(nil, nil)
+	require.NoError(t, err)
+	assert.Nil(t, s, "no profiles means no set")
+}
+
+func TestApply(t *testing.T) {
+	token := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(token, []byte("s3cret\n"), 0o600))
+	t.Setenv("PROFILE_TEST_PASSWORD", "hunter2")
+	s, err := New([]config.Profile{
+		{Domain: "example.com", Headers: map[string]string{"x-crawler-key": "abc"}, Bearer: config.Secret{File: token}},
+		{Domain: "intranet.example.com.", Basic: config.BasicAuth{Username: "crawler", Password: config.Secret{Env: "PROFILE_TEST_PASSWORD"}}},
+	}, nil)
+	require.NoError(t, err)
+
+	apply := func(rawURL string) http.Header {
+		req, err := http.NewRequest("GET", rawURL, nil)
+		require.NoError(t, err)
+		s.Apply(req)
+		return req.Header
+	}
+
+	h := apply("https://www.example.com/")
+	assert.Equal(t, "Bearer s3cret", h.Get("Authorization"))
+	assert.Equal(t, "abc", h.Get("X-Crawler-Key"))
+
+	h = apply("https://Intranet.Example.com/")
+	user, pass, ok := (&http.Request{Header: h}).BasicAuth()
+	require.True(t, ok, "the most specific domain wins")
+	assert.Equal(t, "crawler", user)
+	assert.Equal(t, "hunter2", pass)
+	assert.Empty(t, h.Get("X-Crawler-Key"))
+
+	assert.Empty(t, apply("https://notexample.com/"))
+}
+
+func TestCheckRedirectDropsCredentials(t *testing.T) {
+	t.Setenv("PROFILE_TEST_TOKEN", "t0ken")
+	s, err := New([]config.Profile{{
+		Domain:  "example.com",
+		Headers: map[string]string{"X-Crawler-Key": "abc"},
+		Bearer:  config.Secret{Env: "PROFILE_TEST_TOKEN"},
+	}}, nil)
+	require.NoError(t, err)
+
+	first, _ := http.NewRequest("GET", "https://example.com/a", nil)
+	s.Apply(first)
+	redirect := func(rawURL string) http.Header {
+		req, _ := http.NewRequest("GET", rawURL, nil)
+		req.Header = first.Header.Clone()
+		require.NoError(t, s.CheckRedirect(req, []*http.Request{first}))
+		return req.Header
+	}
+
+	assert.Equal(t, "Bearer t0ken", redirect("https://example.com/b").Get("Authorization"))
+	for _, to := range []string{"https://www.example.com/", "https://example.com:8443/", "http://example.com/", "https://evil.test/"} {
+		h := redirect(to)
+		assert.Empty(t, h.Get("Authorization"), to)
+		assert.Empty(t, h.Get("X-Crawler-Key"), to)
+	}
+}
+
+func TestJarPerJob(t *testing.T) {
+	s, err := New([]config.Profile{
+		{Domain: "example.com", Cookies: true},
+		{Domain: "other.com", Headers: map[string]string{"X-Key": "v"}},
+	}, nil)
+	require.NoError(t, err)
+	u, _ := url.Parse("https://example.com/")
+	other, _ := url.Parse("https://other.com/")
+	session := []*http.Cookie{{Name: "session", Value: "1"}}
+
+	a := s.Jar(WithJob(context.Background(), "a"))
+	a.SetCookies(u, session)
+	a.SetCookies(other, session)
+	assert.Len(t, a.Cookies(u), 1)
+	assert.Empty(t, a.Cookies(other), "only profiles with cookies keep them")
+	assert.Len(t, s.Jar(WithJob(context.Background(), "a")).Cookies(u), 1, "the jar lasts for the job")
+	assert.Empty(t, s.Jar(WithJob(context.Background(), "b")).Cookies(u), "jobs do not share cookies")
+
+	noCookies, err := New([]config.Profile{{Domain: "example.com"}}, nil)
+	require.NoError(t, err)
+	assert.Nil(t, noCookies.Jar(context.Background()))
+}
+
+func TestJarEviction(t *testing.T) {
+	s, err := New([]config.Profile{{Domain: "example.com", Cookies: true}}, nil)
+	require.NoError(t, err)
+	now := time.Unix(0, 0)
+	s.now = func() time.Time { now = now.Add(time.Second); return now }
+
+	u, _ := url.Parse("https://example.com/")
+	s.Jar(WithJob(context.Background(), "first")).SetCookies(u, []*http.Cookie{{Name: "a", Value: "1"}})
+	for i := range maxJars {
+		s.Jar(WithJob(context.Background(), fmt.Sprintf("job-%d", i)))
+	}
+	assert.Len(t, s.jars, maxJars)
+	assert.Empty(t, s.Jar(WithJob(context.Background(), "first")).Cookies(u), "the least recently used jar was dropped")
+}
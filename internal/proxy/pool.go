@@ -0,0 +1,357 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/NesterovYehor/Crawler/internal/config"
+	"github.com/NesterovYehor/Crawler/internal/metrics"
+	"github.com/NesterovYehor/Crawler/internal/utils"
+)
+
+const (
+	Sticky        = "sticky"
+	RoundRobin    = "round_robin"
+	LeastFailures = "least_failures"
+
+	defaultMaxFailures = 3
+	defaultCooldown    = time.Minute
+	// maxStickyHosts bounds the hosts the sticky strategy remembers.
+	maxStickyHosts = 10000
+)
+
+// ValidStrategy reports whether s names a selection strategy.
+func ValidStrategy(s string) bool {
+	return s == Sticky || s == RoundRobin || s == LeastFailures
+}
+
+// Proxy is one outbound proxy and its health.
+type Proxy struct {
+	URL *url.URL
+	// Name identifies the proxy in metrics and logs without its credentials.
+	Name string
+
+	mu           sync.Mutex
+	consecutive  int
+	failures     int64
+	ejected      bool
+	ejectedUntil time.Time
+}
+
+// Pool picks a proxy for every request and ejects proxies that keep
+// failing until their cooldown has passed.
+type Pool struct {
+	proxies     []*Proxy
+	strategy    string
+	domains     []config.DomainProxy
+	maxFailures int
+	cooldown    time.Duration
+	metrics     metrics.ProxyMetrics
+	next        atomic.Uint64
+
+	mu sync.Mutex
+	// sticky holds the hosts that moved off the proxy their name hashes
+	// to; the others need not be remembered.
+	sticky map[string]*Proxy
+
+	now func() time.Time
+}
+
+// NewPool returns the pool described by cfg, or nil if cfg has no proxies.
+// A nil m records no metrics.
+func NewPool(cfg *config.Proxies, m metrics.ProxyMetrics) (*Pool, error) {
+	if len(cfg.URLs) == 0 {
+		return nil, nil
+	}
+	if m == nil {
+		m = noopMetrics{}
+	}
+	p := &Pool{
+		strategy:    cfg.Strategy,
+		domains:     cfg.Domains,
+		maxFailures: cfg.MaxFailures,
+		cooldown:    cfg.Cooldown,
+		metrics:     m,
+		sticky:      make(map[string]*Proxy),
+		now:         time.Now,
+	}
+	if p.strategy == "" {
+		p.strategy = RoundRobin
+	}
+	if !ValidStrategy(p.strategy) {
+		return nil, fmt.Errorf("unknown proxy strategy %q", p.strategy)
+	}
+	for _, d := range p.domains {
+		if d.Domain == "" || !ValidStrategy(d.Strategy) {
+			return nil, fmt.Errorf("invalid proxy strategy %q for domain %q", d.Strategy, d.Domain)
+		}
+	}
+	if p.maxFailures <= 0 {
+		p.maxFailures = defaultMaxFailures
+	}
+	if p.cooldown <= 0 {
+		p.cooldown = defaultCooldown
+	}
+
+	for _, raw := range cfg.URLs {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL %q: %w", raw, err)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme %q in %q", u.Scheme, u.Redacted())
+		}
+		if u.Host == "" {
+			return nil, fmt.Errorf("proxy URL %q has no host", u.Redacted())
+		}
+		px := &Proxy{URL: u, Name: u.Scheme + "://" + u.Host}
+		p.proxies = append(p.proxies, px)
+		m.SetHealthy(px.Name, true)
+	}
+	return p, nil
+}
+
+// StrategyFor returns the strategy for requests to host: override if it is
+// set, else the strategy of the host's domain, else the pool's.
+func (p *Pool) StrategyFor(host, override string) string {
+	if override != "" {
+		return override
+	}
+	host = strings.ToLower(host)
+	for _, d := range p.domains {
+		domain := strings.ToLower(d.Domain)
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return d.Strategy
+		}
+	}
+	return p.strategy
+}
+
+// Pick returns the proxy for a request to host. It fails with
+// utils.ErrNoProxy, retryable later, while every proxy is cooling down.
+func (p *Pool) Pick(host, strategy string) (*Proxy, error) {
+	now := p.now()
+	var px *Proxy
+	switch strategy {
+	case Sticky:
+		px = p.pickSticky(host, now)
+	case LeastFailures:
+		px = p.pickLeastFailures(now)
+	default:
+		px = p.pickRoundRobin(now)
+	}
+	if px == nil {
+		return nil, fmt.Errorf("%w: %w", utils.ErrRetryLater, utils.ErrNoProxy)
+	}
+	return px, nil
+}
+
+func (p *Pool) pickRoundRobin(now time.Time) *Proxy {
+	n := uint64(len(p.proxies))
+	start := p.next.Add(1) - 1
+	for i := uint64(0); i < n; i++ {
+		if px := p.proxies[(start+i)%n]; p.available(px, now) {
+			return px
+		}
+	}
+	return nil
+}
+
+func (p *Pool) pickLeastFailures(now time.Time) *Proxy {
+	var best *Proxy
+	var bestFailures int64
+	for _, px := range p.proxies {
+		if !p.available(px, now) {
+			continue
+		}
+		px.mu.Lock()
+		failures := px.failures
+		px.mu.Unlock()
+		if best == nil || failures < bestFailures {
+			best, bestFailures = px, failures
+		}
+	}
+	return best
+}
+
+// pickSticky keeps a host on the proxy it was given until that proxy is
+// ejected. New hosts start at a proxy chosen by a hash of the host name.
+// Only hosts that moved off that proxy are remembered, at most
+// maxStickyHosts of them; a forgotten host starts over at its hash.
+func (p *Pool) pickSticky(host string, now time.Time) *Proxy {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if px, ok := p.sticky[host]; ok && p.available(px, now) {
+		return px
+	}
+	delete(p.sticky, host)
+	h := fnv.New32a()
+	h.Write([]byte(host))
+	n := len(p.proxies)
+	start := int(h.Sum32() % uint32(n))
+	for i := 0; i < n; i++ {
+		if px := p.proxies[(start+i)%n]; p.available(px, now) {
+			if i > 0 {
+				p.remember(host, px)
+			}
+			return px
+		}
+	}
+	return nil
+}
+
+// remember keeps host on px, forgetting an arbitrary host once
+// maxStickyHosts are remembered. p.mu must be held.
+func (p *Pool) remember(host string, px *Proxy) {
+	if len(p.sticky) >= maxStickyHosts {
+		for h := range p.sticky {
+			delete(p.sticky, h)
+			break
+		}
+	}
+	p.sticky[host] = px
+}
+
+// available reports whether px is in rotation and brings it back once its
+// cooldown has passed.
+func (p *Pool) available(px *Proxy, now time.Time) bool {
+	px.mu.Lock()
+	defer px.mu.Unlock()
+	if !px.ejected {
+		return true
+	}
+	if now.Before(px.ejectedUntil) {
+		return false
+	}
+	px.ejected = false
+	p.metrics.SetHealthy(px.Name, true)
+	return true
+}
+
+// Report records the outcome of a request sent through px. After
+// maxFailures failures in a row px is ejected for the cooldown.
+func (p *Pool) Report(px *Proxy, failed bool, dur time.Duration) {
+	p.metrics.ObserveRequest(px.Name, failed, dur)
+	px.mu.Lock()
+	defer px.mu.Unlock()
+	if !failed {
+		px.consecutive = 0
+		return
+	}
+	px.failures++
+	px.consecutive++
+	if px.consecutive >= p.maxFailures && !px.ejected {
+		px.consecutive = 0
+		px.ejected = true
+		px.ejectedUntil = p.now().Add(p.cooldown)
+		p.metrics.ObserveEjection(px.Name)
+		p.metrics.SetHealthy(px.Name, false)
+	}
+}
+
+// ProxyFunc returns a function for http.Transport.Proxy that sends every
+// request through the pool.
+func (p *Pool) ProxyFunc() func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		ctx := req.Context()
+		px, err := p.Pick(req.URL.Hostname(), p.StrategyFor(req.URL.Hostname(), strategyFromContext(ctx)))
+		if err != nil {
+			return nil, err
+		}
+		if t, ok := ctx.Value(trackKey{}).(*tracker); ok {
+			t.add(px)
+		}
+		return px.URL, nil
+	}
+}
+
+// Track returns a context whose requests remember the proxies they used,
+// and a function that reports the outcome to them. failed tells whether
+// the proxy itself failed, see Failed; a CONNECT refused with 407 seen by
+// OnConnectResponse counts too. Only the last proxy of a redirect chain is
+// charged with a failure.
+func (p *Pool) Track(ctx context.Context) (context.Context, func(failed bool, dur time.Duration)) {
+	t := &tracker{}
+	return context.WithValue(ctx, trackKey{}, t), func(failed bool, dur time.Duration) {
+		used, connectFailed := t.used()
+		failed = failed || connectFailed
+		for i, px := range used {
+			p.Report(px, failed && i == len(used)-1, dur)
+		}
+	}
+}
+
+// OnConnectResponse is meant for http.Transport.OnProxyConnectResponse. It
+// notes a CONNECT the proxy refused for lack of credentials. Other refusals,
+// such as a 502 for a target the proxy could not reach, are not the proxy's
+// fault.
+func (p *Pool) OnConnectResponse(ctx context.Context, _ *url.URL, _ *http.Request, resp *http.Response) error {
+	if t, ok := ctx.Value(trackKey{}).(*tracker); ok && resp.StatusCode == http.StatusProxyAuthRequired {
+		t.mu.Lock()
+		t.connectFailed = true
+		t.mu.Unlock()
+	}
+	return nil
+}
+
+// Failed reports whether a request that ended with err, or with a response
+// of status, failed because of its proxy: the proxy could not be dialed or
+// asked for credentials. Failures of the target, such as an unknown host,
+// a refused connection or a bad certificate, are not the proxy's.
+func Failed(err error, status int) bool {
+	if err == nil {
+		return status == http.StatusProxyAuthRequired
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "proxyconnect"
+}
+
+type trackKey struct{}
+
+type tracker struct {
+	mu            sync.Mutex
+	proxies       []*Proxy
+	connectFailed bool
+}
+
+func (t *tracker) add(px *Proxy) {
+	t.mu.Lock()
+	t.proxies = append(t.proxies, px)
+	t.mu.Unlock()
+}
+
+func (t *tracker) used() ([]*Proxy, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.proxies, t.connectFailed
+}
+
+type strategyKey struct{}
+
+// WithStrategy returns a context whose requests use strategy instead of the
+// pool's or their domain's.
+func WithStrategy(ctx context.Context, strategy string) context.Context {
+	return context.WithValue(ctx, strategyKey{}, strategy)
+}
+
+func strategyFromContext(ctx context.Context) string {
+	s, _ := ctx.Value(strategyKey{}).(string)
+	return s
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) ObserveRequest(string, bool, time.Duration) {}
+func (noopMetrics) ObserveEjection(string)                     {}
+func (noopMetrics) SetHealthy(string, bool)                    {}
@@ -0,0 +1,143 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/NesterovYehor/Crawler/internal/config"
+	"github.com/NesterovYehor/Crawler/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestPool(t *testing.T, cfg config.Proxies) (*Pool, *time.Time) {
+	cfg.URLs = []string{"http://a:8080", "http://b:8080", "socks5://c:1080"}
+	p, err := NewPool(&cfg, noopMetrics{})
+	require.NoError(t, err)
+	now := time.Unix(0, 0)
+	p.now = func() time.Time { return now }
+	return p, &now
+}
+
+func pick(t *testing.T, p *Pool, host, strategy string) string {
+	px, err := p.Pick(host, strategy)
+	require.NoError(t, err)
+	return px.Name
+}
+
+func TestNewPoolValidates(t *testing.T) {
+	var m noopMetrics
+	for name, cfg := range map[string]config.Proxies{
+		"scheme":          {URLs: []string{"ftp://a:21"}},
+		"host":            {URLs: []string{"http://"}},
+		"strategy":        {URLs: []string{"http://a:8080"}, Strategy: "random"},
+		"domain_strategy": {URLs: []string{"http://a:8080"}, Domains: []config.DomainProxy{{Domain: "example.com", Strategy: "random"}}},
+	} {
+		_, err := NewPool(&cfg, m)
+		assert.Error(t, err, name)
+	}
+
+	p, err := NewPool(&config.Proxies{}, m)
+	require.NoError(t, err)
+	assert.Nil(t, p, "no proxies means no pool")
+
+	p, err = NewPool(&config.Proxies{URLs: []string{"http://a:8080"}}, nil)
+	require.NoError(t, err)
+	p.Report(p.proxies[0], true, 0)
+}
+
+func TestStrategies(t *testing.T) {
+	p, _ := newTestPool(t, config.Proxies{})
+
+	var rotated []string
+	for range 4 {
+		rotated = append(rotated, pick(t, p, "example.com", RoundRobin))
+	}
+	assert.Equal(t, []string{"http://a:8080", "http://b:8080", "socks5://c:1080", "http://a:8080"}, rotated)
+
+	first := pick(t, p, "example.com", Sticky)
+	for range 3 {
+		assert.Equal(t, first, pick(t, p, "example.com", Sticky))
+	}
+
+	a, _ := p.Pick("", RoundRobin)
+	p.Report(a, true, 0)
+	assert.NotEqual(t, a.Name, pick(t, p, "example.com", LeastFailures))
+}
+
+func TestStrategyFor(t *testing.T) {
+	p, _ := newTestPool(t, config.Proxies{
+		Strategy: LeastFailures,
+		Domains:  []config.DomainProxy{{Domain: "Example.com", Strategy: Sticky}},
+	})
+	assert.Equal(t, Sticky, p.StrategyFor("www.example.com", ""))
+	assert.Equal(t, LeastFailures, p.StrategyFor("example.org", ""))
+	assert.Equal(t, RoundRobin, p.StrategyFor("example.com", RoundRobin), "a job override wins")
+}
+
+func TestEjectionAndCooldown(t *testing.T) {
+	p, now := newTestPool(t, config.Proxies{MaxFailures: 2, Cooldown: time.Minute})
+	sticky, err := p.Pick("example.com", Sticky)
+	require.NoError(t, err)
+
+	p.Report(sticky, true, 0)
+	assert.Equal(t, sticky.Name, pick(t, p, "example.com", Sticky), "one failure does not eject")
+	p.Report(sticky, true, 0)
+	moved := pick(t, p, "example.com", Sticky)
+	assert.NotEqual(t, sticky.Name, moved, "the host moves off an ejected proxy")
+	assert.Equal(t, moved, pick(t, p, "example.com", Sticky))
+	assert.Len(t, p.sticky, 1, "only the moved host is remembered")
+
+	*now = now.Add(time.Minute)
+	assert.True(t, p.available(sticky, *now), "the proxy returns after its cooldown")
+
+	for _, px := range p.proxies {
+		p.Report(px, true, 0)
+		p.Report(px, true, 0)
+	}
+	_, err = p.Pick("example.com", RoundRobin)
+	assert.True(t, errors.Is(err, utils.ErrNoProxy) && errors.Is(err, utils.ErrRetryLater))
+}
+
+func TestStickyHostsBounded(t *testing.T) {
+	p, _ := newTestPool(t, config.Proxies{MaxFailures: 1})
+	p.Report(p.proxies[0], true, 0)
+	p.Report(p.proxies[1], true, 0)
+	for i := range maxStickyHosts + 10 {
+		assert.Equal(t, "socks5://c:1080", pick(t, p, fmt.Sprintf("host%d.example.com", i), Sticky))
+	}
+	assert.LessOrEqual(t, len(p.sticky), maxStickyHosts)
+	assert.Greater(t, len(p.sticky), maxStickyHosts/2, "hosts hashed to an ejected proxy are remembered")
+}
+
+func TestFailed(t *testing.T) {
+	dial := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	proxyDial := &net.OpError{Op: "proxyconnect", Net: "tcp", Err: dial}
+
+	assert.True(t, Failed(&url.Error{Op: "Get", URL: "http://example.com", Err: proxyDial}, 0), "the proxy could not be dialed")
+	assert.True(t, Failed(nil, http.StatusProxyAuthRequired))
+	assert.False(t, Failed(&url.Error{Op: "Get", URL: "http://example.com", Err: dial}, 0), "the target refused")
+	assert.False(t, Failed(&net.DNSError{Err: "no such host", Name: "example.com", IsNotFound: true}, 0))
+	assert.False(t, Failed(nil, http.StatusBadGateway))
+}
+
+func TestConnectResponse(t *testing.T) {
+	p, _ := newTestPool(t, config.Proxies{MaxFailures: 1})
+	px := p.proxies[0]
+	connect := func(status int) {
+		ctx, report := p.Track(context.Background())
+		ctx.Value(trackKey{}).(*tracker).add(px)
+		require.NoError(t, p.OnConnectResponse(ctx, nil, nil, &http.Response{StatusCode: status}))
+		report(false, 0)
+	}
+	connect(http.StatusBadGateway)
+	assert.True(t, p.available(px, p.now()), "the target was unreachable")
+	connect(http.StatusProxyAuthRequired)
+	assert.False(t, p.available(px, p.now()), "the proxy refused the credentials")
+}
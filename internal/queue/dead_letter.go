@@ -0,0 +1,214 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/NesterovYehor/Crawler/internal/models"
+	"github.com/NesterovYehor/Crawler/internal/utils"
+	"github.com/redis/go-redis/v9"
+)
+
+var DeadLetterQueue = "queue:dead"
+
+// deadLetterPage is how many entries of the dead-letter queue are read at a
+// time.
+const deadLetterPage = 500
+
+// DeadLetterFilter selects dead letters. After is a cursor: only dead
+// letters with a greater ID are selected, so a long listing can be read a
+// page at a time by passing the ID of the last dead letter of a page.
+type DeadLetterFilter struct {
+	Host       string
+	ErrorClass string
+	Limit      int
+	After      string
+}
+
+func (f DeadLetterFilter) match(dl *models.DeadLetter) bool {
+	if f.After != "" && !idAfter(dl.ID, f.After) {
+		return false
+	}
+	if f.Host != "" && f.Host != dl.Host {
+		return false
+	}
+	if f.ErrorClass != "" && f.ErrorClass != dl.ErrorClass {
+		return false
+	}
+	return true
+}
+
+// idAfter reports whether the stream ID id is greater than cursor.
+func idAfter(id, cursor string) bool {
+	ms, seq := splitID(id)
+	cms, cseq := splitID(cursor)
+	return ms > cms || ms == cms && seq > cseq
+}
+
+func splitID(id string) (ms, seq uint64) {
+	msPart, seqPart, _ := strings.Cut(id, "-")
+	ms, _ = strconv.ParseUint(msPart, 10, 64)
+	seq, _ = strconv.ParseUint(seqPart, 10, 64)
+	return ms, seq
+}
+
+func (q *queue) DeadLetter(ctx context.Context, tasks []*models.Task) error {
+	pipe := q.client.Pipeline()
+	for _, t := range tasks {
+		if t == nil {
+			continue
+		}
+		host, err := utils.GetDomain(t.URL)
+		if err != nil {
+			slog.Warn("dead letter task has invalid url", "url", t.URL, "error", err)
+		}
+		val, err := models.NewDeadLetter(t, host).Encode()
+		if err != nil {
+			return err
+		}
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: DeadLetterQueue,
+			Values: val,
+		})
+	}
+	if pipe.Len() == 0 {
+		return nil
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to store dead letters: %w", err)
+	}
+	return nil
+}
+
+// scanDeadLetters reads the dead-letter queue a page at a time and hands
+// the dead letters of each page that match filter to fn, until filter.Limit
+// of them were handed on or the queue is read to the end.
+func (q *queue) scanDeadLetters(ctx context.Context, filter DeadLetterFilter, fn func([]*models.DeadLetter) error) error {
+	start, found := "-", 0
+	if filter.After != "" {
+		start = "(" + filter.After
+	}
+	for {
+		msgs, err := q.client.XRangeN(ctx, DeadLetterQueue, start, "+", deadLetterPage).Result()
+		if err != nil && err != redis.Nil {
+			return fmt.Errorf("failed to read dead letters: %w", err)
+		}
+		if len(msgs) == 0 {
+			return nil
+		}
+		start = "(" + msgs[len(msgs)-1].ID
+
+		var page []*models.DeadLetter
+		for _, msg := range msgs {
+			dl := &models.DeadLetter{}
+			if err := dl.Decode(msg.ID, msg.Values); err != nil {
+				slog.Error("skipping malformed dead letter", "id", msg.ID, "error", err)
+				continue
+			}
+			if !filter.match(dl) {
+				continue
+			}
+			page = append(page, dl)
+			if found++; filter.Limit > 0 && found >= filter.Limit {
+				break
+			}
+		}
+		if len(page) > 0 {
+			if err := fn(page); err != nil {
+				return err
+			}
+		}
+		if len(msgs) < deadLetterPage || filter.Limit > 0 && found >= filter.Limit {
+			return nil
+		}
+	}
+}
+
+func (q *queue) ListDeadLetters(ctx context.Context, filter DeadLetterFilter) ([]*models.DeadLetter, error) {
+	var res []*models.DeadLetter
+	err := q.scanDeadLetters(ctx, filter, func(page []*models.DeadLetter) error {
+		res = append(res, page...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (q *queue) PurgeDeadLetters(ctx context.Context, filter DeadLetterFilter) (int, error) {
+	purged := 0
+	err := q.scanDeadLetters(ctx, filter, func(page []*models.DeadLetter) error {
+		ids := make([]string, len(page))
+		for i, dl := range page {
+			ids[i] = dl.ID
+		}
+		n, err := q.client.XDel(ctx, DeadLetterQueue, ids...).Result()
+		if err != nil {
+			return fmt.Errorf("failed to purge dead letters: %w", err)
+		}
+		purged += int(n)
+		return nil
+	})
+	return purged, err
+}
+
+// ReplayDeadLetters moves matching dead letters back into target with a
+// fresh retry budget. The attempt history is kept on the task.
+func (q *queue) ReplayDeadLetters(ctx context.Context, filter DeadLetterFilter, target string) (int, error) {
+	if target != HighPriorityQueue && target != MediumPriorityQueue {
+		return 0, fmt.Errorf("cannot replay dead letters into %q", target)
+	}
+	replayed := 0
+	err := q.scanDeadLetters(ctx, filter, func(page []*models.DeadLetter) error {
+		for _, dl := range page {
+			ok, err := q.replayDeadLetter(ctx, dl, target)
+			if err != nil {
+				return err
+			}
+			if ok {
+				replayed++
+			}
+		}
+		return nil
+	})
+	return replayed, err
+}
+
+// replayDeadLetter moves dl into target and reports whether it could be
+// replayed at all.
+func (q *queue) replayDeadLetter(ctx context.Context, dl *models.DeadLetter, target string) (bool, error) {
+	task := dl.Task
+	task.ID = ""
+	task.Retries = 0
+	task.MaxAttempts = 0
+	task.NextAttemptAt = 0
+	task.SourceName = target
+	pipe := q.client.TxPipeline()
+	// Replays into the medium lane wait in the host frontier like new links.
+	if usesFrontier(target) {
+		pushed, err := pushFrontier(ctx, pipe, []*models.Task{task})
+		if err != nil || pushed == 0 {
+			slog.Error("skipping dead letter that cannot be replayed", "id", dl.ID, "error", err)
+			return false, nil
+		}
+	} else {
+		val, err := task.Encode()
+		if err != nil {
+			slog.Error("skipping dead letter that cannot be replayed", "id", dl.ID, "error", err)
+			return false, nil
+		}
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: target,
+			Values: val,
+		})
+	}
+	pipe.XDel(ctx, DeadLetterQueue, dl.ID)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, fmt.Errorf("failed to replay dead letter %s: %w", dl.ID, err)
+	}
+	return true, nil
+}
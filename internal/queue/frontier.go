@@ -0,0 +1,168 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/NesterovYehor/Crawler/internal/models"
+	"github.com/NesterovYehor/Crawler/internal/utils"
+	"github.com/redis/go-redis/v9"
+)
+
+// The frontier keeps discovered links in one list per host and a ready set
+// that orders hosts by the time they may be fetched again. Promotion moves at
+// most one task per ready host into the fetch stream, so a single large site
+// cannot starve the others and workers only receive fetchable URLs.
+const (
+	frontierReadyKey   = "frontier:ready"
+	frontierHostPrefix = "frontier:host:"
+	frontierSizeKey    = "frontier:size"
+	defaultHostDelay   = time.Second
+)
+
+// promoteHostScript moves the next task of one host into the stream when
+// the host is still due, and reschedules the host after the larger of the
+// queue's host delay and the host's politeness delay. KEYS are the ready
+// set, the host's list, the stream, the size counter and the host's
+// politeness hash, whose delay is in milliseconds and whose refill_time,
+// the time the host may be fetched again, in seconds.
+var promoteHostScript = redis.NewScript(`
+local now = tonumber(ARGV[2])
+local score = redis.call("ZSCORE", KEYS[1], ARGV[1])
+if not score or tonumber(score) > now then
+    return 0
+end
+local moved = 0
+local raw = redis.call("LPOP", KEYS[2])
+if raw then
+    local fields = cjson.decode(raw)
+    local args = {}
+    for k, v in pairs(fields) do
+        table.insert(args, k)
+        table.insert(args, tostring(v))
+    end
+    redis.call("XADD", KEYS[3], "*", unpack(args))
+    redis.call("DECR", KEYS[4])
+    moved = 1
+end
+if redis.call("LLEN", KEYS[2]) == 0 then
+    redis.call("ZREM", KEYS[1], ARGV[1])
+    return moved
+end
+local next = now + tonumber(ARGV[3])
+if redis.call("TYPE", KEYS[5]).ok == "hash" then
+    local rules = redis.call("HMGET", KEYS[5], "delay", "refill_time")
+    local delay = tonumber(rules[1])
+    if delay then
+        next = math.max(next, now + delay)
+    end
+    local refill = tonumber(rules[2])
+    if refill then
+        next = math.max(next, refill * 1000)
+    end
+end
+redis.call("ZADD", KEYS[1], next, ARGV[1])
+return moved
+`)
+
+func usesFrontier(source string) bool {
+	return source == MediumPriorityQueue
+}
+
+func (q *queue) addToFrontier(ctx context.Context, tasks []*models.Task) error {
+	pipe := q.client.TxPipeline()
+	pushed, err := pushFrontier(ctx, pipe, tasks)
+	if err != nil || pushed == 0 {
+		return err
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to add tasks to frontier: %w", err)
+	}
+	return nil
+}
+
+// pushFrontier queues the commands that add tasks to the frontier on pipe
+// and returns how many tasks they add. Tasks without a host are skipped.
+func pushFrontier(ctx context.Context, pipe redis.Pipeliner, tasks []*models.Task) (int64, error) {
+	now := time.Now().UnixMilli()
+	var pushed int64
+	for _, t := range tasks {
+		host, err := utils.GetDomain(t.URL)
+		if err != nil || host == "" {
+			continue
+		}
+		val, err := t.Encode()
+		if err != nil {
+			continue
+		}
+		raw, err := json.Marshal(val)
+		if err != nil {
+			return 0, fmt.Errorf("failed to encode frontier task: %w", err)
+		}
+		pipe.RPush(ctx, frontierHostPrefix+host, raw)
+		pipe.ZAddNX(ctx, frontierReadyKey, redis.Z{Score: float64(now), Member: host})
+		pushed++
+	}
+	if pushed > 0 {
+		pipe.IncrBy(ctx, frontierSizeKey, pushed)
+	}
+	return pushed, nil
+}
+
+// promote moves up to count tasks from hosts that are due into the source
+// stream, one per host, and reschedules each of those hosts after its
+// delay.
+func (q *queue) promote(ctx context.Context, source string, count int) (int, error) {
+	now := time.Now().UnixMilli()
+	hosts, err := q.client.ZRangeByScore(ctx, frontierReadyKey, &redis.ZRangeBy{
+		Min: "-inf", Max: strconv.FormatInt(now, 10), Count: int64(count),
+	}).Result()
+	if err != nil && err != redis.Nil {
+		return 0, fmt.Errorf("failed to read due frontier hosts: %w", err)
+	}
+	moved := 0
+	for _, host := range hosts {
+		n, err := promoteHostScript.Run(ctx, q.client,
+			[]string{frontierReadyKey, frontierHostPrefix + host, source, frontierSizeKey, host},
+			host, now, q.hostDelay.Milliseconds(),
+		).Int()
+		if err != nil && err != redis.Nil {
+			return moved, fmt.Errorf("failed to promote frontier tasks of %s: %w", host, err)
+		}
+		moved += n
+	}
+	return moved, nil
+}
+
+func (q *queue) frontierSize(ctx context.Context) (int64, error) {
+	n, err := q.client.ZCard(ctx, frontierReadyKey).Result()
+	if err != nil && err != redis.Nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// frontierLen returns the number of tasks waiting in the frontier.
+func (q *queue) frontierLen(ctx context.Context) (int64, error) {
+	n, err := q.client.Get(ctx, frontierSizeKey).Int64()
+	if err != nil && err != redis.Nil {
+		return 0, err
+	}
+	return max(n, 0), nil
+}
+
+func (q *queue) clearFrontier(ctx context.Context) error {
+	iter := q.client.Scan(ctx, 0, frontierHostPrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		if err := q.client.Del(ctx, iter.Val()).Err(); err != nil {
+			return err
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	return q.client.Del(ctx, frontierReadyKey, frontierSizeKey).Err()
+}
@@ -12,7 +12,14 @@ type Interface interface {
 	getMainTasks(ctx context.Context, count int, sourceName string) ([]*models.Task, error)
 	Del(messages []*models.Task) error
 	IsEmpty(source string, ctx context.Context) (bool, error)
+	Len(ctx context.Context, source string) (int64, error)
 	Retry(ctx context.Context, taks models.Task) error
 	Close(ctx context.Context) error
+	RunReclaimer(ctx context.Context)
+	DeadLetter(ctx context.Context, tasks []*models.Task) error
+	ListDeadLetters(ctx context.Context, filter DeadLetterFilter) ([]*models.DeadLetter, error)
+	PurgeDeadLetters(ctx context.Context, filter DeadLetterFilter) (int, error)
+	ReplayDeadLetters(ctx context.Context, filter DeadLetterFilter, target string) (int, error)
+	PurgeJob(ctx context.Context, jobID string) (int, error)
 	getRetryTasks(ctx context.Context, count int) ([]*models.Task, error)
 }
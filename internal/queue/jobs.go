@@ -0,0 +1,196 @@
+package queue
+
+import (
+	"container/heap"
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/NesterovYehor/Crawler/internal/models"
+	"github.com/redis/go-redis/v9"
+)
+
+const purgeBatchSize = 500
+
+// PurgeJob removes every queued task of a job from the streams, the
+// frontier and the retry sets, and returns how many tasks were removed.
+func (q *queue) PurgeJob(ctx context.Context, jobID string) (int, error) {
+	if jobID == "" {
+		return 0, fmt.Errorf("job ID is empty")
+	}
+	total := q.purgeReclaimed(jobID)
+	for _, stream := range q.streams {
+		n, err := q.purgeStream(ctx, stream, jobID)
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+	n, err := q.purgeFrontier(ctx, jobID)
+	if err != nil {
+		return total, err
+	}
+	total += n
+	n, err = q.purgeRetries(ctx, jobID)
+	return total + n, err
+}
+
+func (q *queue) purgeReclaimed(jobID string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var n int
+	for source, tasks := range q.reclaimed {
+		kept := slices.DeleteFunc(tasks, func(t *models.Task) bool {
+			return t.JobID == jobID
+		})
+		n += len(tasks) - len(kept)
+		q.reclaimed[source] = kept
+	}
+	return n
+}
+
+func (q *queue) purgeStream(ctx context.Context, stream, jobID string) (int, error) {
+	var ids []string
+	start := "-"
+	for {
+		msgs, err := q.client.XRangeN(ctx, stream, start, "+", purgeBatchSize).Result()
+		if err != nil && err != redis.Nil {
+			return 0, fmt.Errorf("failed to scan %s: %w", stream, err)
+		}
+		for _, msg := range msgs {
+			task := &models.Task{}
+			if task.Decode(msg.Values, stream) == nil && task.JobID == jobID {
+				ids = append(ids, msg.ID)
+			}
+		}
+		if len(msgs) < purgeBatchSize {
+			break
+		}
+		start = "(" + msgs[len(msgs)-1].ID
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	pipe := q.client.Pipeline()
+	pipe.XAck(ctx, stream, q.groupName, ids...)
+	pipe.XDel(ctx, stream, ids...)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("failed to purge job tasks from %s: %w", stream, err)
+	}
+	return len(ids), nil
+}
+
+func (q *queue) purgeFrontier(ctx context.Context, jobID string) (int, error) {
+	var total int64
+	iter := q.client.Scan(ctx, 0, frontierHostPrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		items, err := q.client.LRange(ctx, key, 0, -1).Result()
+		if err != nil {
+			return int(total), fmt.Errorf("failed to read %s: %w", key, err)
+		}
+		for _, raw := range items {
+			var fields map[string]any
+			if json.Unmarshal([]byte(raw), &fields) != nil {
+				continue
+			}
+			task := &models.Task{}
+			if task.Decode(fields, "") != nil || task.JobID != jobID {
+				continue
+			}
+			n, err := q.client.LRem(ctx, key, 1, raw).Result()
+			if err != nil {
+				return int(total), fmt.Errorf("failed to purge job tasks from %s: %w", key, err)
+			}
+			total += n
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return int(total), err
+	}
+	if total > 0 {
+		// Hosts left without tasks are dropped from the ready set by promote.
+		if err := q.client.DecrBy(ctx, frontierSizeKey, total).Err(); err != nil {
+			return int(total), err
+		}
+	}
+	return int(total), nil
+}
+
+func (q *queue) purgeRetries(ctx context.Context, jobID string) (int, error) {
+	var total int
+	for _, set := range retrySets(q.retryClasses) {
+		members, err := q.client.ZRange(ctx, set, 0, -1).Result()
+		if err != nil && err != redis.Nil {
+			return total, fmt.Errorf("failed to read %s: %w", set, err)
+		}
+		var remove []any
+		for _, raw := range members {
+			var m retryMember
+			if json.Unmarshal([]byte(raw), &m) != nil {
+				continue
+			}
+			task := &models.Task{}
+			if task.Decode(m.Task, "") != nil || task.JobID != jobID {
+				continue
+			}
+			remove = append(remove, raw)
+		}
+		if len(remove) == 0 {
+			continue
+		}
+		n, err := q.client.ZRem(ctx, set, remove...).Result()
+		if err != nil {
+			return total, fmt.Errorf("failed to purge job tasks from %s: %w", set, err)
+		}
+		total += int(n)
+	}
+	return total, nil
+}
+
+func (q *memoryQueue) PurgeJob(ctx context.Context, jobID string) (int, error) {
+	if jobID == "" {
+		return 0, fmt.Errorf("job ID is empty")
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	ofJob := func(t *models.Task) bool { return t.JobID == jobID }
+	var n int
+	for source, tasks := range q.streams {
+		before := len(tasks)
+		q.streams[source] = slices.DeleteFunc(tasks, ofJob)
+		n += before - len(q.streams[source])
+	}
+	for id, p := range q.pending {
+		if ofJob(p.task) {
+			delete(q.pending, id)
+			n++
+		}
+	}
+	for host, tasks := range q.frontier {
+		before := len(tasks)
+		tasks = slices.DeleteFunc(tasks, ofJob)
+		n += before - len(tasks)
+		if len(tasks) == 0 {
+			delete(q.frontier, host)
+		} else {
+			q.frontier[host] = tasks
+		}
+	}
+	q.ready = slices.DeleteFunc(q.ready, func(h *readyHost) bool {
+		_, ok := q.frontier[h.host]
+		return !ok
+	})
+	heap.Init(&q.ready)
+	for _, h := range q.retries {
+		before := h.Len()
+		*h = slices.DeleteFunc(*h, ofJob)
+		n += before - h.Len()
+		heap.Init(h)
+	}
+	return n, nil
+}
@@ -0,0 +1,86 @@
+package queue
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/NesterovYehor/Crawler/internal/config"
+)
+
+var builtinLanes = []string{
+	HighPriorityQueue,
+	MediumPriorityQueue,
+	StoreQueue,
+	RetryPriorityQueue,
+}
+
+var defaultWeights = map[string]int{
+	HighPriorityQueue:   8,
+	MediumPriorityQueue: 4,
+	StoreQueue:          2,
+	RetryPriorityQueue:  1,
+}
+
+// Lanes returns the declared lanes, or the built-in ones sized from the
+// legacy fetch and upload worker counts when none are declared. The
+// built-in lanes are always required because the crawler routes tasks to
+// them.
+func Lanes(declared []config.Lane, workers *config.Workers) ([]config.Lane, error) {
+	if len(declared) == 0 {
+		return defaultLanes(workers), nil
+	}
+
+	seen := make(map[string]bool, len(declared))
+	for _, l := range declared {
+		if l.Name == "" {
+			return nil, fmt.Errorf("lane without a name")
+		}
+		if seen[l.Name] {
+			return nil, fmt.Errorf("lane %q declared twice", l.Name)
+		}
+		if l.Workers < 0 || l.Weight < 0 || l.MaxLen < 0 || l.MinWorkers < 0 || l.MaxWorkers < 0 {
+			return nil, fmt.Errorf("lane %q has negative workers, weight or max_len", l.Name)
+		}
+		if l.MaxWorkers > 0 && l.MinWorkers > l.MaxWorkers {
+			return nil, fmt.Errorf("lane %q has min_workers above max_workers", l.Name)
+		}
+		seen[l.Name] = true
+	}
+	for _, name := range builtinLanes {
+		if !seen[name] {
+			return nil, fmt.Errorf("built-in lane %q is not declared", name)
+		}
+	}
+	return slices.Clone(declared), nil
+}
+
+func defaultLanes(workers *config.Workers) []config.Lane {
+	counts := map[string]int{}
+	if workers != nil {
+		counts[HighPriorityQueue] = workers.Fetch.HighPrioretyCount
+		counts[MediumPriorityQueue] = workers.Fetch.MedPrioretyCount
+		counts[StoreQueue] = workers.Upload.Count
+		counts[RetryPriorityQueue] = workers.Fetch.LowPrioretyCount
+	}
+	lanes := make([]config.Lane, len(builtinLanes))
+	for i, name := range builtinLanes {
+		lanes[i] = config.Lane{
+			Name:    name,
+			Workers: counts[name],
+			Weight:  defaultWeights[name],
+		}
+	}
+	return lanes
+}
+
+// streamLanes returns the names of the lanes backed by a Redis stream, which
+// is every lane except the retry lane.
+func streamLanes(lanes []config.Lane) []string {
+	var names []string
+	for _, l := range lanes {
+		if l.Name != RetryPriorityQueue {
+			names = append(names, l.Name)
+		}
+	}
+	return names
+}
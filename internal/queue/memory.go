@@ -0,0 +1,495 @@
+package queue
+
+import (
+	"cmp"
+	"container/heap"
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/NesterovYehor/Crawler/internal/config"
+	"github.com/NesterovYehor/Crawler/internal/models"
+	"github.com/NesterovYehor/Crawler/internal/utils"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	BackendRedis  = "redis"
+	BackendMemory = "memory"
+)
+
+// New returns the queue implementation selected by cfg.Backend. The Redis
+// client is only used by the Redis backend and may be nil otherwise.
+func New(ctx context.Context, client *redis.Client, cfg *config.Queue) (Interface, error) {
+	switch cfg.Backend {
+	case "", BackendRedis:
+		if client == nil {
+			return nil, fmt.Errorf("redis queue backend requires a redis client")
+		}
+		return NewQueue(ctx, client, cfg)
+	case BackendMemory:
+		if _, err := Lanes(cfg.Lanes, nil); err != nil {
+			return nil, err
+		}
+		return NewMemoryQueue(cfg), nil
+	default:
+		return nil, fmt.Errorf("unknown queue backend %q", cfg.Backend)
+	}
+}
+
+type pendingTask struct {
+	task        *models.Task
+	deliveredAt time.Time
+}
+
+// memoryQueue is an in-process implementation of Interface for single
+// process crawls and tests. It mirrors the Redis queue: streams with
+// acknowledgements and reclaiming, a host-partitioned frontier for the
+// medium queue, a delayed retry set and a dead-letter list. Its frontier
+// spaces a host's fetches by hostDelay only: robots.txt crawl delays are
+// kept with the politeness rules in Redis, which it does not read.
+type memoryQueue struct {
+	mu                sync.Mutex
+	visibilityTimeout time.Duration
+	reclaimInterval   time.Duration
+	hostDelay         time.Duration
+	retryClasses      []string
+
+	lastID   int64
+	seq      int64
+	streams  map[string][]*models.Task
+	pending  map[string]*pendingTask
+	retries  map[string]*retryHeap
+	frontier map[string][]*models.Task
+	ready    hostHeap
+	dead     []*models.DeadLetter
+}
+
+func NewMemoryQueue(cfg *config.Queue) Interface {
+	q := &memoryQueue{
+		visibilityTimeout: cfg.VisibilityTimeout,
+		reclaimInterval:   cfg.ReclaimInterval,
+		hostDelay:         cfg.HostDelay,
+		retryClasses:      cfg.RetrySets,
+	}
+	if q.visibilityTimeout <= 0 {
+		q.visibilityTimeout = defaultVisibilityTimeout
+	}
+	if q.reclaimInterval <= 0 {
+		q.reclaimInterval = defaultReclaimInterval
+	}
+	if q.hostDelay <= 0 {
+		q.hostDelay = defaultHostDelay
+	}
+	q.reset()
+	return q
+}
+
+func (q *memoryQueue) reset() {
+	q.streams = make(map[string][]*models.Task)
+	q.pending = make(map[string]*pendingTask)
+	q.retries = make(map[string]*retryHeap)
+	for _, set := range retrySets(q.retryClasses) {
+		q.retries[set] = &retryHeap{}
+	}
+	q.frontier = make(map[string][]*models.Task)
+	q.ready = nil
+	q.dead = nil
+}
+
+func (q *memoryQueue) nextID() string {
+	now := time.Now().UnixMilli()
+	if now == q.lastID {
+		q.seq++
+	} else {
+		q.lastID, q.seq = now, 0
+	}
+	return fmt.Sprintf("%d-%d", q.lastID, q.seq)
+}
+
+func (q *memoryQueue) Add(messages []*models.Task) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, m := range messages {
+		if m == nil {
+			continue
+		}
+		if m.Exhausted() {
+			q.deadLetter(m)
+			continue
+		}
+		if !m.IsValid() {
+			continue
+		}
+		t := cloneTask(m)
+		t.ID = q.nextID()
+		if usesFrontier(t.SourceName) {
+			q.addToFrontier(t)
+			continue
+		}
+		q.streams[t.SourceName] = append(q.streams[t.SourceName], t)
+	}
+	return nil
+}
+
+func (q *memoryQueue) addToFrontier(t *models.Task) {
+	host, err := utils.GetDomain(t.URL)
+	if err != nil || host == "" {
+		return
+	}
+	if _, ok := q.frontier[host]; !ok {
+		heap.Push(&q.ready, &readyHost{host: host, next: time.Now()})
+	}
+	q.frontier[host] = append(q.frontier[host], t)
+}
+
+func (q *memoryQueue) promote(source string, count int) {
+	now := time.Now()
+	var reschedule []*readyHost
+	for moved := 0; moved < count && q.ready.Len() > 0 && !q.ready[0].next.After(now); moved++ {
+		h := heap.Pop(&q.ready).(*readyHost)
+		tasks := q.frontier[h.host]
+		q.streams[source] = append(q.streams[source], tasks[0])
+		if len(tasks) == 1 {
+			delete(q.frontier, h.host)
+			continue
+		}
+		q.frontier[h.host] = tasks[1:]
+		h.next = now.Add(q.hostDelay)
+		reschedule = append(reschedule, h)
+	}
+	for _, h := range reschedule {
+		heap.Push(&q.ready, h)
+	}
+}
+
+func (q *memoryQueue) GetTasks(ctx context.Context, count int, source string) ([]*models.Task, error) {
+	if count <= 0 {
+		return nil, nil
+	}
+	if source == RetryPriorityQueue {
+		return q.getRetryTasks(ctx, count)
+	}
+	return q.getMainTasks(ctx, count, source)
+}
+
+func (q *memoryQueue) getMainTasks(ctx context.Context, count int, source string) ([]*models.Task, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if usesFrontier(source) {
+		q.promote(source, count)
+	}
+	stream := q.streams[source]
+	if len(stream) == 0 {
+		return nil, utils.ErrNoTasks
+	}
+	n := min(count, len(stream))
+	q.streams[source] = stream[n:]
+
+	tasks := make([]*models.Task, n)
+	for i, t := range stream[:n] {
+		t.Deliveries++
+		q.pending[t.ID] = &pendingTask{task: t, deliveredAt: time.Now()}
+		tasks[i] = cloneTask(t)
+	}
+	return tasks, nil
+}
+
+func (q *memoryQueue) getRetryTasks(ctx context.Context, count int) ([]*models.Task, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	moved := q.promoteRetries(count)
+
+	var tasks []*models.Task
+	for stream, n := range moved {
+		if usesFrontier(stream) || len(tasks) >= count {
+			continue
+		}
+		got, _ := q.getMainTasks(ctx, min(n, count-len(tasks)), stream)
+		tasks = append(tasks, got...)
+	}
+	if len(tasks) == 0 {
+		return nil, utils.ErrNoTasks
+	}
+	return tasks, nil
+}
+
+// promoteRetries moves up to count due tasks out of the delay sets and
+// returns the number moved into each lane.
+func (q *memoryQueue) promoteRetries(count int) map[string]int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now().Unix()
+	moved := make(map[string]int)
+	remaining := count
+	for _, set := range retrySets(q.retryClasses) {
+		h := q.retries[set]
+		for remaining > 0 && h.Len() > 0 && (*h)[0].NextAttemptAt <= now {
+			t := heap.Pop(h).(*models.Task)
+			t.SourceName = retryStream(t)
+			t.ID = q.nextID()
+			t.Deliveries = 0
+			if usesFrontier(t.SourceName) {
+				q.addToFrontier(t)
+			} else {
+				q.streams[t.SourceName] = append(q.streams[t.SourceName], t)
+			}
+			moved[t.SourceName]++
+			remaining--
+		}
+	}
+	return moved
+}
+
+func (q *memoryQueue) Del(messages []*models.Task) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, m := range messages {
+		if m == nil || m.ID == "" {
+			continue
+		}
+		delete(q.pending, m.ID)
+	}
+	return nil
+}
+
+func (q *memoryQueue) IsEmpty(source string, ctx context.Context) (bool, error) {
+	if source == RetryPriorityQueue {
+		return true, nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if usesFrontier(source) && len(q.frontier) != 0 {
+		return false, nil
+	}
+	if len(q.streams[source]) != 0 {
+		return false, nil
+	}
+	for _, p := range q.pending {
+		if p.task.SourceName == source {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (q *memoryQueue) Len(ctx context.Context, source string) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if source == RetryPriorityQueue {
+		var n int
+		for _, h := range q.retries {
+			n += h.Len()
+		}
+		return int64(n), nil
+	}
+	n := len(q.streams[source])
+	for _, p := range q.pending {
+		if p.task.SourceName == source {
+			n++
+		}
+	}
+	if usesFrontier(source) {
+		for _, tasks := range q.frontier {
+			n += len(tasks)
+		}
+	}
+	return int64(n), nil
+}
+
+func (q *memoryQueue) Retry(ctx context.Context, taks models.Task) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	taks.Retries++
+	if taks.Exhausted() {
+		q.deadLetter(&taks)
+		return nil
+	}
+	taks.CountNextAttemptAt()
+	heap.Push(q.retries[retrySetFor(q.retryClasses, &taks)], cloneTask(&taks))
+	return nil
+}
+
+func (q *memoryQueue) Close(ctx context.Context) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.reset()
+	return nil
+}
+
+func (q *memoryQueue) RunReclaimer(ctx context.Context) {
+	ticker := time.NewTicker(q.reclaimInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			q.reclaim()
+		}
+	}
+}
+
+func (q *memoryQueue) reclaim() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var stale []*models.Task
+	for id, p := range q.pending {
+		if time.Since(p.deliveredAt) >= q.visibilityTimeout {
+			stale = append(stale, p.task)
+			delete(q.pending, id)
+		}
+	}
+	slices.SortFunc(stale, func(a, b *models.Task) int {
+		return compareIDs(a.ID, b.ID)
+	})
+	bySource := make(map[string][]*models.Task)
+	for _, t := range stale {
+		bySource[t.SourceName] = append(bySource[t.SourceName], t)
+	}
+	for source, tasks := range bySource {
+		q.streams[source] = append(tasks, q.streams[source]...)
+	}
+}
+
+func (q *memoryQueue) deadLetter(t *models.Task) {
+	host, _ := utils.GetDomain(t.URL)
+	dl := models.NewDeadLetter(cloneTask(t), host)
+	dl.ID = q.nextID()
+	q.dead = append(q.dead, dl)
+}
+
+func (q *memoryQueue) DeadLetter(ctx context.Context, tasks []*models.Task) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, t := range tasks {
+		if t != nil {
+			q.deadLetter(t)
+		}
+	}
+	return nil
+}
+
+func (q *memoryQueue) ListDeadLetters(ctx context.Context, filter DeadLetterFilter) ([]*models.DeadLetter, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.matchDeadLetters(filter), nil
+}
+
+func (q *memoryQueue) matchDeadLetters(filter DeadLetterFilter) []*models.DeadLetter {
+	var res []*models.DeadLetter
+	for _, dl := range q.dead {
+		if !filter.match(dl) {
+			continue
+		}
+		res = append(res, dl)
+		if filter.Limit > 0 && len(res) >= filter.Limit {
+			break
+		}
+	}
+	return res
+}
+
+func (q *memoryQueue) removeDeadLetters(letters []*models.DeadLetter) {
+	q.dead = slices.DeleteFunc(q.dead, func(dl *models.DeadLetter) bool {
+		return slices.Contains(letters, dl)
+	})
+}
+
+func (q *memoryQueue) PurgeDeadLetters(ctx context.Context, filter DeadLetterFilter) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	letters := q.matchDeadLetters(filter)
+	q.removeDeadLetters(letters)
+	return len(letters), nil
+}
+
+func (q *memoryQueue) ReplayDeadLetters(ctx context.Context, filter DeadLetterFilter, target string) (int, error) {
+	if target != HighPriorityQueue && target != MediumPriorityQueue {
+		return 0, fmt.Errorf("cannot replay dead letters into %q", target)
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	letters := q.matchDeadLetters(filter)
+	for _, dl := range letters {
+		t := cloneTask(dl.Task)
+		t.ID = q.nextID()
+		t.Retries = 0
+		t.MaxAttempts = 0
+		t.NextAttemptAt = 0
+		t.Deliveries = 0
+		t.SourceName = target
+		if usesFrontier(target) {
+			q.addToFrontier(t)
+			continue
+		}
+		q.streams[target] = append(q.streams[target], t)
+	}
+	q.removeDeadLetters(letters)
+	return len(letters), nil
+}
+
+func cloneTask(t *models.Task) *models.Task {
+	c := *t
+	c.Attempts = slices.Clone(t.Attempts)
+	return &c
+}
+
+func compareIDs(a, b string) int {
+	var am, as, bm, bs int64
+	fmt.Sscanf(a, "%d-%d", &am, &as)
+	fmt.Sscanf(b, "%d-%d", &bm, &bs)
+	if c := cmp.Compare(am, bm); c != 0 {
+		return c
+	}
+	return cmp.Compare(as, bs)
+}
+
+type retryHeap []*models.Task
+
+func (h retryHeap) Len() int           { return len(h) }
+func (h retryHeap) Less(i, j int) bool { return h[i].NextAttemptAt < h[j].NextAttemptAt }
+func (h retryHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *retryHeap) Push(x any)        { *h = append(*h, x.(*models.Task)) }
+func (h *retryHeap) Pop() any {
+	old := *h
+	n := len(old)
+	t := old[n-1]
+	*h = old[:n-1]
+	return t
+}
+
+type readyHost struct {
+	host string
+	next time.Time
+}
+
+type hostHeap []*readyHost
+
+func (h hostHeap) Len() int           { return len(h) }
+func (h hostHeap) Less(i, j int) bool { return h[i].next.Before(h[j].next) }
+func (h hostHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *hostHeap) Push(x any)        { *h = append(*h, x.(*readyHost)) }
+func (h *hostHeap) Pop() any {
+	old := *h
+	n := len(old)
+	r := old[n-1]
+	*h = old[:n-1]
+	return r
+}
@@ -2,10 +2,11 @@ package queue
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/NesterovYehor/Crawler/internal/config"
@@ -14,17 +15,33 @@ import (
 	"github.com/redis/go-redis/v9"
 )
 
+const (
+	defaultVisibilityTimeout = 5 * time.Minute
+	defaultReclaimInterval   = 30 * time.Second
+	reclaimBatchSize         = 100
+)
+
 type queue struct {
-	client     *redis.Client
-	consumerID string
-	groupName  string
+	client            *redis.Client
+	consumerID        string
+	groupName         string
+	visibilityTimeout time.Duration
+	reclaimInterval   time.Duration
+	hostDelay         time.Duration
+	retryClasses      []string
+	streams           []string
+
+	mu        sync.Mutex
+	reclaimed map[string][]*models.Task
 }
 
 func NewQueue(ctx context.Context, client *redis.Client, cfg *config.Queue) (Interface, error) {
-	for _, source := range FallbackOrder {
-		if source == RetryPriorityQueue {
-			continue
-		}
+	lanes, err := Lanes(cfg.Lanes, nil)
+	if err != nil {
+		return nil, err
+	}
+	streams := streamLanes(lanes)
+	for _, source := range streams {
 		err := client.XGroupCreateMkStream(ctx, source, "workers", "0").Err()
 		if err != nil {
 			if strings.Contains(err.Error(), "BUSYGROUP") {
@@ -34,30 +51,56 @@ func NewQueue(ctx context.Context, client *redis.Client, cfg *config.Queue) (Int
 		}
 	}
 
+	visibilityTimeout := cfg.VisibilityTimeout
+	if visibilityTimeout <= 0 {
+		visibilityTimeout = defaultVisibilityTimeout
+	}
+	reclaimInterval := cfg.ReclaimInterval
+	if reclaimInterval <= 0 {
+		reclaimInterval = defaultReclaimInterval
+	}
+	hostDelay := cfg.HostDelay
+	if hostDelay <= 0 {
+		hostDelay = defaultHostDelay
+	}
+
 	return &queue{
-		client:     client,
-		consumerID: cfg.ConsumerID,
-		groupName:  "workers",
+		client:            client,
+		consumerID:        cfg.ConsumerID,
+		groupName:         "workers",
+		visibilityTimeout: visibilityTimeout,
+		reclaimInterval:   reclaimInterval,
+		hostDelay:         hostDelay,
+		retryClasses:      cfg.RetrySets,
+		streams:           streams,
+		reclaimed:         make(map[string][]*models.Task),
 	}, nil
 }
 
+// Add queues messages. Every task that can be added is, and the tasks that
+// could not be are reported, so a caller that acknowledges deliveries only
+// once they are requeued never loses one.
 func (q *queue) Add(messages []*models.Task) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	pipe := q.client.Pipeline()
-	defer func() {
-		_, err := pipe.Exec(ctx)
-		if err != nil {
-			return
-		}
-	}()
+	var exhausted, frontier []*models.Task
+	var errs []error
 	for _, m := range messages {
 		if m == nil {
 			continue
 		}
+		if m.Exhausted() {
+			exhausted = append(exhausted, m)
+			continue
+		}
+		if usesFrontier(m.SourceName) {
+			frontier = append(frontier, m)
+			continue
+		}
 		val, err := m.Encode()
 		if err != nil {
+			errs = append(errs, err)
 			continue
 		}
 
@@ -65,10 +108,18 @@ func (q *queue) Add(messages []*models.Task) error {
 			Stream: string(m.SourceName),
 			Values: val,
 		}).Err(); err != nil {
-			continue
+			errs = append(errs, fmt.Errorf("failed to add task to %s: %w", m.SourceName, err))
 		}
 	}
-	return nil
+	if err := q.addToFrontier(ctx, frontier); err != nil {
+		errs = append(errs, err)
+	}
+	if len(exhausted) > 0 {
+		if err := q.DeadLetter(ctx, exhausted); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
 }
 
 func (q *queue) GetTasks(ctx context.Context, count int, source string) ([]*models.Task, error) {
@@ -83,13 +134,21 @@ func (q *queue) GetTasks(ctx context.Context, count int, source string) ([]*mode
 }
 
 func (q *queue) getMainTasks(ctx context.Context, count int, source string) ([]*models.Task, error) {
+	if tasks := q.popReclaimed(source, count); len(tasks) > 0 {
+		return tasks, nil
+	}
+	if usesFrontier(source) {
+		if _, err := q.promote(ctx, source, count); err != nil {
+			slog.Error(err.Error())
+		}
+	}
+
 	res, err := q.client.XReadGroup(ctx, &redis.XReadGroupArgs{
 		Group:    q.groupName,
 		Consumer: q.consumerID,
 		Streams:  []string{source, ">"},
 		Block:    time.Millisecond * 100,
 		Count:    int64(count),
-		NoAck:    true,
 	}).Result()
 
 	if err == redis.Nil {
@@ -106,6 +165,7 @@ func (q *queue) getMainTasks(ctx context.Context, count int, source string) ([]*
 				return nil, err
 			}
 			m.ID = msg.ID
+			m.Deliveries = 1
 			messages[i] = m
 		}
 		return messages, nil
@@ -113,101 +173,194 @@ func (q *queue) getMainTasks(ctx context.Context, count int, source string) ([]*
 	return nil, nil
 }
 
+// getRetryTasks promotes due retry tasks back into the lanes they came
+// from and hands out what it promoted into stream lanes, so the retry
+// lane's workers run the retries. Tasks promoted into the frontier wait for
+// their host there.
 func (q *queue) getRetryTasks(ctx context.Context, count int) ([]*models.Task, error) {
-	members, err := q.fetchRetryMembers(ctx, count)
+	moved, err := q.promoteRetries(ctx, count)
 	if err != nil {
 		return nil, err
 	}
-	if len(members) == 0 {
+	var tasks []*models.Task
+	for stream, n := range moved {
+		if usesFrontier(stream) || len(tasks) >= count {
+			continue
+		}
+		got, err := q.getMainTasks(ctx, min(n, count-len(tasks)), stream)
+		if err != nil && !errors.Is(err, utils.ErrNoTasks) {
+			return tasks, err
+		}
+		for _, t := range got {
+			if t != nil {
+				tasks = append(tasks, t)
+			}
+		}
+	}
+	if len(tasks) == 0 {
 		return nil, utils.ErrNoTasks
 	}
+	return tasks, nil
+}
 
-	tasks := make([]*models.Task, len(members))
-	for i, memberJSON := range members {
-		task := &models.Task{}
-		if err := json.Unmarshal([]byte(memberJSON), task); err != nil {
-			slog.Error(err.Error())
+// Del acknowledges processed tasks and removes them from their streams.
+func (q *queue) Del(messages []*models.Task) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	pipe := q.client.Pipeline()
+	for _, m := range messages {
+		if m == nil || m.ID == "" {
 			continue
 		}
-		tasks[i] = task
+		pipe.XAck(ctx, m.SourceName, q.groupName, m.ID)
+		pipe.XDel(ctx, m.SourceName, m.ID)
 	}
-
-	return tasks, nil
+	if pipe.Len() == 0 {
+		return nil
+	}
+	_, err := pipe.Exec(ctx)
+	return err
 }
 
-func (q *queue) fetchRetryMembers(ctx context.Context, count int) ([]string, error) {
-	currentUnixTime := time.Now().Unix()
+// RunReclaimer periodically claims tasks that stayed unacknowledged longer
+// than the visibility timeout, so tasks held by a dead consumer are handed
+// out again by GetTasks. It blocks until ctx is cancelled.
+func (q *queue) RunReclaimer(ctx context.Context) {
+	ticker := time.NewTicker(q.reclaimInterval)
+	defer ticker.Stop()
 
-	zmembers, err := q.client.ZPopMin(ctx, RetryPriorityQueue, int64(count)).Result()
-	if err != nil && err != redis.Nil {
-		return nil, fmt.Errorf("failed to atomically pop retry tasks from queue (ZPopMin): %w", err)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, source := range q.streams {
+				if err := q.reclaim(ctx, source); err != nil && !errors.Is(err, context.Canceled) {
+					slog.Error("failed to reclaim stale tasks", "source", source, "error", err)
+				}
+			}
+		}
 	}
+}
 
-	if len(zmembers) == 0 {
-		return nil, utils.ErrNoTasks
+func (q *queue) reclaim(ctx context.Context, source string) error {
+	start := "0-0"
+	for {
+		msgs, next, err := q.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   source,
+			Group:    q.groupName,
+			Consumer: q.consumerID,
+			MinIdle:  q.visibilityTimeout,
+			Start:    start,
+			Count:    reclaimBatchSize,
+		}).Result()
+		if err != nil {
+			return fmt.Errorf("failed to auto claim tasks from %s: %w", source, err)
+		}
+
+		if len(msgs) > 0 {
+			tasks, err := q.decodeClaimed(ctx, source, msgs)
+			if err != nil {
+				return err
+			}
+			q.pushReclaimed(source, tasks)
+		}
+
+		if next == "" || next == "0-0" {
+			return nil
+		}
+		start = next
 	}
-	var dueTasksJSON []string
+}
 
-	readdPipe := q.client.Pipeline()
-	readdedCount := 0
+func (q *queue) decodeClaimed(ctx context.Context, source string, msgs []redis.XMessage) ([]*models.Task, error) {
+	pipe := q.client.Pipeline()
+	pending := make([]*redis.XPendingExtCmd, len(msgs))
+	for i, msg := range msgs {
+		pending[i] = pipe.XPendingExt(ctx, &redis.XPendingExtArgs{
+			Stream: source,
+			Group:  q.groupName,
+			Start:  msg.ID,
+			End:    msg.ID,
+			Count:  1,
+		})
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to read delivery counters for %s: %w", source, err)
+	}
 
-	for _, zmember := range zmembers {
-		memberJSON, ok := zmember.Member.(string)
-		if !ok {
+	var (
+		tasks   []*models.Task
+		invalid []string
+	)
+	for i, msg := range msgs {
+		task := &models.Task{}
+		if len(msg.Values) == 0 || task.Decode(msg.Values, source) != nil {
+			invalid = append(invalid, msg.ID)
 			continue
 		}
-		if int64(zmember.Score) <= currentUnixTime {
-			dueTasksJSON = append(dueTasksJSON, memberJSON)
-		} else {
-			readdPipe.ZAdd(ctx, RetryPriorityQueue, redis.Z{
-				Score:  zmember.Score,
-				Member: zmember.Member,
-			})
-			readdedCount++
+		task.ID = msg.ID
+		task.Deliveries = 1
+		if res := pending[i].Val(); len(res) == 1 {
+			task.Deliveries = int(res[0].RetryCount)
 		}
+		tasks = append(tasks, task)
 	}
 
-	if readdedCount > 0 {
-		_, err := readdPipe.Exec(ctx)
-		if err != nil {
-			return nil, fmt.Errorf("failed to re-add %d non-due tasks to retry queue: %w", readdedCount, err)
+	if len(invalid) > 0 {
+		if err := q.client.XAck(ctx, source, q.groupName, invalid...).Err(); err != nil {
+			slog.Error("failed to ack invalid reclaimed tasks", "source", source, "error", err)
 		}
 	}
-
-	return dueTasksJSON, nil
+	return tasks, nil
 }
 
-func (q *queue) Del(messages []*models.Task) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
-	defer cancel()
-	pipe := q.client.Pipeline()
-	defer func() {
-		_, err := pipe.Exec(ctx)
-		if err != nil {
-			slog.Error(err.Error())
-			return
+func (q *queue) pushReclaimed(source string, tasks []*models.Task) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	queued := make(map[string]struct{}, len(q.reclaimed[source]))
+	for _, t := range q.reclaimed[source] {
+		queued[t.ID] = struct{}{}
+	}
+	for _, t := range tasks {
+		if _, ok := queued[t.ID]; ok {
+			continue
 		}
-	}()
-	for _, m := range messages {
-		pipe.XDel(ctx, string(m.SourceName), m.ID)
+		q.reclaimed[source] = append(q.reclaimed[source], t)
 	}
-	_, err := pipe.Exec(ctx)
-	return err
+}
+
+func (q *queue) popReclaimed(source string, count int) []*models.Task {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	buf := q.reclaimed[source]
+	if len(buf) == 0 {
+		return nil
+	}
+	n := min(count, len(buf))
+	tasks := buf[:n:n]
+	q.reclaimed[source] = buf[n:]
+	return tasks
 }
 
 func (q *queue) Retry(ctx context.Context, taks models.Task) error {
-	taks.SourceName = RetryPriorityQueue
 	taks.Retries++
+	if taks.Exhausted() {
+		return q.DeadLetter(ctx, []*models.Task{&taks})
+	}
 	taks.CountNextAttemptAt()
-	data, err := taks.EncodeToStr()
+	member, err := encodeRetryMember(&taks)
 	if err != nil {
 		return err
 	}
 	z := redis.Z{
 		Score:  float64(taks.NextAttemptAt),
-		Member: data,
+		Member: member,
 	}
-	err = q.client.ZAdd(ctx, RetryPriorityQueue, z).Err()
+	err = q.client.ZAdd(ctx, retrySetFor(q.retryClasses, &taks), z).Err()
 	if err != nil {
 		return fmt.Errorf("failed to store retry task: %v", err)
 	}
@@ -215,8 +368,13 @@ func (q *queue) Retry(ctx context.Context, taks models.Task) error {
 }
 
 func (q *queue) Close(ctx context.Context) error {
-	for _, source := range FallbackOrder {
-
+	if err := q.clearFrontier(ctx); err != nil {
+		return fmt.Errorf("failed to clear frontier: %w", err)
+	}
+	if err := q.client.Del(ctx, retrySets(q.retryClasses)...).Err(); err != nil {
+		return fmt.Errorf("failed to delete retry sets: %w", err)
+	}
+	for _, source := range q.streams {
 		if err := q.client.XGroupDestroy(ctx, source, q.groupName).Err(); err != nil {
 			return fmt.Errorf("failed to destroy consumer group: %w", err)
 		}
@@ -229,11 +387,50 @@ func (q *queue) Close(ctx context.Context) error {
 	return nil
 }
 
+// Len returns the number of tasks held for source: entries in its stream,
+// including delivered but unacknowledged ones, plus tasks still waiting in
+// the frontier or in the retry sets.
+func (q *queue) Len(ctx context.Context, source string) (int64, error) {
+	if source == RetryPriorityQueue {
+		var total int64
+		for _, set := range retrySets(q.retryClasses) {
+			n, err := q.client.ZCard(ctx, set).Result()
+			if err != nil && err != redis.Nil {
+				return 0, fmt.Errorf("failed to count retry set %s: %w", set, err)
+			}
+			total += n
+		}
+		return total, nil
+	}
+	n, err := q.client.XLen(ctx, source).Result()
+	if err != nil && err != redis.Nil {
+		return 0, fmt.Errorf("failed to count stream %s: %w", source, err)
+	}
+	if usesFrontier(source) {
+		waiting, err := q.frontierLen(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("failed to count frontier: %w", err)
+		}
+		n += waiting
+	}
+	return n, nil
+}
+
 // Needs only for tests
 func (q *queue) IsEmpty(source string, ctx context.Context) (bool, error) {
 	if source == RetryPriorityQueue {
 		return true, nil
 	}
+	if usesFrontier(source) {
+		pending, err := q.frontierSize(ctx)
+		if err != nil {
+			return false, err
+		}
+		if pending != 0 {
+			slog.Info(fmt.Sprintf("[Is Queue Empty]Hosts waiting in frontier:%v", pending))
+			return false, nil
+		}
+	}
 	n, err := q.client.XLen(ctx, source).Result()
 	if err != nil && err != redis.Nil {
 		return false, err
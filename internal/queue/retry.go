@@ -0,0 +1,150 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/NesterovYehor/Crawler/internal/models"
+	"github.com/NesterovYehor/Crawler/internal/utils"
+	"github.com/redis/go-redis/v9"
+)
+
+// Failed tasks wait in delay sets (sorted sets scored by the next attempt
+// time) and are promoted back into the lane they came from once due. Each
+// task is promoted by a script that only re-adds it when it removed it from
+// its delay set, so a task is never lost or promoted twice. Tasks of the
+// medium lane go back through the host frontier, like new links do.
+var promoteRetryScript = redis.NewScript(`
+if redis.call("ZREM", KEYS[1], ARGV[1]) == 0 then
+    return 0
+end
+redis.call("XADD", KEYS[2], "*", unpack(ARGV, 2))
+return 1
+`)
+
+var promoteRetryToFrontierScript = redis.NewScript(`
+if redis.call("ZREM", KEYS[1], ARGV[1]) == 0 then
+    return 0
+end
+redis.call("RPUSH", KEYS[2], ARGV[2])
+redis.call("ZADD", KEYS[3], "NX", ARGV[3], ARGV[4])
+redis.call("INCR", KEYS[4])
+return 1
+`)
+
+type retryMember struct {
+	Stream string         `json:"stream"`
+	Task   map[string]any `json:"task"`
+}
+
+// retrySetFor returns the delay set a failed task waits in. Error classes
+// listed in the queue config get a set of their own, everything else shares
+// RetryPriorityQueue.
+func retrySetFor(classes []string, task *models.Task) string {
+	if last, ok := task.LastAttempt(); ok && slices.Contains(classes, last.ErrorClass) {
+		return RetryPriorityQueue + ":" + last.ErrorClass
+	}
+	return RetryPriorityQueue
+}
+
+func retrySets(classes []string) []string {
+	sets := []string{RetryPriorityQueue}
+	for _, class := range classes {
+		sets = append(sets, RetryPriorityQueue+":"+class)
+	}
+	return sets
+}
+
+func retryStream(task *models.Task) string {
+	if task.SourceName == "" || task.SourceName == RetryPriorityQueue {
+		return MediumPriorityQueue
+	}
+	return task.SourceName
+}
+
+// promoteRetries moves up to count due tasks out of the delay sets and
+// returns the number moved into each lane.
+func (q *queue) promoteRetries(ctx context.Context, count int) (map[string]int, error) {
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	moved := make(map[string]int)
+	remaining := count
+	for _, set := range retrySets(q.retryClasses) {
+		if remaining <= 0 {
+			break
+		}
+		members, err := q.client.ZRangeByScore(ctx, set, &redis.ZRangeBy{
+			Min: "-inf", Max: now, Count: int64(remaining),
+		}).Result()
+		if err != nil && err != redis.Nil {
+			return moved, fmt.Errorf("failed to read retry set %s: %w", set, err)
+		}
+		for _, member := range members {
+			stream, ok, err := q.promoteRetry(ctx, set, member)
+			if err != nil {
+				return moved, err
+			}
+			if ok {
+				moved[stream]++
+				remaining--
+			}
+		}
+	}
+	return moved, nil
+}
+
+func (q *queue) promoteRetry(ctx context.Context, set, member string) (string, bool, error) {
+	var item retryMember
+	task := &models.Task{}
+	if err := json.Unmarshal([]byte(member), &item); err != nil || task.Decode(item.Task, item.Stream) != nil {
+		slog.Error("dropping invalid retry task", "set", set)
+		return "", false, q.client.ZRem(ctx, set, member).Err()
+	}
+	val, err := task.Encode()
+	if err != nil {
+		slog.Error("dropping invalid retry task", "set", set, "error", err)
+		return "", false, q.client.ZRem(ctx, set, member).Err()
+	}
+
+	var res *redis.Cmd
+	if usesFrontier(item.Stream) {
+		host, err := utils.GetDomain(task.URL)
+		if err != nil || host == "" {
+			return "", false, q.client.ZRem(ctx, set, member).Err()
+		}
+		raw, err := json.Marshal(val)
+		if err != nil {
+			return "", false, fmt.Errorf("failed to encode frontier task: %w", err)
+		}
+		res = promoteRetryToFrontierScript.Run(ctx, q.client,
+			[]string{set, frontierHostPrefix + host, frontierReadyKey, frontierSizeKey},
+			member, raw, time.Now().UnixMilli(), host)
+	} else {
+		args := []any{member}
+		for k, v := range val {
+			args = append(args, k, v)
+		}
+		res = promoteRetryScript.Run(ctx, q.client, []string{set, item.Stream}, args...)
+	}
+	n, err := res.Int()
+	if err != nil {
+		return "", false, fmt.Errorf("failed to promote retry task: %w", err)
+	}
+	return item.Stream, n == 1, nil
+}
+
+func encodeRetryMember(task *models.Task) (string, error) {
+	val, err := task.Encode()
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(retryMember{Stream: retryStream(task), Task: val})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode retry task: %w", err)
+	}
+	return string(data), nil
+}
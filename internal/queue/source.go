@@ -3,6 +3,8 @@ package queue
 import (
 	"sync"
 	"time"
+
+	"github.com/NesterovYehor/Crawler/internal/config"
 )
 
 const fixedQueueBackoff = 1 * time.Second
@@ -14,48 +16,52 @@ var (
 	StoreQueue          = "queue:store"
 )
 
-var FallbackOrder = []string{
-	HighPriorityQueue,
-	MediumPriorityQueue,
-	StoreQueue,
-	RetryPriorityQueue,
-}
-
 type QueueState struct {
 	Name                string
+	Weight              int
 	NextAttemptDue      time.Time
 	ConsecutiveFailures int
+
+	currentWeight int
 }
 
+// Source picks the lane a worker pulls from. A worker always prefers its own
+// lane; while that lane is backing off, the other available lanes are chosen
+// by smooth weighted round-robin, so every lane with a positive weight gets
+// a share of the idle workers.
 type Source struct {
 	mu   *sync.Mutex
 	Curr string
 	init string
 
+	order       []*QueueState
 	queueStates map[string]*QueueState
 }
 
-func NewSource(initialQueueName string) *Source {
+func NewSource(initialQueueName string, lanes []config.Lane) *Source {
 	s := &Source{
 		mu:          &sync.Mutex{},
 		Curr:        initialQueueName,
 		init:        initialQueueName,
 		queueStates: make(map[string]*QueueState),
 	}
-	for _, qName := range FallbackOrder {
-		s.queueStates[qName] = &QueueState{
-			Name:           qName,
+	for _, lane := range lanes {
+		state := &QueueState{
+			Name:           lane.Name,
+			Weight:         lane.Weight,
 			NextAttemptDue: time.Now(),
 		}
+		s.order = append(s.order, state)
+		s.queueStates[lane.Name] = state
 	}
 	return s
 }
 
-func (s *Source) MarkQueueFailed() {
+func (s *Source) MarkQueueFailed(name string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	state := s.queueStates[s.Curr]
+	state := s.queueStates[name]
 	if state == nil {
 		return
 	}
@@ -64,41 +70,46 @@ func (s *Source) MarkQueueFailed() {
 	state.NextAttemptDue = time.Now().Add(fixedQueueBackoff)
 }
 
-func (s *Source) GetCurrentSource() string {
+func (s *Source) MarkQueueSucceeded(name string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if s.checkInit() {
-		return s.Curr
-	}
 
-	currState := s.queueStates[s.Curr]
-	if currState != nil && time.Now().After(currState.NextAttemptDue) {
-		return s.Curr
+	if state := s.queueStates[name]; state != nil {
+		state.ConsecutiveFailures = 0
 	}
-	s.findNewCurrSource()
-	return s.Curr
 }
 
-func (s *Source) checkInit() bool {
-	initialState := s.queueStates[s.init]
-	initialAvailable := (initialState != nil && time.Now().After(initialState.NextAttemptDue))
-	if initialAvailable && s.Curr != s.init {
+func (s *Source) GetCurrentSource() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if initState := s.queueStates[s.init]; initState == nil || now.After(initState.NextAttemptDue) {
 		s.Curr = s.init
-		return true
+		return s.Curr
 	}
-	return false
+	s.Curr = s.pickWeighted(now)
+	return s.Curr
 }
 
-func (s *Source) findNewCurrSource() {
-	for _, qName := range FallbackOrder {
-		if qName == s.Curr {
+func (s *Source) pickWeighted(now time.Time) string {
+	var (
+		best  *QueueState
+		total int
+	)
+	for _, state := range s.order {
+		if state.Name == s.init || state.Weight <= 0 || !now.After(state.NextAttemptDue) {
 			continue
 		}
-		state := s.queueStates[qName]
-		if state != nil && time.Now().After(state.NextAttemptDue) {
-			s.Curr = qName
-			return
+		state.currentWeight += state.Weight
+		total += state.Weight
+		if best == nil || state.currentWeight > best.currentWeight {
+			best = state
 		}
 	}
-	s.Curr = s.init
+	if best == nil {
+		return s.init
+	}
+	best.currentWeight -= total
+	return best.Name
 }
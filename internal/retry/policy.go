@@ -0,0 +1,192 @@
+package retry
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"time"
+
+	"github.com/NesterovYehor/Crawler/internal/config"
+	"github.com/NesterovYehor/Crawler/internal/models"
+	"github.com/NesterovYehor/Crawler/internal/utils"
+)
+
+const (
+	Exponential = "exponential"
+	Linear      = "linear"
+	Constant    = "constant"
+)
+
+// Triggers say what set the delay of a retry.
+const (
+	TriggerBackoff    = "backoff"
+	TriggerRetryAfter = "retry_after"
+	TriggerExhausted  = "exhausted"
+)
+
+// Default is used for the fields a configuration leaves unset. It matches
+// the backoff tasks had before retries were configurable.
+var Default = config.RetryRule{
+	MaxAttempts: models.MaxRetries,
+	Backoff:     Exponential,
+	BaseDelay:   2 * time.Second,
+	Multiplier:  2,
+	MaxDelay:    10 * time.Minute,
+}
+
+// Decision is what the policy did with a failed task.
+type Decision struct {
+	Class   string
+	Trigger string
+	Delay   time.Duration
+	// Attempt is the number of the failed attempt; MaxAttempts its limit.
+	Attempt     int
+	MaxAttempts int
+}
+
+// Policy schedules the retries of failed tasks by topic and error class.
+type Policy struct {
+	def           config.RetryRule
+	topics        map[string]config.RetryRule
+	classes       map[string]config.RetryRule
+	maxRetryAfter time.Duration
+
+	random func() float64
+}
+
+// NewPolicy returns the policy described by cfg. A nil cfg uses Default
+// for every task.
+func NewPolicy(cfg *config.Retry) (*Policy, error) {
+	p := &Policy{def: Default, random: rand.Float64}
+	if cfg == nil {
+		return p, nil
+	}
+	p.def = merge(Default, cfg.Default)
+	p.topics, p.classes, p.maxRetryAfter = cfg.Topics, cfg.Classes, cfg.MaxRetryAfter
+
+	rules := map[string]config.RetryRule{"default": p.def}
+	for topic, r := range p.topics {
+		rules["topic "+topic] = r
+	}
+	for class, r := range p.classes {
+		rules["class "+class] = r
+	}
+	for name, r := range rules {
+		if err := validate(r); err != nil {
+			return nil, fmt.Errorf("invalid retry rule for %s: %w", name, err)
+		}
+	}
+	return p, nil
+}
+
+func validate(r config.RetryRule) error {
+	switch r.Backoff {
+	case "", Exponential, Linear, Constant:
+	default:
+		return fmt.Errorf("unknown backoff %q", r.Backoff)
+	}
+	if r.MaxAttempts < 0 || r.BaseDelay < 0 || r.MaxDelay < 0 || r.Multiplier < 0 {
+		return fmt.Errorf("negative limit")
+	}
+	if r.Jitter < 0 || r.Jitter > 1 {
+		return fmt.Errorf("jitter %v is not between 0 and 1", r.Jitter)
+	}
+	return nil
+}
+
+// merge returns base with the fields o sets.
+func merge(base, o config.RetryRule) config.RetryRule {
+	if o.MaxAttempts > 0 {
+		base.MaxAttempts = o.MaxAttempts
+	}
+	if o.Backoff != "" {
+		base.Backoff = o.Backoff
+	}
+	if o.BaseDelay > 0 {
+		base.BaseDelay = o.BaseDelay
+	}
+	if o.Multiplier > 0 {
+		base.Multiplier = o.Multiplier
+	}
+	if o.MaxDelay > 0 {
+		base.MaxDelay = o.MaxDelay
+	}
+	if o.Jitter > 0 {
+		base.Jitter = o.Jitter
+	}
+	return base
+}
+
+// Rule returns the rule for a task of topic that failed with an error of
+// class.
+func (p *Policy) Rule(topic, class string) config.RetryRule {
+	return merge(merge(p.def, p.topics[topic]), p.classes[class])
+}
+
+// Backoff returns the delay before the attempt after the given one,
+// without jitter.
+func Backoff(r config.RetryRule, attempt int) time.Duration {
+	attempt = max(attempt, 1)
+	var d float64
+	switch r.Backoff {
+	case Constant:
+		d = float64(r.BaseDelay)
+	case Linear:
+		d = float64(r.BaseDelay) * float64(attempt)
+	default:
+		d = float64(r.BaseDelay) * math.Pow(r.Multiplier, float64(attempt-1))
+	}
+	if r.MaxDelay > 0 && d > float64(r.MaxDelay) {
+		return r.MaxDelay
+	}
+	return time.Duration(d)
+}
+
+// Schedule decides the retry of a task that failed with err, whose attempt
+// RecordAttempt has already added. It sets the task's attempt limit and
+// records the delay and the reason for it with the attempt; the queue then
+// either schedules the task after the delay or dead-letters it.
+func (p *Policy) Schedule(task *models.Task, err error) Decision {
+	class := utils.ClassifyError(err)
+	rule := p.Rule(task.Topic, class)
+	d := Decision{
+		Class:       class,
+		Trigger:     TriggerBackoff,
+		Attempt:     task.Retries + 1,
+		MaxAttempts: rule.MaxAttempts,
+	}
+	task.MaxAttempts = rule.MaxAttempts
+
+	var reason string
+	if d.Attempt >= d.MaxAttempts {
+		d.Trigger = TriggerExhausted
+		reason = fmt.Sprintf("%s: gave up after %d of %d attempts", class, d.Attempt, d.MaxAttempts)
+	} else {
+		d.Delay = p.jitter(Backoff(rule, d.Attempt), rule.Jitter)
+		reason = fmt.Sprintf("%s: %s backoff %s, attempt %d of %d", class, rule.Backoff, d.Delay, d.Attempt, d.MaxAttempts)
+		// The server's delay wins unless the backoff is longer already.
+		if retryAfter, ok := utils.RetryAfter(err); ok {
+			if p.maxRetryAfter > 0 {
+				retryAfter = min(retryAfter, p.maxRetryAfter)
+			}
+			if retryAfter > d.Delay {
+				d.Trigger, d.Delay = TriggerRetryAfter, retryAfter
+				reason = fmt.Sprintf("%s: Retry-After %s, attempt %d of %d", class, d.Delay, d.Attempt, d.MaxAttempts)
+			}
+		}
+	}
+
+	if n := len(task.Attempts); n > 0 {
+		task.Attempts[n-1].Reason = reason
+		task.Attempts[n-1].Delay = d.Delay
+	}
+	return d
+}
+
+// jitter spreads d uniformly by up to fraction of itself either way.
+func (p *Policy) jitter(d time.Duration, fraction float64) time.Duration {
+	if fraction <= 0 || d <= 0 {
+		return d
+	}
+	return time.Duration(float64(d) * (1 + fraction*(2*p.random()-1)))
+}
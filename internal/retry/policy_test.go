@@ -0,0 +1,128 @@
+package retry
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/NesterovYehor/Crawler/internal/config"
+	"github.com/NesterovYehor/Crawler/internal/models"
+	"github.com/NesterovYehor/Crawler/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func failed(topic string, retries int, err error) *models.Task {
+	task := models.NewTask(topic, "https://example.com/", "", "")
+	task.Retries = retries
+	task.RecordAttempt(err)
+	return task
+}
+
+func TestNewPolicyValidates(t *testing.T) {
+	for name, cfg := range map[string]config.Retry{
+		"backoff": {Default: config.RetryRule{Backoff: "fibonacci"}},
+		"jitter":  {Classes: map[string]config.RetryRule{utils.ErrorClassDNS: {Jitter: 2}}},
+		"delay":   {Topics: map[string]config.RetryRule{"crawl_page": {BaseDelay: -time.Second}}},
+	} {
+		_, err := NewPolicy(&cfg)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	r := config.RetryRule{BaseDelay: time.Second, Multiplier: 3, MaxDelay: 20 * time.Second}
+	r.Backoff = Exponential
+	assert.Equal(t, []time.Duration{time.Second, 3 * time.Second, 9 * time.Second, 20 * time.Second},
+		[]time.Duration{Backoff(r, 1), Backoff(r, 2), Backoff(r, 3), Backoff(r, 4)})
+	r.Backoff = Linear
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 3 * time.Second},
+		[]time.Duration{Backoff(r, 1), Backoff(r, 2), Backoff(r, 3)})
+	r.Backoff = Constant
+	assert.Equal(t, time.Second, Backoff(r, 7))
+}
+
+func TestPolicyDefaultsMatchLegacyBackoff(t *testing.T) {
+	p, err := NewPolicy(nil)
+	require.NoError(t, err)
+	task := failed("crawl_page", 1, utils.ErrRetryLater)
+	d := p.Schedule(task, utils.ErrRetryLater)
+	assert.Equal(t, 4*time.Second, d.Delay)
+	assert.Equal(t, models.MaxRetries, task.MaxAttempts)
+}
+
+func TestPolicyRulesByTopicAndClass(t *testing.T) {
+	p, err := NewPolicy(&config.Retry{
+		Default: config.RetryRule{MaxAttempts: 5, BaseDelay: time.Second, Multiplier: 2},
+		Topics:  map[string]config.RetryRule{"store_data": {Backoff: Constant, BaseDelay: 5 * time.Second}},
+		Classes: map[string]config.RetryRule{
+			utils.ErrorClassServerError: {MaxAttempts: 3},
+			utils.ErrorClassHTTPStatus:  {MaxAttempts: 1},
+		},
+	})
+	require.NoError(t, err)
+
+	r := p.Rule("store_data", utils.ErrorClassServerError)
+	assert.Equal(t, Constant, r.Backoff, "from the topic")
+	assert.Equal(t, 5*time.Second, r.BaseDelay, "from the topic")
+	assert.Equal(t, 3, r.MaxAttempts, "from the class")
+
+	serverErr := &utils.StatusError{Code: 503}
+	task := failed("crawl_page", 1, serverErr)
+	d := p.Schedule(task, serverErr)
+	assert.Equal(t, TriggerBackoff, d.Trigger)
+	assert.Equal(t, 2*time.Second, d.Delay)
+	assert.Equal(t, 3, task.MaxAttempts)
+	last, _ := task.LastAttempt()
+	assert.Equal(t, "server_error: exponential backoff 2s, attempt 2 of 3", last.Reason)
+	assert.Equal(t, 2*time.Second, last.Delay)
+
+	task = failed("crawl_page", 2, serverErr)
+	d = p.Schedule(task, serverErr)
+	assert.Equal(t, TriggerExhausted, d.Trigger)
+	task.Retries++
+	assert.True(t, task.Exhausted(), "the queue dead-letters the task")
+
+	notFound := utils.ErrInvalidStatusCode(404)
+	task = failed("crawl_page", 0, notFound)
+	assert.Equal(t, TriggerExhausted, p.Schedule(task, notFound).Trigger)
+}
+
+func TestPolicyHonorsRetryAfter(t *testing.T) {
+	p, err := NewPolicy(&config.Retry{
+		Default:       config.RetryRule{BaseDelay: time.Second},
+		MaxRetryAfter: time.Hour,
+	})
+	require.NoError(t, err)
+
+	limited := fmt.Errorf("crawl failed: %w", &utils.StatusError{Code: 429, RetryAfter: 2 * time.Minute})
+	task := failed("crawl_page", 0, limited)
+	d := p.Schedule(task, limited)
+	assert.Equal(t, utils.ErrorClassRateLimited, d.Class)
+	assert.Equal(t, TriggerRetryAfter, d.Trigger)
+	assert.Equal(t, 2*time.Minute, d.Delay)
+	last, _ := task.LastAttempt()
+	assert.Equal(t, "rate_limited: Retry-After 2m0s, attempt 1 of 5", last.Reason)
+
+	// A Retry-After beyond the cap is cut to it.
+	far := &utils.StatusError{Code: 503, RetryAfter: 48 * time.Hour}
+	assert.Equal(t, time.Hour, p.Schedule(failed("crawl_page", 0, far), far).Delay)
+
+	// A shorter Retry-After than the backoff does not shorten it.
+	soon := &utils.StatusError{Code: 503, RetryAfter: time.Millisecond}
+	d = p.Schedule(failed("crawl_page", 0, soon), soon)
+	assert.Equal(t, TriggerBackoff, d.Trigger)
+	assert.Equal(t, time.Second, d.Delay)
+}
+
+func TestPolicyJitter(t *testing.T) {
+	p, err := NewPolicy(&config.Retry{Default: config.RetryRule{BaseDelay: 10 * time.Second, Jitter: 0.5}})
+	require.NoError(t, err)
+	for _, tc := range []struct {
+		random float64
+		want   time.Duration
+	}{{0, 5 * time.Second}, {0.5, 10 * time.Second}, {1, 15 * time.Second}} {
+		p.random = func() float64 { return tc.random }
+		assert.Equal(t, tc.want, p.Schedule(failed("crawl_page", 0, utils.ErrRetryLater), utils.ErrRetryLater).Delay)
+	}
+}
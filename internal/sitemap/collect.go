@@ -0,0 +1,117 @@
+package sitemap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/NesterovYehor/Crawler/internal/config"
+)
+
+// Defaults are used for the limits a configuration leaves at zero.
+var Defaults = config.Sitemaps{MaxDepth: 2, MaxFiles: 100, MaxURLs: 200000}
+
+// FetchFunc returns the body of the sitemap at rawURL.
+type FetchFunc func(ctx context.Context, rawURL string) (io.ReadCloser, error)
+
+type pending struct {
+	url   string
+	depth int
+	// host is the host of the root sitemap the index listing url came
+	// from.
+	host string
+}
+
+// Collect reads the sitemaps at roots and, breadth first, the sitemaps
+// their indexes list, within limits. An index is only followed to
+// sitemaps on the host of the root it came from. It returns the pages
+// found, each URL once, along with the failures of single sitemaps joined
+// into one error; a failed sitemap does not stop the others.
+func Collect(ctx context.Context, fetch FetchFunc, roots []string, limits config.Sitemaps) ([]Entry, error) {
+	if limits.MaxDepth <= 0 {
+		limits.MaxDepth = Defaults.MaxDepth
+	}
+	if limits.MaxFiles <= 0 {
+		limits.MaxFiles = Defaults.MaxFiles
+	}
+	if limits.MaxURLs <= 0 {
+		limits.MaxURLs = Defaults.MaxURLs
+	}
+
+	var (
+		entries []Entry
+		errs    []error
+		queue   []pending
+		visited = make(map[string]bool)
+		seen    = make(map[string]bool)
+	)
+	for _, root := range roots {
+		queue = append(queue, pending{url: root, host: hostOf(root)})
+	}
+
+	for len(queue) > 0 {
+		if err := ctx.Err(); err != nil {
+			errs = append(errs, err)
+			break
+		}
+		next := queue[0]
+		queue = queue[1:]
+		if visited[next.url] {
+			continue
+		}
+		if len(visited) == limits.MaxFiles {
+			errs = append(errs, fmt.Errorf("stopped after %d sitemaps", limits.MaxFiles))
+			break
+		}
+		visited[next.url] = true
+
+		full := false
+		err := read(ctx, fetch, next.url, func(e Entry) {
+			if seen[e.URL] || full {
+				return
+			}
+			if len(entries) == limits.MaxURLs {
+				full = true
+				return
+			}
+			seen[e.URL] = true
+			entries = append(entries, e)
+		}, func(loc string) {
+			if next.depth < limits.MaxDepth && !visited[loc] && hostOf(loc) == next.host {
+				queue = append(queue, pending{url: loc, depth: next.depth + 1, host: next.host})
+			}
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("sitemap %s: %w", next.url, err))
+		}
+		if full {
+			errs = append(errs, fmt.Errorf("stopped at %d URLs", limits.MaxURLs))
+			break
+		}
+	}
+	return entries, errors.Join(errs...)
+}
+
+func hostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Host)
+}
+
+func read(ctx context.Context, fetch FetchFunc, rawURL string, page func(Entry), index func(string)) error {
+	base, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	body, err := fetch(ctx, rawURL)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	return Parse(body, base, page, index)
+}
@@ -0,0 +1,111 @@
+package sitemap
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/NesterovYehor/Crawler/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSite serves sitemaps from a map and records the order they were
+// fetched in.
+type fakeSite struct {
+	files   map[string]string
+	fetched []string
+}
+
+func (s *fakeSite) fetch(ctx context.Context, rawURL string) (io.ReadCloser, error) {
+	s.fetched = append(s.fetched, rawURL)
+	body, ok := s.files[rawURL]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return io.NopCloser(strings.NewReader(body)), nil
+}
+
+func index(locs ...string) string {
+	var b strings.Builder
+	b.WriteString("<sitemapindex>")
+	for _, l := range locs {
+		b.WriteString("<sitemap><loc>" + l + "</loc></sitemap>")
+	}
+	b.WriteString("</sitemapindex>")
+	return b.String()
+}
+
+func urlset(locs ...string) string {
+	var b strings.Builder
+	b.WriteString("<urlset>")
+	for _, l := range locs {
+		b.WriteString("<url><loc>" + l + "</loc></url>")
+	}
+	b.WriteString("</urlset>")
+	return b.String()
+}
+
+func urls(entries []Entry) []string {
+	var out []string
+	for _, e := range entries {
+		out = append(out, e.URL)
+	}
+	return out
+}
+
+func TestCollectFollowsIndexes(t *testing.T) {
+	site := &fakeSite{files: map[string]string{
+		"https://example.com/index.xml":  index("https://example.com/a.xml", "https://example.com/nested.xml", "https://example.com/missing.xml"),
+		"https://example.com/a.xml":      urlset("https://example.com/1", "https://example.com/2"),
+		"https://example.com/nested.xml": index("https://example.com/b.xml", "https://example.com/index.xml"),
+		"https://example.com/b.xml":      urlset("https://example.com/2", "https://example.com/3"),
+		"https://example.com/deep.xml":   urlset("https://example.com/4"),
+	}}
+	entries, err := Collect(context.Background(), site.fetch, []string{"https://example.com/index.xml"}, config.Sitemaps{})
+	assert.ErrorContains(t, err, "sitemap https://example.com/missing.xml: not found")
+	assert.Equal(t, []string{"https://example.com/1", "https://example.com/2", "https://example.com/3"}, urls(entries),
+		"a failed sitemap does not stop the others and each URL is kept once")
+	assert.NotContains(t, site.fetched[1:], "https://example.com/index.xml", "a sitemap is read once")
+}
+
+func TestCollectStaysOnRootHost(t *testing.T) {
+	site := &fakeSite{files: map[string]string{
+		"https://example.com/index.xml": index("https://example.com/a.xml", "https://evil.test/b.xml", "https://cdn.example.com/c.xml"),
+		"https://example.com/a.xml":     urlset("https://example.com/1"),
+		"https://evil.test/b.xml":       urlset("https://evil.test/2"),
+		"https://cdn.example.com/c.xml": urlset("https://example.com/3"),
+	}}
+	entries, err := Collect(context.Background(), site.fetch, []string{"https://example.com/index.xml"}, config.Sitemaps{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"https://example.com/1"}, urls(entries))
+	assert.Equal(t, []string{"https://example.com/index.xml", "https://example.com/a.xml"}, site.fetched,
+		"sitemaps on other hosts are not fetched")
+}
+
+func TestCollectLimits(t *testing.T) {
+	site := &fakeSite{files: map[string]string{
+		"https://example.com/index.xml":  index("https://example.com/nested.xml", "https://example.com/a.xml"),
+		"https://example.com/nested.xml": index("https://example.com/b.xml"),
+		"https://example.com/a.xml":      urlset("https://example.com/1", "https://example.com/2", "https://example.com/3"),
+		"https://example.com/b.xml":      urlset("https://example.com/4"),
+	}}
+	ctx := context.Background()
+	roots := []string{"https://example.com/index.xml"}
+
+	entries, err := Collect(ctx, site.fetch, roots, config.Sitemaps{MaxDepth: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"https://example.com/1", "https://example.com/2", "https://example.com/3"}, urls(entries),
+		"indexes deeper than max_depth are not followed")
+
+	entries, err = Collect(ctx, site.fetch, roots, config.Sitemaps{MaxURLs: 2})
+	assert.ErrorContains(t, err, "stopped at 2 URLs")
+	assert.Len(t, entries, 2)
+
+	site.fetched = nil
+	_, err = Collect(ctx, site.fetch, roots, config.Sitemaps{MaxFiles: 2})
+	assert.ErrorContains(t, err, "stopped after 2 sitemaps")
+	assert.Len(t, site.fetched, 2)
+}
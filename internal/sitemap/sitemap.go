@@ -0,0 +1,260 @@
+package sitemap
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limits of a single sitemap file set by the sitemap protocol.
+const (
+	MaxFileURLs  = 50000
+	MaxFileBytes = 50 << 20
+)
+
+// DefaultPriority is the priority of a URL whose entry sets none.
+const DefaultPriority = 0.5
+
+var (
+	ErrTooLarge    = fmt.Errorf("sitemap is larger than %d bytes uncompressed", MaxFileBytes)
+	ErrTooManyURLs = fmt.Errorf("sitemap lists more than %d URLs", MaxFileURLs)
+)
+
+// Entry is a page listed in a sitemap. ChangeFreq is empty and LastMod
+// zero when the entry does not set them.
+type Entry struct {
+	URL        string
+	LastMod    time.Time
+	ChangeFreq string
+	Priority   float64
+}
+
+type urlXML struct {
+	Loc        string `xml:"loc"`
+	LastMod    string `xml:"lastmod"`
+	ChangeFreq string `xml:"changefreq"`
+	Priority   string `xml:"priority"`
+}
+
+type sitemapXML struct {
+	Loc string `xml:"loc"`
+}
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// Parse reads the sitemap in r: a <urlset>, a <sitemapindex> or a plain
+// text list of URLs, gzip-compressed or not. It streams the file, calling
+// page for each page it lists and index for each sitemap an index lists.
+// Relative URLs are resolved against base and URLs other than http and
+// https are skipped. A file beyond the protocol limits stops with
+// ErrTooLarge or ErrTooManyURLs once the entries before were handed on.
+func Parse(r io.Reader, base *url.URL, page func(Entry), index func(string)) error {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(len(gzipMagic)); bytes.Equal(magic, gzipMagic) {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return fmt.Errorf("invalid gzip sitemap: %w", err)
+		}
+		defer zr.Close()
+		br = bufio.NewReader(zr)
+	}
+	br = bufio.NewReader(&limitReader{r: br, remaining: MaxFileBytes})
+
+	p := &parser{base: base, page: page, index: index}
+	if isXML(br) {
+		return p.parseXML(br)
+	}
+	return p.parseText(br)
+}
+
+// isXML reports whether the first character after any byte order mark
+// and white space is a '<'.
+func isXML(br *bufio.Reader) bool {
+	head, _ := br.Peek(512)
+	head = bytes.TrimPrefix(head, []byte("\xef\xbb\xbf"))
+	head = bytes.TrimLeft(head, " \t\r\n")
+	return len(head) > 0 && head[0] == '<'
+}
+
+type parser struct {
+	base  *url.URL
+	page  func(Entry)
+	index func(string)
+	n     int
+}
+
+func (p *parser) parseXML(r io.Reader) error {
+	dec := xml.NewDecoder(r)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return p.readError(err)
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case "url":
+			var u urlXML
+			if err := dec.DecodeElement(&u, &start); err != nil {
+				return p.readError(err)
+			}
+			loc, ok := p.resolve(u.Loc)
+			if !ok {
+				continue
+			}
+			if err := p.count(); err != nil {
+				return err
+			}
+			p.page(Entry{
+				URL:        loc,
+				LastMod:    parseLastMod(u.LastMod),
+				ChangeFreq: parseChangeFreq(u.ChangeFreq),
+				Priority:   parsePriority(u.Priority),
+			})
+		case "sitemap":
+			var s sitemapXML
+			if err := dec.DecodeElement(&s, &start); err != nil {
+				return p.readError(err)
+			}
+			loc, ok := p.resolve(s.Loc)
+			if !ok {
+				continue
+			}
+			if err := p.count(); err != nil {
+				return err
+			}
+			p.index(loc)
+		}
+	}
+}
+
+func (p *parser) parseText(r io.Reader) error {
+	sc := bufio.NewScanner(r)
+	for first := true; sc.Scan(); first = false {
+		line := strings.TrimSpace(sc.Text())
+		if first {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+		// Text sitemaps list absolute URLs only; anything else is noise.
+		if u, err := url.Parse(line); err != nil || !u.IsAbs() {
+			continue
+		}
+		loc, ok := p.resolve(line)
+		if !ok {
+			continue
+		}
+		if err := p.count(); err != nil {
+			return err
+		}
+		p.page(Entry{URL: loc, Priority: DefaultPriority})
+	}
+	if err := sc.Err(); err != nil {
+		return p.readError(err)
+	}
+	return nil
+}
+
+func (p *parser) count() error {
+	p.n++
+	if p.n > MaxFileURLs {
+		return ErrTooManyURLs
+	}
+	return nil
+}
+
+func (p *parser) readError(err error) error {
+	if errors.Is(err, ErrTooLarge) {
+		return ErrTooLarge
+	}
+	return fmt.Errorf("invalid sitemap: %w", err)
+}
+
+func (p *parser) resolve(loc string) (string, bool) {
+	loc = strings.TrimSpace(loc)
+	if loc == "" {
+		return "", false
+	}
+	u, err := url.Parse(loc)
+	if err != nil {
+		return "", false
+	}
+	if p.base != nil {
+		u = p.base.ResolveReference(u)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", false
+	}
+	return u.String(), true
+}
+
+// lastModLayouts are the W3C datetime forms sitemaps use.
+var lastModLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+	"2006-01",
+	"2006",
+}
+
+func parseLastMod(v string) time.Time {
+	v = strings.TrimSpace(v)
+	for _, layout := range lastModLayouts {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func parseChangeFreq(v string) string {
+	v = strings.ToLower(strings.TrimSpace(v))
+	switch v {
+	case "always", "hourly", "daily", "weekly", "monthly", "yearly", "never":
+		return v
+	}
+	return ""
+}
+
+func parsePriority(v string) float64 {
+	p, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	if err != nil || p < 0 || p > 1 {
+		return DefaultPriority
+	}
+	return p
+}
+
+// limitReader fails with ErrTooLarge once more than remaining bytes are
+// read.
+type limitReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, ErrTooLarge
+	}
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n, ErrTooLarge
+	}
+	return n, err
+}
@@ -0,0 +1,129 @@
+package sitemap
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type parsed struct {
+	pages   []Entry
+	indexed []string
+}
+
+func parse(t *testing.T, r io.Reader) (parsed, error) {
+	t.Helper()
+	base, err := url.Parse("https://example.com/sitemaps/main.xml")
+	require.NoError(t, err)
+	var p parsed
+	err = Parse(r, base,
+		func(e Entry) { p.pages = append(p.pages, e) },
+		func(loc string) { p.indexed = append(p.indexed, loc) })
+	return p, err
+}
+
+func TestParseURLSet(t *testing.T) {
+	p, err := parse(t, strings.NewReader(`<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9"
+        xmlns:image="http://www.google.com/schemas/sitemap-image/1.1">
+  <url>
+    <loc> https://example.com/a </loc>
+    <lastmod>2024-05-01T12:30:00+02:00</lastmod>
+    <changefreq>Daily</changefreq>
+    <priority>0.9</priority>
+    <image:image><image:loc>https://cdn.example.com/a.png</image:loc></image:image>
+  </url>
+  <url><loc>/b</loc><lastmod>2024-05-01</lastmod><priority>high</priority></url>
+  <url><loc>ftp://example.com/c</loc></url>
+  <url><changefreq>weekly</changefreq></url>
+</urlset>`))
+	require.NoError(t, err)
+	assert.Empty(t, p.indexed)
+	require.Len(t, p.pages, 2)
+
+	assert.Equal(t, "https://example.com/a", p.pages[0].URL)
+	assert.True(t, p.pages[0].LastMod.Equal(time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC)))
+	assert.Equal(t, "daily", p.pages[0].ChangeFreq)
+	assert.Equal(t, 0.9, p.pages[0].Priority)
+
+	assert.Equal(t, "https://example.com/b", p.pages[1].URL, "relative to the sitemap")
+	assert.Equal(t, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), p.pages[1].LastMod)
+	assert.Empty(t, p.pages[1].ChangeFreq)
+	assert.Equal(t, DefaultPriority, p.pages[1].Priority, "an invalid priority is the default")
+}
+
+func TestParseIndex(t *testing.T) {
+	p, err := parse(t, strings.NewReader(`<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>https://example.com/posts.xml.gz</loc><lastmod>2024-05-01</lastmod></sitemap>
+  <sitemap><loc>pages.xml</loc></sitemap>
+</sitemapindex>`))
+	require.NoError(t, err)
+	assert.Empty(t, p.pages)
+	assert.Equal(t, []string{"https://example.com/posts.xml.gz", "https://example.com/sitemaps/pages.xml"}, p.indexed)
+}
+
+func TestParseText(t *testing.T) {
+	p, err := parse(t, strings.NewReader("\ufeffhttps://example.com/a\r\n\n  https://example.com/b  \nnot a url\n"))
+	require.NoError(t, err)
+	require.Len(t, p.pages, 2)
+	assert.Equal(t, Entry{URL: "https://example.com/a", Priority: DefaultPriority}, p.pages[0])
+	assert.Equal(t, "https://example.com/b", p.pages[1].URL)
+}
+
+func TestParseGzip(t *testing.T) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	io.WriteString(zw, `<urlset><url><loc>https://example.com/a</loc></url></urlset>`)
+	require.NoError(t, zw.Close())
+
+	p, err := parse(t, &buf)
+	require.NoError(t, err)
+	require.Len(t, p.pages, 1)
+	assert.Equal(t, "https://example.com/a", p.pages[0].URL)
+}
+
+func TestParseInvalidXMLKeepsEarlierEntries(t *testing.T) {
+	p, err := parse(t, strings.NewReader(`<urlset><url><loc>https://example.com/a</loc></url><url><loc>`))
+	assert.Error(t, err)
+	assert.Len(t, p.pages, 1)
+}
+
+func TestParseLimits(t *testing.T) {
+	t.Run("urls", func(t *testing.T) {
+		r, w := io.Pipe()
+		go func() {
+			io.WriteString(w, "<urlset>")
+			for i := range MaxFileURLs + 10 {
+				fmt.Fprintf(w, "<url><loc>https://example.com/%d</loc></url>", i)
+			}
+			io.WriteString(w, "</urlset>")
+			w.Close()
+		}()
+		p, err := parse(t, r)
+		r.Close()
+		assert.ErrorIs(t, err, ErrTooManyURLs)
+		assert.Len(t, p.pages, MaxFileURLs)
+	})
+
+	t.Run("bytes", func(t *testing.T) {
+		// One URL and a comment padding the file past the limit.
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		io.WriteString(zw, "<urlset><url><loc>https://example.com/a</loc></url><!--")
+		zw.Write(bytes.Repeat([]byte("x"), MaxFileBytes))
+		io.WriteString(zw, "--></urlset>")
+		require.NoError(t, zw.Close())
+
+		p, err := parse(t, &buf)
+		assert.ErrorIs(t, err, ErrTooLarge)
+		assert.Len(t, p.pages, 1)
+	})
+}
@@ -29,8 +29,8 @@ func NewCache(client *redis.Client, metrics metrics.CacheMetrics) (*Cache, error
 	}, nil
 }
 
-func (c *Cache) RunScript(key, sriptHash string, ctx context.Context) (any, error) {
-	return c.client.EvalSha(ctx, sriptHash, []string{key}).Result()
+func (c *Cache) RunScript(key, sriptHash string, ctx context.Context, args ...any) (any, error) {
+	return c.client.EvalSha(ctx, sriptHash, []string{key}, args...).Result()
 }
 
 func (c *Cache) Get(ctx context.Context, key string) ([]byte, error) {
@@ -44,6 +44,19 @@ func (c *Cache) Get(ctx context.Context, key string) ([]byte, error) {
 	return data, nil
 }
 
+func (c *Cache) GetField(ctx context.Context, key, field string) (string, error) {
+	start := time.Now()
+	value, err := c.client.HGet(ctx, key, field).Result()
+	if err == redis.Nil {
+		return "", err
+	} else if err != nil {
+		c.metrics.RedisMetrics().ObserveFailure()
+		return "", err
+	}
+	c.metrics.RedisMetrics().ObserveFetch(time.Since(start))
+	return value, nil
+}
+
 func (c *Cache) Save(ctx context.Context, key string, values map[string]any) error {
 	start := time.Now()
 	err := c.client.HSet(ctx, key, values).Err()
@@ -80,6 +93,28 @@ func (c *Cache) AddToBF(ctx context.Context, key string) error {
 	return nil
 }
 
+// AddToFilter adds value to the named bloom filter, creating the filter on
+// first use.
+func (c *Cache) AddToFilter(ctx context.Context, filter, value string) error {
+	err := c.client.BFAdd(ctx, filter, value).Err()
+	if err != nil {
+		c.metrics.BloomFilterMetrics().ObserveFailure()
+		return fmt.Errorf("failed to add value to bloom filter %s: %w", filter, err)
+	}
+	c.metrics.BloomFilterMetrics().ObserveAdd()
+	return nil
+}
+
+func (c *Cache) CheckFilter(ctx context.Context, filter, value string) (bool, error) {
+	exist, err := c.client.BFExists(ctx, filter, value).Result()
+	if err != nil {
+		c.metrics.BloomFilterMetrics().ObserveFailure()
+		return false, err
+	}
+	c.metrics.BloomFilterMetrics().ObserveFetch(exist)
+	return exist, nil
+}
+
 func (c *Cache) CheckBF(ctx context.Context, key string) (bool, error) {
 	exist, err := c.client.BFExists(ctx, key, key).Result()
 	if err != nil {
@@ -2,7 +2,9 @@ package metadata
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/NesterovYehor/Crawler/internal/config"
@@ -15,25 +17,26 @@ type cassandraStore struct {
 	session *gocql.Session
 	metrics metrics.DBMetrics
 }
+
 func NewCassandraStore(cfg *config.DB, metrics metrics.DBMetrics) (MetadataStore, error) {
 	cluster := gocql.NewCluster(cfg.Addr)
 	cluster.Consistency = gocql.Quorum
 	cluster.ProtoVersion = 4
 
-	tempSess, err := cluster.CreateSession() 
+	tempSess, err := cluster.CreateSession()
 	if err != nil {
 		return nil, fmt.Errorf("failed to create temporary session for keyspace DDL: %w", err)
 	}
-	defer tempSess.Close() 
+	defer tempSess.Close()
 
 	err = tempSess.Query(`CREATE KEYSPACE IF NOT EXISTS metadata WITH REPLICATION = { 'class' : 'SimpleStrategy', 'replication_factor' : 1 };`).Exec()
 	if err != nil {
 		return nil, fmt.Errorf("failed to create new keyspace: %w", err)
 	}
 
-	cluster.Keyspace = "metadata" 
-	
-	sess, err := cluster.CreateSession() 
+	cluster.Keyspace = "metadata"
+
+	sess, err := cluster.CreateSession()
 	if err != nil {
 		return nil, fmt.Errorf("failed to create session for metadata keyspace: %w", err)
 	}
@@ -45,18 +48,49 @@ func NewCassandraStore(cfg *config.DB, metrics metrics.DBMetrics) (MetadataStore
 			html_hash text,
 			latency_ms bigint,
 			time timestamp,
-			content_length int
+			content_length int,
+			depth int,
+			parent_url text,
+			seed_id text,
+			discovery text,
+			job_id text,
+			etag text,
+			last_modified text,
+			fetch_status text,
+			truncated boolean,
+			charset text,
+			final_url text,
+			redirects list<text>
 		);
 	`).Exec()
 	if err != nil {
 		return nil, fmt.Errorf("failed to create new table: %w", err)
 	}
+	if err := addLineageColumns(sess); err != nil {
+		return nil, err
+	}
 
 	return &cassandraStore{
 		session: sess,
 		metrics: metrics,
 	}, nil
 }
+
+// addLineageColumns upgrades tables created before lineage, job IDs,
+// response validators, truncation markers, charsets and redirects were
+// stored.
+func addLineageColumns(sess *gocql.Session) error {
+	for _, col := range []string{"depth int", "parent_url text", "seed_id text", "discovery text", "job_id text",
+		"etag text", "last_modified text", "fetch_status text", "truncated boolean",
+		"charset text", "final_url text", "redirects list<text>"} {
+		err := sess.Query(`ALTER TABLE metadata.metadata ADD ` + col).Exec()
+		if err != nil && !strings.Contains(err.Error(), "conflicts with an existing column") {
+			return fmt.Errorf("failed to add column %s: %w", col, err)
+		}
+	}
+	return nil
+}
+
 func (c *cassandraStore) Close() {
 	c.session.Close()
 }
@@ -64,10 +98,14 @@ func (c *cassandraStore) Close() {
 func (c *cassandraStore) Save(ctx context.Context, data models.Metadata) error {
 	start := time.Now()
 	queue := `
-        insert into metadata (url, host, html_hash, latency_ms, time, content_length) values (?,?,?,?,?, ?)
+        insert into metadata (url, host, html_hash, latency_ms, time, content_length, depth, parent_url, seed_id, discovery, job_id,
+            etag, last_modified, fetch_status, truncated, charset, final_url, redirects) values (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)
     `
 
-	if err := c.session.Query(queue, data.URL, data.Host, data.HTMLHash, int64(data.Latency), data.Timestamp, data.ContentLen).Exec(); err != nil {
+	if err := c.session.Query(queue, data.URL, data.Host, data.HTMLHash, int64(data.Latency), data.Timestamp, data.ContentLen,
+		data.Depth, data.ParentURL, data.SeedID, data.Discovery, data.JobID,
+		data.ETag, data.LastModified, data.FetchStatus, data.Truncated, data.Charset,
+		data.FinalURL, data.Redirects).Exec(); err != nil {
 		c.metrics.Update(true, time.Since(start))
 		return err
 	}
@@ -77,15 +115,26 @@ func (c *cassandraStore) Save(ctx context.Context, data models.Metadata) error {
 	return nil
 }
 
+const metadataColumns = `url, host, html_hash, latency_ms, time, content_length, depth, parent_url, seed_id, discovery, job_id,
+	etag, last_modified, fetch_status, truncated, charset, final_url, redirects`
+
+// metadataDest returns the scan destinations for a row selected with
+// metadataColumns.
+func metadataDest(m *models.Metadata, latencyMs *int64) []any {
+	return []any{&m.URL, &m.Host, &m.HTMLHash, latencyMs, &m.Timestamp, &m.ContentLen, &m.Depth, &m.ParentURL, &m.SeedID,
+		&m.Discovery, &m.JobID, &m.ETag, &m.LastModified, &m.FetchStatus, &m.Truncated, &m.Charset,
+		&m.FinalURL, &m.Redirects}
+}
+
 func (c *cassandraStore) Get(ctx context.Context) ([]models.Metadata, error) {
 	var results []models.Metadata
 
-	iter := c.session.Query(`SELECT url, host, html_hash, latency_ms, time, content_length FROM metadata`).Iter()
+	iter := c.session.Query(`SELECT ` + metadataColumns + ` FROM metadata`).Iter()
 
 	var m models.Metadata
 	var latencyMs int64
 
-	for iter.Scan(&m.URL, &m.Host, &m.HTMLHash, &latencyMs, &m.Timestamp, &m.ContentLen) {
+	for iter.Scan(metadataDest(&m, &latencyMs)...) {
 		m.Latency = models.Latency(time.Duration(latencyMs) * time.Millisecond)
 		results = append(results, m)
 	}
@@ -95,3 +144,30 @@ func (c *cassandraStore) Get(ctx context.Context) ([]models.Metadata, error) {
 	}
 	return results, nil
 }
+
+func (c *cassandraStore) GetByURL(ctx context.Context, url string) (*models.Metadata, error) {
+	var m models.Metadata
+	var latencyMs int64
+
+	err := c.session.Query(`SELECT `+metadataColumns+` FROM metadata WHERE url = ?`, url).WithContext(ctx).
+		Scan(metadataDest(&m, &latencyMs)...)
+	if errors.Is(err, gocql.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query metadata of %s: %w", url, err)
+	}
+	m.Latency = models.Latency(time.Duration(latencyMs) * time.Millisecond)
+	return &m, nil
+}
+
+func (c *cassandraStore) MarkUnchanged(ctx context.Context, url string, at time.Time) error {
+	start := time.Now()
+	err := c.session.Query(`UPDATE metadata SET time = ?, fetch_status = ? WHERE url = ?`,
+		at, models.FetchStatusUnchanged, url).WithContext(ctx).Exec()
+	c.metrics.Update(err != nil, time.Since(start))
+	if err != nil {
+		return fmt.Errorf("failed to mark %s unchanged: %w", url, err)
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package metadata
 
 import (
 	"context"
+	"time"
 
 	"github.com/NesterovYehor/Crawler/internal/models"
 )
@@ -10,4 +11,9 @@ type MetadataStore interface {
 	Save(ctx context.Context, data models.Metadata) error
 	Close()
 	Get(ctx context.Context) ([]models.Metadata, error)
+	// GetByURL returns the metadata of a crawled page, or nil if the page
+	// was never stored.
+	GetByURL(ctx context.Context, url string) (*models.Metadata, error)
+	// MarkUnchanged records that a re-crawl of url found it unchanged.
+	MarkUnchanged(ctx context.Context, url string, at time.Time) error
 }
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"time"
 
+	"github.com/NesterovYehor/Crawler/internal/jobs"
 	"github.com/NesterovYehor/Crawler/internal/metrics"
 	"github.com/NesterovYehor/Crawler/internal/models"
 	"github.com/NesterovYehor/Crawler/internal/storage/blob"
@@ -46,6 +47,16 @@ func (st *Storage) AddToBF(ctx context.Context, key string) error {
 	return st.Cache.AddToBF(ctx, key)
 }
 
+// Seen reports whether url was already crawled by the job. Every job has its
+// own seen-set; tasks without a job share the global one.
+func (st *Storage) Seen(ctx context.Context, jobID, url string) (bool, error) {
+	return st.Cache.CheckFilter(ctx, jobs.SeenFilter(jobID), url)
+}
+
+func (st *Storage) MarkSeen(ctx context.Context, jobID, url string) error {
+	return st.Cache.AddToFilter(ctx, jobs.SeenFilter(jobID), url)
+}
+
 func (st *Storage) SaveToCache(ctx context.Context, key string, values map[string]any) error {
 	return st.Cache.Save(ctx, key, values)
 }
@@ -63,6 +74,13 @@ func (st *Storage) SaveIfNew(ctx context.Context, data *models.PageDataModel) er
 		if err := st.Metadata.Save(ctx, data.Metadata); err != nil {
 			return err
 		}
+		// A redirected page is stored under its final URL as well, for the
+		// conditional requests of later crawls of that URL.
+		if final, ok := data.Metadata.AtFinalURL(); ok {
+			if err := st.Metadata.Save(ctx, final); err != nil {
+				return err
+			}
+		}
 		return st.AddToBF(ctx, data.Metadata.HTMLHash)
 	}
 	st.metrics.Update(false, time.Since(start))
@@ -74,8 +92,24 @@ func (st *Storage) GetMemtadata(ctx context.Context) ([]models.Metadata, error)
 	return st.Metadata.Get(ctx)
 }
 
-func (st *Storage) RunScript(key, sriptHash string, ctx context.Context) (any, error) {
-	return st.Cache.RunScript(key, sriptHash, ctx)
+// GetMetadata returns the stored metadata of url, or nil if it was never
+// stored.
+func (st *Storage) GetMetadata(ctx context.Context, url string) (*models.Metadata, error) {
+	return st.Metadata.GetByURL(ctx, url)
+}
+
+func (st *Storage) MarkUnchanged(ctx context.Context, url string, at time.Time) error {
+	return st.Metadata.MarkUnchanged(ctx, url, at)
+}
+
+// GetCacheField returns a field of the hash SaveToCache stored at key, or
+// redis.Nil if there is none.
+func (st *Storage) GetCacheField(ctx context.Context, key, field string) (string, error) {
+	return st.Cache.GetField(ctx, key, field)
+}
+
+func (st *Storage) RunScript(key, sriptHash string, ctx context.Context, args ...any) (any, error) {
+	return st.Cache.RunScript(key, sriptHash, ctx, args...)
 }
 
 func (st *Storage) SaveTempWithUUID(ctx context.Context, data *models.PageDataModel) (string, error) {
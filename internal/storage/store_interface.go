@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"time"
 
 	"github.com/NesterovYehor/Crawler/internal/models"
 )
@@ -9,10 +10,15 @@ import (
 type Interface interface {
 	ExistsInBF(ctx context.Context, key string) (bool, error)
 	AddToBF(ctx context.Context, key string) error
+	Seen(ctx context.Context, jobID, url string) (bool, error)
+	MarkSeen(ctx context.Context, jobID, url string) error
 	SaveToCache(ctx context.Context, key string, values map[string]any) error
-	RunScript(key, sriptHash string, ctx context.Context) (any, error)
+	GetCacheField(ctx context.Context, key, field string) (string, error)
+	RunScript(key, sriptHash string, ctx context.Context, args ...any) (any, error)
 	SaveIfNew(ctx context.Context, data *models.PageDataModel) error
 	GetMemtadata(ctx context.Context) ([]models.Metadata, error)
+	GetMetadata(ctx context.Context, url string) (*models.Metadata, error)
+	MarkUnchanged(ctx context.Context, url string, at time.Time) error
 	SaveTempWithUUID(ctx context.Context, data *models.PageDataModel) (string, error)
 	GetTempByUUID(ctx context.Context, id string) (*models.PageDataModel, error)
 }
@@ -1,8 +1,31 @@
 package utils
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+const (
+	ErrorClassRetryLater   = "retry_later"
+	ErrorClassRateLimited  = "rate_limited"
+	ErrorClassServerError  = "server_error"
+	ErrorClassHTTPStatus   = "http_status"
+	ErrorClassTimeout      = "timeout"
+	ErrorClassDNS          = "dns"
+	ErrorClassConnection   = "connection"
+	ErrorClassNetwork      = "network"
+	ErrorClassRobots       = "robots"
+	ErrorClassContentType  = "content_type"
+	ErrorClassRedirect     = "redirect"
+	ErrorClassUnknownTopic = "unknown_topic"
+	ErrorClassPanic        = "panic"
+	ErrorClassUnknown      = "unknown"
 )
 
 var (
@@ -15,6 +38,13 @@ var (
 	ErrInValidPageData    = errors.New("page data is invalid")
 	ErrChanIsClosed       = errors.New("channel is closed, cannot get task")
 	ErrDomainRateLimited  = errors.New("domain is currently rate limited")
+	ErrUnknownTopic       = errors.New("no handler registered for task topic")
+	ErrTaskPanicked       = errors.New("task handler panicked")
+	ErrNotModified        = errors.New("page not modified since last crawl")
+	ErrNoProxy            = errors.New("no healthy proxy available")
+	ErrRedirectStopped    = errors.New("redirect not followed")
+	ErrRobotsDisallowed   = errors.New("access disallowed by robots.txt")
+	ErrInvalidRobots      = errors.New("invalid robots.txt")
 )
 
 func ErrInvalidTaskFormat(msg any) error {
@@ -26,5 +56,112 @@ func ErrInvalidRetriesValue(err error) error {
 }
 
 func ErrInvalidStatusCode(statusCode int) error{
-	return fmt.Errorf("invalid response status code: %v", statusCode)
+	return &StatusError{Code: statusCode}
+}
+
+// StatusError is a response whose status code is an error. RetryAfter is
+// the delay the server asked for in a Retry-After header, if it sent one.
+type StatusError struct {
+	Code       int
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("invalid response status code: %v", e.Code)
+}
+
+// Unwrap makes 408, 429 and 5xx responses ErrRetryLater.
+func (e *StatusError) Unwrap() error {
+	if e.Code == http.StatusRequestTimeout || e.Code == http.StatusTooManyRequests || e.Code >= 500 {
+		return ErrRetryLater
+	}
+	return nil
+}
+
+func (e *StatusError) class() string {
+	switch {
+	case e.Code == http.StatusTooManyRequests:
+		return ErrorClassRateLimited
+	case e.Code == http.StatusRequestTimeout:
+		return ErrorClassTimeout
+	case e.Code >= 500:
+		return ErrorClassServerError
+	}
+	return ErrorClassHTTPStatus
+}
+
+// RedirectError is a redirect the HTTP client did not follow. To is the
+// target it pointed at and Reason why it was not followed.
+type RedirectError struct {
+	To     string
+	Reason string
+}
+
+func (e *RedirectError) Error() string {
+	return fmt.Sprintf("redirect to %s not followed: %s", e.To, e.Reason)
+}
+
+func (e *RedirectError) Unwrap() error {
+	return ErrRedirectStopped
+}
+
+// RetryAfter returns the delay a server asked for before the request that
+// failed with err is tried again.
+func RetryAfter(err error) (time.Duration, bool) {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+		return statusErr.RetryAfter, true
+	}
+	return 0, false
+}
+
+// Retryable reports whether err is transient: the same request may
+// succeed if it is tried again later.
+func Retryable(err error) bool {
+	switch ClassifyError(err) {
+	case ErrorClassRetryLater, ErrorClassRateLimited, ErrorClassServerError,
+		ErrorClassTimeout, ErrorClassDNS, ErrorClassConnection, ErrorClassNetwork:
+		return true
+	}
+	return false
+}
+
+// ClassifyError maps an error to one of the ErrorClass constants so failed
+// tasks can be grouped and filtered, and retried by the policy of their
+// class.
+func ClassifyError(err error) string {
+	var netErr net.Error
+	var statusErr *StatusError
+	var dnsErr *net.DNSError
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &statusErr):
+		return statusErr.class()
+	case errors.As(err, &dnsErr):
+		return ErrorClassDNS
+	case errors.Is(err, ErrRetryLater):
+		return ErrorClassRetryLater
+	case errors.Is(err, ErrInvalidContentType):
+		return ErrorClassContentType
+	case errors.Is(err, ErrRedirectStopped):
+		return ErrorClassRedirect
+	case errors.Is(err, ErrUnknownTopic):
+		return ErrorClassUnknownTopic
+	case errors.Is(err, ErrTaskPanicked):
+		return ErrorClassPanic
+	case errors.Is(err, ErrRobotsDisallowed), errors.Is(err, ErrInvalidRobots):
+		return ErrorClassRobots
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrorClassTimeout
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.ECONNABORTED), errors.Is(err, io.ErrUnexpectedEOF):
+		return ErrorClassConnection
+	case errors.As(err, &netErr):
+		if netErr.Timeout() {
+			return ErrorClassTimeout
+		}
+		return ErrorClassNetwork
+	}
+	return ErrorClassUnknown
 }
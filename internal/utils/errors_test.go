@@ -0,0 +1,59 @@
+package utils_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/NesterovYehor/Crawler/internal/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		class     string
+		retryable bool
+	}{
+		{"rate limited", utils.ErrInvalidStatusCode(429), utils.ErrorClassRateLimited, true},
+		{"request timeout", utils.ErrInvalidStatusCode(408), utils.ErrorClassTimeout, true},
+		{"server error", fmt.Errorf("crawl failed: %w", utils.ErrInvalidStatusCode(502)), utils.ErrorClassServerError, true},
+		{"not found", utils.ErrInvalidStatusCode(404), utils.ErrorClassHTTPStatus, false},
+		{"dns", &net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "example.invalid", IsNotFound: true}}, utils.ErrorClassDNS, true},
+		{"reset", &net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, utils.ErrorClassConnection, true},
+		{"refused", &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, utils.ErrorClassConnection, true},
+		{"unexpected eof", fmt.Errorf("read body: %w", io.ErrUnexpectedEOF), utils.ErrorClassConnection, true},
+		{"deadline", fmt.Errorf("fetch: %w", context.DeadlineExceeded), utils.ErrorClassTimeout, true},
+		{"no proxy", fmt.Errorf("%w: %w", utils.ErrRetryLater, utils.ErrNoProxy), utils.ErrorClassRetryLater, true},
+		{"content type", utils.ErrInvalidContentType, utils.ErrorClassContentType, false},
+		{"redirect", fmt.Errorf("crawl failed: %w", &utils.RedirectError{To: "https://other.test/", Reason: "scope"}), utils.ErrorClassRedirect, false},
+		{"robots", fmt.Errorf("%w for domain: example.com", utils.ErrRobotsDisallowed), utils.ErrorClassRobots, false},
+		{"mentions robots", fmt.Errorf("robots.txt error"), utils.ErrorClassUnknown, false},
+		{"unknown", fmt.Errorf("boom"), utils.ErrorClassUnknown, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.class, utils.ClassifyError(tt.err))
+			assert.Equal(t, tt.retryable, utils.Retryable(tt.err))
+		})
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	err := fmt.Errorf("crawl failed: %w", &utils.StatusError{Code: 503, RetryAfter: time.Minute})
+	assert.ErrorIs(t, err, utils.ErrRetryLater)
+	d, ok := utils.RetryAfter(err)
+	assert.True(t, ok)
+	assert.Equal(t, time.Minute, d)
+
+	_, ok = utils.RetryAfter(utils.ErrInvalidStatusCode(503))
+	assert.False(t, ok)
+	assert.NotErrorIs(t, utils.ErrInvalidStatusCode(404), utils.ErrRetryLater)
+	assert.Equal(t, "invalid response status code: 404", utils.ErrInvalidStatusCode(404).Error())
+}
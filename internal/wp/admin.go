@@ -0,0 +1,38 @@
+package wp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// StatusHandler serves the status table of the running workers as JSON.
+func (wp *WorkerPool) StatusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(wp.Status()); err != nil {
+			slog.Warn("failed to write worker status", "error", err)
+		}
+	})
+}
+
+// serveAdmin serves the admin endpoint until ctx is done. It has no
+// authentication, which is why admin.port defaults to a loopback address.
+func (wp *WorkerPool) serveAdmin(ctx context.Context) {
+	mux := http.NewServeMux()
+	mux.Handle("GET /workers", wp.StatusHandler())
+	srv := &http.Server{Addr: wp.adminAddr, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("admin endpoint stopped", "addr", wp.adminAddr, "error", err)
+	}
+}
@@ -0,0 +1,197 @@
+package wp
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/NesterovYehor/Crawler/internal/config"
+)
+
+const (
+	defaultScaleInterval    = 10 * time.Second
+	defaultBacklogPerWorker = 50
+	defaultIdleThreshold    = 0.5
+	// bufferFullRatio is the buffer fill level at which a lane grows even
+	// when its backlog in the queue is small: tasks are already waiting here.
+	bufferFullRatio = 0.75
+	latencySamples  = 512
+)
+
+// laneLoad collects what the workers of a lane did since the last sample.
+type laneLoad struct {
+	idle atomic.Int64
+
+	mu        sync.Mutex
+	latencies []time.Duration
+	next      int
+}
+
+func (l *laneLoad) addIdle(d time.Duration) {
+	l.idle.Add(int64(d))
+}
+
+func (l *laneLoad) observe(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.latencies) < latencySamples {
+		l.latencies = append(l.latencies, d)
+		return
+	}
+	l.latencies[l.next] = d
+	l.next = (l.next + 1) % latencySamples
+}
+
+// sample returns the idle time and p95 task latency since the last sample
+// and starts a new window.
+func (l *laneLoad) sample() (time.Duration, time.Duration) {
+	idle := time.Duration(l.idle.Swap(0))
+
+	l.mu.Lock()
+	latencies := l.latencies
+	l.latencies, l.next = nil, 0
+	l.mu.Unlock()
+
+	if len(latencies) == 0 {
+		return idle, 0
+	}
+	slices.Sort(latencies)
+	return idle, latencies[(len(latencies)*95-1)/100]
+}
+
+// laneSample is what the autoscaler knows about a lane when it decides.
+type laneSample struct {
+	workers int
+	backlog int64
+	fill    float64
+	idle    float64
+	p95     time.Duration
+}
+
+type autoscaler struct {
+	interval         time.Duration
+	backlogPerWorker int64
+	idleThreshold    float64
+	maxLatency       time.Duration
+}
+
+func newAutoscaler(cfg config.Autoscale) *autoscaler {
+	a := &autoscaler{
+		interval:         cfg.Interval,
+		backlogPerWorker: cfg.BacklogPerWorker,
+		idleThreshold:    cfg.IdleThreshold,
+		maxLatency:       cfg.MaxLatency,
+	}
+	if a.interval <= 0 {
+		a.interval = defaultScaleInterval
+	}
+	if a.backlogPerWorker <= 0 {
+		a.backlogPerWorker = defaultBacklogPerWorker
+	}
+	if a.idleThreshold <= 0 {
+		a.idleThreshold = defaultIdleThreshold
+	}
+	return a
+}
+
+// laneBounds returns the worker bounds of a lane. A lane without bounds
+// keeps its configured worker count.
+func laneBounds(l config.Lane) (int, int) {
+	if l.MinWorkers == 0 && l.MaxWorkers == 0 {
+		return l.Workers, l.Workers
+	}
+	hi := l.MaxWorkers
+	if hi == 0 {
+		hi = max(l.Workers, l.MinWorkers)
+	}
+	return l.MinWorkers, hi
+}
+
+// decide returns the change in workers for a lane and the signal behind it.
+// Latency and idleness shrink a lane before backlog can grow it, so a slow
+// site or store is not hit harder. Each step changes the lane by a quarter,
+// at least one worker.
+func (a *autoscaler) decide(s laneSample, lo, hi int) (int, string) {
+	switch {
+	case s.workers < lo:
+		return lo - s.workers, "min"
+	case s.workers > hi:
+		return hi - s.workers, "max"
+	}
+
+	step := max(1, s.workers/4)
+	switch {
+	case a.maxLatency > 0 && s.p95 > a.maxLatency:
+		return -min(step, s.workers-lo), "latency"
+	case s.idle >= a.idleThreshold:
+		return -min(step, s.workers-lo), "idle"
+	case s.backlog > int64(s.workers)*a.backlogPerWorker:
+		return min(step, hi-s.workers), "backlog"
+	case s.fill >= bufferFullRatio:
+		return min(step, hi-s.workers), "buffer"
+	}
+	return 0, "steady"
+}
+
+// runAutoscaler resizes the lanes every interval until ctx is done.
+func (wp *WorkerPool) runAutoscaler(ctx, taskCtx context.Context) {
+	ticker := time.NewTicker(wp.scaler.interval)
+	defer ticker.Stop()
+	last := time.Now()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, lane := range wp.lanes {
+				wp.scaleLane(ctx, taskCtx, lane, now.Sub(last))
+			}
+			last = now
+		}
+	}
+}
+
+func (wp *WorkerPool) scaleLane(ctx, taskCtx context.Context, lane config.Lane, elapsed time.Duration) {
+	idle, p95 := wp.load[lane.Name].sample()
+	lo, hi := laneBounds(lane)
+	if lo == hi {
+		return
+	}
+
+	backlog, err := wp.queue.Len(ctx, lane.Name)
+	if err != nil {
+		slog.Warn("autoscaler failed to read queue length", "source", lane.Name, "error", err)
+		return
+	}
+	s := laneSample{
+		workers: wp.workerCount(lane.Name),
+		backlog: backlog,
+		p95:     p95,
+	}
+	if buf := wp.buffers[lane.Name]; cap(buf) > 0 {
+		s.fill = float64(len(buf)) / float64(cap(buf))
+	}
+	if s.workers > 0 && elapsed > 0 {
+		s.idle = float64(idle) / float64(elapsed*time.Duration(s.workers))
+	}
+
+	delta, reason := wp.scaler.decide(s, lo, hi)
+	decision := "hold"
+	switch {
+	case delta > 0:
+		decision = "up"
+		wp.spawnWorkers(delta, ctx, taskCtx, lane.Name)
+	case delta < 0:
+		decision = "down"
+		wp.retireWorkers(-delta, lane.Name)
+	}
+	if delta != 0 {
+		slog.Info("autoscaler resized lane", "source", lane.Name, "workers", s.workers+delta, "reason", reason,
+			"backlog", s.backlog, "fill", s.fill, "idle", s.idle, "p95", s.p95)
+	}
+	wp.metrics.Workers.ObserveScale(lane.Name, decision, reason, s.workers+delta)
+}
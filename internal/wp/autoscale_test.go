@@ -0,0 +1,148 @@
+package wp
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/NesterovYehor/Crawler/internal/config"
+	"github.com/NesterovYehor/Crawler/internal/models"
+	"github.com/NesterovYehor/Crawler/internal/queue"
+	"github.com/NesterovYehor/Crawler/internal/storage"
+	"github.com/NesterovYehor/Crawler/tests/testutils/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAutoscalerDecide(t *testing.T) {
+	a := newAutoscaler(config.Autoscale{BacklogPerWorker: 10, IdleThreshold: 0.5, MaxLatency: time.Second})
+
+	for name, tc := range map[string]struct {
+		sample laneSample
+		delta  int
+		reason string
+	}{
+		"below_min":         {laneSample{workers: 1}, 1, "min"},
+		"above_max":         {laneSample{workers: 10}, -2, "max"},
+		"backlog":           {laneSample{workers: 4, backlog: 100}, 1, "backlog"},
+		"backlog_at_max":    {laneSample{workers: 8, backlog: 1000}, 0, "backlog"},
+		"full_buffer":       {laneSample{workers: 4, fill: 0.8}, 1, "buffer"},
+		"idle":              {laneSample{workers: 8, idle: 0.9}, -2, "idle"},
+		"idle_at_min":       {laneSample{workers: 2, idle: 0.9}, 0, "idle"},
+		"slow_with_backlog": {laneSample{workers: 4, backlog: 100, p95: 2 * time.Second}, -1, "latency"},
+		"steady":            {laneSample{workers: 4, backlog: 20, idle: 0.1}, 0, "steady"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			delta, reason := a.decide(tc.sample, 2, 8)
+			assert.Equal(t, tc.delta, delta)
+			assert.Equal(t, tc.reason, reason)
+		})
+	}
+}
+
+func TestLaneLoadSample(t *testing.T) {
+	l := &laneLoad{}
+	for i := 1; i <= 100; i++ {
+		l.observe(time.Duration(i) * time.Millisecond)
+	}
+	l.addIdle(time.Second)
+
+	idle, p95 := l.sample()
+	assert.Equal(t, time.Second, idle)
+	assert.Equal(t, 95*time.Millisecond, p95)
+
+	idle, p95 = l.sample()
+	assert.Zero(t, idle, "a sample starts a new window")
+	assert.Zero(t, p95)
+}
+
+// timedStore takes a fixed time for every store task.
+type timedStore struct {
+	storage.Interface
+	delay time.Duration
+}
+
+func (s *timedStore) GetTempByUUID(ctx context.Context, id string) (*models.PageDataModel, error) {
+	time.Sleep(s.delay)
+	return &models.PageDataModel{}, nil
+}
+
+func (s *timedStore) SaveIfNew(ctx context.Context, data *models.PageDataModel) error {
+	return nil
+}
+
+func TestWorkerPoolAutoscales(t *testing.T) {
+	q := queue.NewMemoryQueue(&config.Queue{})
+	tasks := make([]*models.Task, 400)
+	for i := range tasks {
+		tasks[i] = models.NewTask(storeDataTask, fmt.Sprintf("https://example.com/%d", i), queue.StoreQueue, fmt.Sprint(i))
+	}
+	require.NoError(t, q.Add(tasks))
+
+	pool, err := NewWorkerPool(&WorkerPoolOpts{
+		Config: &config.Workers{Autoscale: config.Autoscale{
+			Enabled:          true,
+			Interval:         50 * time.Millisecond,
+			BacklogPerWorker: 10,
+		}},
+		Queue:   q,
+		ST:      &timedStore{delay: 5 * time.Millisecond},
+		Metrics: mocks.NewNoopMetrics(),
+		Lanes: []config.Lane{
+			{Name: queue.HighPriorityQueue},
+			{Name: queue.MediumPriorityQueue},
+			{Name: queue.StoreQueue, Workers: 1, MinWorkers: 1, MaxWorkers: 4, Weight: 1},
+			{Name: queue.RetryPriorityQueue},
+		},
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		pool.Run(ctx)
+		close(stopped)
+	}()
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+
+	assert.Eventually(t, func() bool {
+		return pool.workerCount(queue.StoreQueue) == 4
+	}, 5*time.Second, 10*time.Millisecond, "a backlog should grow the lane to max_workers")
+	assert.Eventually(t, func() bool {
+		return pool.workerCount(queue.StoreQueue) == 1
+	}, 10*time.Second, 10*time.Millisecond, "idle workers should shrink the lane to min_workers")
+}
+
+func TestNewWorkerPoolLaneBounds(t *testing.T) {
+	lanes := []config.Lane{
+		{Name: queue.HighPriorityQueue, Workers: 2, MaxWorkers: 8},
+		{Name: queue.MediumPriorityQueue},
+		{Name: queue.StoreQueue, Workers: 1, MinWorkers: 3, MaxWorkers: 4},
+		{Name: queue.RetryPriorityQueue},
+	}
+	declared := slices.Clone(lanes)
+	newPool := func(autoscale bool) *WorkerPool {
+		pool, err := NewWorkerPool(&WorkerPoolOpts{
+			Config:  &config.Workers{Autoscale: config.Autoscale{Enabled: autoscale}},
+			Queue:   queue.NewMemoryQueue(&config.Queue{}),
+			Metrics: mocks.NewNoopMetrics(),
+			Lanes:   lanes,
+		})
+		require.NoError(t, err)
+		return pool
+	}
+
+	pool := newPool(true)
+	assert.Equal(t, declared, lanes, "the declared lanes are left as they are")
+	assert.Equal(t, 3, pool.lanes[2].Workers, "the pool starts at min_workers")
+	assert.Equal(t, 16, cap(pool.buffers[queue.HighPriorityQueue]), "room for the most workers the lane may have")
+
+	pool = newPool(false)
+	assert.Equal(t, 1, pool.lanes[2].Workers)
+	assert.Equal(t, 4, cap(pool.buffers[queue.HighPriorityQueue]), "without autoscaling the lane keeps its workers")
+}
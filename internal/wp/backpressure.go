@@ -0,0 +1,297 @@
+package wp
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/NesterovYehor/Crawler/internal/config"
+	"github.com/NesterovYehor/Crawler/internal/metrics"
+	"github.com/NesterovYehor/Crawler/internal/models"
+	"github.com/NesterovYehor/Crawler/internal/queue"
+)
+
+const (
+	BackpressureSpill = "spill"
+	BackpressureDrop  = "drop"
+	BackpressureBlock = "block"
+
+	defaultHighWatermark = 0.9
+	defaultLowWatermark  = 0.7
+	defaultBlockTimeout  = 30 * time.Second
+
+	backpressureCheckInterval = 500 * time.Millisecond
+	// maxHeldTasks bounds the tasks kept in memory by the block policy. Once
+	// it is reached handleAdd stops reading addChan and discovery blocks for
+	// up to the block timeout; links held beyond it are spilled.
+	maxHeldTasks = 10000
+)
+
+// defaultSpillDir is where shed links are spilled when no spill_dir is
+// configured.
+var defaultSpillDir = filepath.Join(os.TempDir(), "crawler", "spill")
+
+// backpressure keeps capped lanes below their max_len. Lengths are sampled
+// periodically; a lane becomes saturated at the high watermark and is
+// released again below the low watermark. While a lane is saturated the
+// discovered links routed to it are spilled, dropped or held according to
+// the policy. Other topics are never shed.
+//
+// The drop policy still fills what is left of a saturated lane's max_len,
+// with the links of the highest priority and lowest depth first. The block
+// policy holds links until their lane is no longer saturated and spills
+// those that do not fit into maxHeldTasks.
+type backpressure struct {
+	policy       string
+	high, low    float64
+	blockTimeout time.Duration
+
+	queue   queue.Interface
+	metrics metrics.QueueMetrics
+	spill   *spillStore
+
+	limits       map[string]int64
+	lengths      map[string]int64
+	added        map[string]int64
+	saturated    map[string]bool
+	checkedAt    time.Time
+	held         map[string][]*models.Task
+	heldCount    int
+	blockedSince time.Time
+}
+
+func newBackpressure(cfg *config.Backpressure, lanes []config.Lane, q queue.Interface, m metrics.QueueMetrics) (*backpressure, error) {
+	b := &backpressure{
+		queue:     q,
+		metrics:   m,
+		limits:    make(map[string]int64),
+		lengths:   make(map[string]int64),
+		added:     make(map[string]int64),
+		saturated: make(map[string]bool),
+		held:      make(map[string][]*models.Task),
+	}
+	for _, lane := range lanes {
+		if lane.MaxLen > 0 {
+			b.limits[lane.Name] = lane.MaxLen
+		}
+	}
+
+	var c config.Backpressure
+	if cfg != nil {
+		c = *cfg
+	}
+	b.policy = c.Policy
+	if b.policy == "" {
+		b.policy = BackpressureSpill
+	}
+	b.high, b.low = c.HighWatermark, c.LowWatermark
+	if b.high <= 0 {
+		b.high = defaultHighWatermark
+	}
+	if b.low <= 0 || b.low > b.high {
+		b.low = min(defaultLowWatermark, b.high)
+	}
+	b.blockTimeout = c.BlockTimeout
+	if b.blockTimeout <= 0 {
+		b.blockTimeout = defaultBlockTimeout
+	}
+
+	switch b.policy {
+	case BackpressureSpill, BackpressureBlock:
+		if len(b.limits) == 0 {
+			break
+		}
+		dir := c.SpillDir
+		if dir == "" {
+			dir = defaultSpillDir
+		}
+		// A relative directory would move with the working directory of
+		// the process and spilled links would be lost on restart.
+		if !filepath.IsAbs(dir) {
+			return nil, fmt.Errorf("spill_dir %q is not an absolute path", dir)
+		}
+		spill, err := newSpillStore(dir)
+		if err != nil {
+			return nil, err
+		}
+		b.spill = spill
+	case BackpressureDrop:
+	default:
+		return nil, fmt.Errorf("unknown backpressure policy %q", b.policy)
+	}
+	return b, nil
+}
+
+// refresh samples the length of every capped lane. It returns false when
+// the previous sample is still recent enough to be used.
+func (b *backpressure) refresh(ctx context.Context) bool {
+	if len(b.limits) == 0 || time.Since(b.checkedAt) < backpressureCheckInterval {
+		return false
+	}
+	b.checkedAt = time.Now()
+
+	for lane, limit := range b.limits {
+		n, err := b.queue.Len(ctx, lane)
+		if err != nil {
+			slog.Warn("failed to read queue length", "source", lane, "error", err)
+			continue
+		}
+		b.lengths[lane], b.added[lane] = n, 0
+		level := float64(n) / float64(limit)
+		was := b.saturated[lane]
+		if was {
+			b.saturated[lane] = level >= b.low
+		} else {
+			b.saturated[lane] = level >= b.high
+		}
+		if b.saturated[lane] != was {
+			slog.Info("queue backpressure changed", "source", lane, "saturated", b.saturated[lane], "length", n, "max_len", limit)
+		}
+		b.metrics.ObserveBackpressure(lane, level, b.saturated[lane])
+	}
+	return true
+}
+
+// room returns how many more tasks fit into lane before it reaches its
+// max_len, going by the last sample and the tasks added since.
+func (b *backpressure) room(lane string) int {
+	return int(max(b.limits[lane]-b.lengths[lane]-b.added[lane], 0))
+}
+
+// admit returns the tasks that may be added right away and applies the
+// policy to discovered links bound for saturated lanes.
+func (b *backpressure) admit(tasks []*models.Task) []*models.Task {
+	if len(b.limits) == 0 {
+		return tasks
+	}
+
+	out := tasks[:0:0]
+	shed := make(map[string][]*models.Task)
+	for _, t := range tasks {
+		if t.Topic != processPageTask || !b.saturated[t.SourceName] {
+			out = append(out, t)
+			continue
+		}
+		shed[t.SourceName] = append(shed[t.SourceName], t)
+	}
+
+	for lane, lt := range shed {
+		switch b.policy {
+		case BackpressureDrop:
+			rankLinks(lt)
+			keep := min(b.room(lane), len(lt))
+			out = append(out, lt[:keep]...)
+			if dropped := len(lt) - keep; dropped > 0 {
+				b.metrics.ObserveShed(lane, "dropped", dropped)
+			}
+		case BackpressureSpill:
+			out = append(out, b.spillTasks(lane, lt)...)
+		case BackpressureBlock:
+			if b.heldCount == 0 {
+				b.blockedSince = time.Now()
+			}
+			hold := min(maxHeldTasks-b.heldCount, len(lt))
+			b.held[lane] = append(b.held[lane], lt[:hold]...)
+			b.heldCount += hold
+			if hold > 0 {
+				b.metrics.ObserveShed(lane, "held", hold)
+			}
+			out = append(out, b.spillTasks(lane, lt[hold:])...)
+		}
+	}
+	for _, t := range out {
+		if _, ok := b.limits[t.SourceName]; ok {
+			b.added[t.SourceName]++
+		}
+	}
+	return out
+}
+
+// spillTasks writes tasks to the spill store. It returns them when they
+// could not be written, so they are added anyway.
+func (b *backpressure) spillTasks(lane string, tasks []*models.Task) []*models.Task {
+	if len(tasks) == 0 {
+		return nil
+	}
+	if err := b.spill.write(lane, tasks); err != nil {
+		slog.Error("failed to spill tasks, adding them anyway", "source", lane, "error", err)
+		return tasks
+	}
+	b.metrics.ObserveShed(lane, "spilled", len(tasks))
+	return nil
+}
+
+// rankLinks orders links by priority, highest first, and then by depth,
+// shallowest first.
+func rankLinks(tasks []*models.Task) {
+	slices.SortStableFunc(tasks, func(a, b *models.Task) int {
+		return cmp.Or(cmp.Compare(b.Priority, a.Priority), cmp.Compare(a.Depth, b.Depth))
+	})
+}
+
+// blocking reports whether handleAdd should stop reading new tasks.
+func (b *backpressure) blocking() bool {
+	return b.heldCount >= maxHeldTasks && time.Since(b.blockedSince) < b.blockTimeout
+}
+
+// release returns shed tasks of lanes that are no longer saturated: as
+// many held tasks as fit into the lane and one spill segment per lane.
+// Held tasks of a saturated lane stay held however long they waited.
+func (b *backpressure) release() []*models.Task {
+	var out []*models.Task
+	for lane, held := range b.held {
+		if b.saturated[lane] {
+			continue
+		}
+		n := min(b.room(lane), len(held))
+		if n == 0 {
+			continue
+		}
+		out = append(out, held[:n]...)
+		b.added[lane] += int64(n)
+		b.heldCount -= n
+		if n == len(held) {
+			delete(b.held, lane)
+		} else {
+			b.held[lane] = held[n:]
+		}
+		b.metrics.ObserveShed(lane, "released", n)
+	}
+
+	if b.spill == nil {
+		return out
+	}
+	for lane := range b.limits {
+		if b.saturated[lane] {
+			continue
+		}
+		tasks, err := b.spill.read(lane)
+		if err != nil {
+			slog.Error("failed to restore spilled tasks", "source", lane, "error", err)
+			continue
+		}
+		if len(tasks) > 0 {
+			out = append(out, tasks...)
+			b.metrics.ObserveShed(lane, "restored", len(tasks))
+		}
+	}
+	return out
+}
+
+// drain returns every held task, saturated lane or not. Spilled tasks stay
+// on disk and are restored by the next run.
+func (b *backpressure) drain() []*models.Task {
+	var out []*models.Task
+	for lane, held := range b.held {
+		out = append(out, held...)
+		delete(b.held, lane)
+		b.metrics.ObserveShed(lane, "released", len(held))
+	}
+	b.heldCount = 0
+	return out
+}
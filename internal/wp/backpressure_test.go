@@ -0,0 +1,134 @@
+package wp
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/NesterovYehor/Crawler/internal/config"
+	"github.com/NesterovYehor/Crawler/internal/models"
+	"github.com/NesterovYehor/Crawler/internal/queue"
+	"github.com/NesterovYehor/Crawler/tests/testutils/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func fillLane(t *testing.T, q queue.Interface, lane string, n int) {
+	tasks := make([]*models.Task, n)
+	for i := range tasks {
+		tasks[i] = models.NewTask(processPageTask, fmt.Sprintf("https://example.com/%d", i), lane, "")
+	}
+	require.NoError(t, q.Add(tasks))
+}
+
+func TestBackpressurePolicies(t *testing.T) {
+	lanes := []config.Lane{{Name: queue.HighPriorityQueue, MaxLen: 10}}
+	incoming := []*models.Task{
+		models.NewTask(processPageTask, "https://new.com/", queue.HighPriorityQueue, ""),
+		models.NewTask(storeDataTask, "https://new.com/", queue.StoreQueue, "data"),
+	}
+
+	for _, policy := range []string{BackpressureDrop, BackpressureSpill, BackpressureBlock} {
+		t.Run(policy, func(t *testing.T) {
+			ctx := context.Background()
+			q := queue.NewMemoryQueue(&config.Queue{})
+			fillLane(t, q, queue.HighPriorityQueue, 10)
+
+			bp, err := newBackpressure(&config.Backpressure{
+				Policy:        policy,
+				HighWatermark: 0.9,
+				LowWatermark:  0.5,
+				SpillDir:      t.TempDir(),
+			}, lanes, q, mocks.NewNoopMetrics().Queue)
+			require.NoError(t, err)
+
+			require.True(t, bp.refresh(ctx))
+			require.True(t, bp.saturated[queue.HighPriorityQueue])
+
+			admitted := bp.admit(incoming)
+			require.Len(t, admitted, 1)
+			assert.Equal(t, storeDataTask, admitted[0].Topic, "only discovered links are shed")
+			assert.Empty(t, bp.release(), "nothing is released while the lane is saturated")
+
+			// Draining the lane below the low watermark lifts the backpressure.
+			got, err := q.GetTasks(ctx, 6, queue.HighPriorityQueue)
+			require.NoError(t, err)
+			require.NoError(t, q.Del(got))
+			bp.checkedAt = time.Time{}
+			require.True(t, bp.refresh(ctx))
+			require.False(t, bp.saturated[queue.HighPriorityQueue])
+
+			released := bp.release()
+			if policy == BackpressureDrop {
+				assert.Empty(t, released)
+				return
+			}
+			require.Len(t, released, 1)
+			assert.Equal(t, "https://new.com/", released[0].URL)
+			assert.Equal(t, queue.HighPriorityQueue, released[0].SourceName)
+			assert.Empty(t, bp.release())
+		})
+	}
+}
+
+func TestBackpressureDropKeepsTopRankedLinks(t *testing.T) {
+	ctx := context.Background()
+	q := queue.NewMemoryQueue(&config.Queue{})
+	fillLane(t, q, queue.HighPriorityQueue, 9)
+	bp, err := newBackpressure(&config.Backpressure{Policy: BackpressureDrop},
+		[]config.Lane{{Name: queue.HighPriorityQueue, MaxLen: 10}}, q, mocks.NewNoopMetrics().Queue)
+	require.NoError(t, err)
+	require.True(t, bp.refresh(ctx))
+
+	deep := models.NewTask(processPageTask, "https://new.com/deep", queue.HighPriorityQueue, "")
+	deep.Depth = 3
+	shallow := models.NewTask(processPageTask, "https://new.com/shallow", queue.HighPriorityQueue, "")
+	shallow.Depth = 1
+	listed := models.NewTask(processPageTask, "https://new.com/listed", queue.HighPriorityQueue, "")
+	listed.Depth, listed.Priority = 3, 0.8
+
+	admitted := bp.admit([]*models.Task{deep, shallow, listed})
+	require.Len(t, admitted, 1, "only the room left in the lane is filled")
+	assert.Equal(t, "https://new.com/listed", admitted[0].URL)
+	admitted = bp.admit([]*models.Task{deep, shallow})
+	assert.Empty(t, admitted, "the lane is full until the next sample")
+}
+
+func TestBackpressureBlockSpillsBeyondHeldLimit(t *testing.T) {
+	ctx := context.Background()
+	q := queue.NewMemoryQueue(&config.Queue{})
+	fillLane(t, q, queue.HighPriorityQueue, 10)
+	bp, err := newBackpressure(&config.Backpressure{Policy: BackpressureBlock, SpillDir: t.TempDir(), BlockTimeout: time.Millisecond},
+		[]config.Lane{{Name: queue.HighPriorityQueue, MaxLen: 10}}, q, mocks.NewNoopMetrics().Queue)
+	require.NoError(t, err)
+	require.True(t, bp.refresh(ctx))
+
+	links := make([]*models.Task, maxHeldTasks+5)
+	for i := range links {
+		links[i] = models.NewTask(processPageTask, fmt.Sprintf("https://new.com/%d", i), queue.HighPriorityQueue, "")
+	}
+	assert.Empty(t, bp.admit(links))
+	assert.Equal(t, maxHeldTasks, bp.heldCount)
+	spilled, err := bp.spill.read(queue.HighPriorityQueue)
+	require.NoError(t, err)
+	assert.Len(t, spilled, 5)
+
+	time.Sleep(5 * time.Millisecond)
+	assert.Empty(t, bp.release(), "held links wait for the lane even after the block timeout")
+}
+
+func TestBackpressureSpillDir(t *testing.T) {
+	lanes := []config.Lane{{Name: queue.HighPriorityQueue, MaxLen: 10}}
+	_, err := newBackpressure(&config.Backpressure{SpillDir: "spill"}, lanes, nil, nil)
+	assert.ErrorContains(t, err, "not an absolute path")
+
+	bp, err := newBackpressure(&config.Backpressure{}, lanes, nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, defaultSpillDir, bp.spill.dir)
+}
+
+func TestBackpressureRejectsUnknownPolicy(t *testing.T) {
+	_, err := newBackpressure(&config.Backpressure{Policy: "shuffle"}, nil, nil, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,97 @@
+package wp
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	httpclient "github.com/NesterovYehor/Crawler/internal/http_client"
+	"github.com/NesterovYehor/Crawler/internal/models"
+	"github.com/NesterovYehor/Crawler/internal/utils"
+)
+
+// conditionalClient makes the fetch of a re-crawled page conditional. It
+// wraps the pool's client for one crawl: fetches of the page itself send
+// the validators stored with its metadata, and the response is kept so its
+// validators, truncation, charset and redirects end up in the new
+// metadata. Other fetches pass through.
+type conditionalClient struct {
+	httpclient.Interface
+	url  string
+	prev httpclient.Validators
+
+	mu          sync.Mutex
+	res         *httpclient.FetchResult
+	notModified bool
+	redirect    *utils.RedirectError
+}
+
+func newConditionalClient(client httpclient.Interface, url string, prev *models.Metadata) *conditionalClient {
+	c := &conditionalClient{Interface: client, url: url}
+	if prev != nil {
+		c.prev = httpclient.Validators{ETag: prev.ETag, LastModified: prev.LastModified}
+	}
+	return c
+}
+
+func (c *conditionalClient) Fetch(ctx context.Context, rawURL string) (*httpclient.FetchResult, error) {
+	if rawURL != c.url {
+		return c.Interface.Fetch(ctx, rawURL)
+	}
+	res, err := c.Interface.FetchIfModified(httpclient.QueueRedirects(ctx), rawURL, c.prev)
+	if res != nil {
+		c.mu.Lock()
+		c.res = res
+		c.notModified = res.StatusCode == http.StatusNotModified || errors.Is(err, utils.ErrNotModified)
+		errors.As(err, &c.redirect)
+		c.mu.Unlock()
+	}
+	return res, err
+}
+
+// queuedRedirect returns the target of the page's redirect if the client
+// handed it back to be queued instead of following it.
+func (c *conditionalClient) queuedRedirect() (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.redirect == nil || c.redirect.Reason != httpclient.RedirectQueued {
+		return "", false
+	}
+	return c.redirect.To, true
+}
+
+// unchanged reports whether the server answered the page's fetch with 304.
+func (c *conditionalClient) unchanged() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.notModified
+}
+
+// annotate copies the validators, truncation, charset and redirects of the
+// page's response to its metadata.
+func (c *conditionalClient) annotate(m *models.Metadata) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.res == nil {
+		return
+	}
+	m.ETag, m.LastModified = c.res.ETag, c.res.LastModified
+	m.Truncated = m.Truncated || c.res.Truncated()
+	if c.res.Charset != "" {
+		m.Charset = c.res.Charset
+	}
+	m.FinalURL, m.Redirects = c.res.FinalURL, c.res.Redirects
+}
+
+// previousMetadata returns what was stored for the task's page by an
+// earlier crawl. A failed lookup only costs the conditional request.
+func previousMetadata(ctx context.Context, tc *TaskContext, task *models.Task) *models.Metadata {
+	prev, err := tc.Storage.GetMetadata(ctx, task.URL)
+	if err != nil {
+		slog.Warn("failed to load previous metadata", "url", task.URL, "error", err)
+		return nil
+	}
+	return prev
+}
@@ -0,0 +1,108 @@
+package wp
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	httpclient "github.com/NesterovYehor/Crawler/internal/http_client"
+	"github.com/NesterovYehor/Crawler/internal/models"
+	"github.com/NesterovYehor/Crawler/internal/utils"
+	"github.com/NesterovYehor/Crawler/tests/testutils/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConditionalClient(t *testing.T) {
+	const page = "https://example.com/page"
+	var sent []httpclient.Validators
+	inner := &mocks.MockHTTPClient{
+		FetchFn: func(ctx context.Context, rawURL string) (*httpclient.FetchResult, error) {
+			return mocks.HTMLResult(rawURL, "<html></html>"), nil
+		},
+		FetchIfModifiedFn: func(ctx context.Context, rawURL string, v httpclient.Validators) (*httpclient.FetchResult, error) {
+			sent = append(sent, v)
+			if v.ETag == `"v1"` {
+				return &httpclient.FetchResult{URL: rawURL, StatusCode: http.StatusNotModified}, utils.ErrNotModified
+			}
+			res := mocks.HTMLResult(rawURL, "<html></html>")
+			res.ETag = `"v1"`
+			return res, nil
+		},
+	}
+	ctx := context.Background()
+
+	t.Run("first_crawl", func(t *testing.T) {
+		c := newConditionalClient(inner, page, nil)
+		_, err := c.Fetch(ctx, page)
+		require.NoError(t, err)
+		assert.False(t, c.unchanged())
+		var m models.Metadata
+		c.annotate(&m)
+		assert.Equal(t, `"v1"`, m.ETag)
+	})
+
+	t.Run("recrawl", func(t *testing.T) {
+		sent = nil
+		c := newConditionalClient(inner, page, &models.Metadata{ETag: `"v1"`, LastModified: "yesterday"})
+		_, err := c.Fetch(ctx, "https://example.com/other")
+		require.NoError(t, err)
+		assert.Empty(t, sent, "other fetches are not conditional")
+		assert.False(t, c.unchanged())
+
+		_, err = c.Fetch(ctx, page)
+		require.ErrorIs(t, err, utils.ErrNotModified)
+		assert.Equal(t, []httpclient.Validators{{ETag: `"v1"`, LastModified: "yesterday"}}, sent)
+		assert.True(t, c.unchanged())
+	})
+	t.Run("redirect", func(t *testing.T) {
+		redirecting := &mocks.MockHTTPClient{
+			FetchIfModifiedFn: func(ctx context.Context, rawURL string, v httpclient.Validators) (*httpclient.FetchResult, error) {
+				res := &httpclient.FetchResult{URL: rawURL, FinalURL: rawURL, StatusCode: http.StatusFound, RedirectTo: "https://example.com/new"}
+				return res, &utils.RedirectError{To: res.RedirectTo, Reason: httpclient.RedirectQueued}
+			},
+		}
+		c := newConditionalClient(redirecting, page, nil)
+		_, err := c.Fetch(ctx, page)
+		require.ErrorIs(t, err, utils.ErrRedirectStopped)
+		to, ok := c.queuedRedirect()
+		assert.True(t, ok)
+		assert.Equal(t, "https://example.com/new", to)
+
+		c = newConditionalClient(inner, page, nil)
+		_, err = c.Fetch(ctx, page)
+		require.NoError(t, err)
+		_, ok = c.queuedRedirect()
+		assert.False(t, ok)
+	})
+
+	t.Run("recrawl_of_redirect_target", func(t *testing.T) {
+		const moved, final = "https://example.com/moved", "https://example.com/final"
+		followed := &mocks.MockHTTPClient{
+			FetchIfModifiedFn: func(ctx context.Context, rawURL string, v httpclient.Validators) (*httpclient.FetchResult, error) {
+				res := mocks.HTMLResult(rawURL, "<html></html>")
+				res.FinalURL, res.Redirects, res.ETag = final, []string{rawURL}, `"v1"`
+				return res, nil
+			},
+		}
+		c := newConditionalClient(followed, moved, nil)
+		_, err := c.Fetch(ctx, moved)
+		require.NoError(t, err)
+		m := models.Metadata{URL: moved}
+		c.annotate(&m)
+
+		atFinal, ok := m.AtFinalURL()
+		require.True(t, ok)
+		assert.Equal(t, final, atFinal.URL)
+		assert.Empty(t, atFinal.Redirects)
+
+		// The final URL, crawled as a page of its own, is fetched with the
+		// validators of the response it served.
+		sent = nil
+		c = newConditionalClient(inner, final, &atFinal)
+		_, err = c.Fetch(ctx, final)
+		require.ErrorIs(t, err, utils.ErrNotModified)
+		assert.Equal(t, []httpclient.Validators{{ETag: `"v1"`}}, sent)
+		assert.True(t, c.unchanged())
+	})
+}
@@ -0,0 +1,94 @@
+package wp
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	httpclient "github.com/NesterovYehor/Crawler/internal/http_client"
+	"github.com/NesterovYehor/Crawler/internal/metrics"
+	"github.com/NesterovYehor/Crawler/internal/models"
+	"github.com/NesterovYehor/Crawler/internal/politeness"
+	"github.com/NesterovYehor/Crawler/internal/storage"
+)
+
+// Handler processes the tasks of one topic. The task is acknowledged once
+// Handle returns, whether it failed or not; a handler that wants another
+// attempt calls TaskContext.Retry. Only a task cut off by shutdown is put
+// back as it was.
+type Handler interface {
+	Handle(ctx context.Context, tc *TaskContext, task *models.Task) error
+}
+
+// HandlerFunc adapts a function to the Handler interface.
+type HandlerFunc func(ctx context.Context, tc *TaskContext, task *models.Task) error
+
+func (f HandlerFunc) Handle(ctx context.Context, tc *TaskContext, task *models.Task) error {
+	return f(ctx, tc, task)
+}
+
+// TaskContext is shared by the handlers a worker runs. It exposes the
+// pool's dependencies and the ways to queue more work.
+type TaskContext struct {
+	Storage    storage.Interface
+	Politeness *politeness.PolitenessManager
+	HTTPClient httpclient.Interface
+	Metrics    *metrics.Metrics
+
+	worker *Worker
+}
+
+func newTaskContext(w *Worker) *TaskContext {
+	return &TaskContext{
+		Storage:    w.pool.st,
+		Politeness: w.pool.pm,
+		HTTPClient: w.pool.httpClient,
+		Metrics:    w.metrics,
+		worker:     w,
+	}
+}
+
+// Enqueue adds tasks to the queue in the next batch. Pages that were
+// already crawled are left out.
+func (tc *TaskContext) Enqueue(tasks ...*models.Task) {
+	if len(tasks) > 0 {
+		tc.worker.pool.enqueue(tasks)
+	}
+}
+
+// Retry records err as a failed attempt and schedules the task again by
+// the retry policy of its topic and error class. A task out of attempts is
+// dead-lettered instead.
+func (tc *TaskContext) Retry(task *models.Task, err error) {
+	task.RecordAttempt(err)
+	d := tc.worker.pool.retryPolicy.Schedule(task, err)
+	tc.Metrics.Workers.ObserveRetry(task.Topic, d.Class, d.Trigger)
+	slog.Debug("retry scheduled", "url", task.URL, "topic", task.Topic, "class", d.Class,
+		"trigger", d.Trigger, "delay", d.Delay, "attempt", d.Attempt, "max_attempts", d.MaxAttempts)
+	tc.worker.pool.scheduleRetry(task)
+}
+
+// AdmitLinks returns the links discovered from parent that are within its
+// seed's max depth and its job's scope and page budget. crawled counts
+// parent as a fetched page against the budget.
+func (tc *TaskContext) AdmitLinks(ctx context.Context, parent *models.Task, links []*models.Task, crawled bool) []*models.Task {
+	return tc.worker.admitLinks(ctx, parent, links, crawled)
+}
+
+func builtinHandlers() map[string]Handler {
+	return map[string]Handler{
+		processRulesTask: HandlerFunc(handleRules),
+		processPageTask:  HandlerFunc(handlePage),
+		storeDataTask:    HandlerFunc(handleStore),
+	}
+}
+
+// Register sets the handler of a topic. Registering a built-in topic
+// replaces its handler. It must be called before Run.
+func (wp *WorkerPool) Register(topic string, h Handler) error {
+	if topic == "" || h == nil {
+		return fmt.Errorf("invalid handler for topic %q", topic)
+	}
+	wp.handlers[topic] = h
+	return nil
+}
@@ -0,0 +1,69 @@
+package wp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/NesterovYehor/Crawler/internal/config"
+	"github.com/NesterovYehor/Crawler/internal/models"
+	"github.com/NesterovYehor/Crawler/internal/queue"
+	"github.com/NesterovYehor/Crawler/internal/utils"
+	"github.com/NesterovYehor/Crawler/tests/testutils/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorkerPoolHandlers(t *testing.T) {
+	q := queue.NewMemoryQueue(&config.Queue{})
+	require.NoError(t, q.Add([]*models.Task{
+		models.NewTask("render_pdf", "https://example.com/a.pdf", queue.StoreQueue, ""),
+		models.NewTask("extract_entities", "https://example.com/b", queue.StoreQueue, ""),
+	}))
+
+	rendered := make(chan string, 1)
+	pool, err := NewWorkerPool(&WorkerPoolOpts{
+		Config:  &config.Workers{},
+		Queue:   q,
+		Metrics: mocks.NewNoopMetrics(),
+		Lanes: []config.Lane{
+			{Name: queue.HighPriorityQueue},
+			{Name: queue.MediumPriorityQueue},
+			{Name: queue.StoreQueue, Workers: 1, Weight: 1},
+			{Name: queue.RetryPriorityQueue},
+		},
+		Handlers: map[string]Handler{
+			"render_pdf": HandlerFunc(func(ctx context.Context, tc *TaskContext, task *models.Task) error {
+				rendered <- task.URL
+				return nil
+			}),
+		},
+	})
+	require.NoError(t, err)
+	assert.Error(t, pool.Register("", HandlerFunc(nil)), "a topic is required")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		pool.Run(ctx)
+		close(stopped)
+	}()
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+
+	select {
+	case url := <-rendered:
+		assert.Equal(t, "https://example.com/a.pdf", url)
+	case <-time.After(5 * time.Second):
+		t.Fatal("custom handler was not called")
+	}
+
+	var dead []*models.DeadLetter
+	require.Eventually(t, func() bool {
+		dead, err = q.ListDeadLetters(context.Background(), queue.DeadLetterFilter{ErrorClass: utils.ErrorClassUnknownTopic})
+		return err == nil && len(dead) == 1
+	}, 5*time.Second, 10*time.Millisecond, "unknown topics should be dead-lettered")
+	assert.Equal(t, "extract_entities", dead[0].Task.Topic)
+}
@@ -0,0 +1,110 @@
+package wp
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	httpclient "github.com/NesterovYehor/Crawler/internal/http_client"
+	"github.com/NesterovYehor/Crawler/internal/identity"
+	"github.com/NesterovYehor/Crawler/internal/jobs"
+	"github.com/NesterovYehor/Crawler/internal/models"
+	"github.com/NesterovYehor/Crawler/internal/profiles"
+	"github.com/NesterovYehor/Crawler/internal/proxy"
+)
+
+// job returns the job a task belongs to, or nil for tasks outside of any
+// job. Lookup errors are logged and treated as no job, so a store outage
+// does not stop the crawl.
+func (w *Worker) job(ctx context.Context, task *models.Task) *models.Job {
+	if task.JobID == "" || w.pool.jobs == nil {
+		return nil
+	}
+	job, err := w.pool.jobs.Job(ctx, task.JobID)
+	if err != nil {
+		if !errors.Is(err, jobs.ErrJobNotFound) {
+			slog.Error("failed to look up job", "job", task.JobID, "error", err)
+		}
+		return nil
+	}
+	return job
+}
+
+// requestContext returns ctx carrying the identity and proxy strategy of
+// the task's requests: the pool's, with the overrides of the task's job.
+// Requests of one job share a cookie jar and follow no redirects out of
+// its scope.
+func (w *Worker) requestContext(ctx context.Context, task *models.Task) context.Context {
+	ctx = profiles.WithJob(ctx, task.JobID)
+	id := w.pool.identity
+	if job := w.job(ctx, task); job != nil {
+		id = id.Override(job.Identity)
+		ctx = httpclient.WithScope(ctx, job.InScope)
+		if job.ProxyStrategy != "" {
+			ctx = proxy.WithStrategy(ctx, job.ProxyStrategy)
+		}
+	}
+	return identity.NewContext(ctx, id)
+}
+
+// admitJobTask reports whether a fetch task may run now. Tasks of a paused
+// job are parked until the job is resumed and tasks of a cancelled job are
+// dropped. Store tasks always run, since their page was already fetched.
+// An error means a task could not be parked and must not be acknowledged.
+func (w *Worker) admitJobTask(ctx context.Context, task *models.Task) (bool, error) {
+	if task.Topic == storeDataTask {
+		return true, nil
+	}
+	job := w.job(ctx, task)
+	if job == nil {
+		return true, nil
+	}
+	switch job.Status {
+	case models.JobPaused:
+		if err := w.pool.jobs.Store().Park(ctx, job.ID, []*models.Task{task}); err != nil {
+			return false, err
+		}
+		w.metrics.Jobs.ObserveTasks(job.ID, "parked", 1)
+		return false, nil
+	case models.JobCancelled:
+		w.metrics.Jobs.ObserveTasks(job.ID, "cancelled", 1)
+		return false, nil
+	}
+	return true, nil
+}
+
+// admitLinks filters the links discovered from parent down to those within
+// the seed's max depth and, for job tasks, the job's scope. When parent is a
+// crawled page it is counted against the job's page budget, and once the
+// budget is spent no more links are queued.
+func (w *Worker) admitLinks(ctx context.Context, parent *models.Task, links []*models.Task, crawled bool) []*models.Task {
+	job := w.job(ctx, parent)
+	if job != nil && crawled {
+		n, err := w.pool.jobs.Store().CountPage(ctx, job.ID)
+		if err != nil {
+			slog.Error("failed to count job page", "job", job.ID, "error", err)
+		} else if !job.PageBudgetLeft(n) {
+			w.metrics.Jobs.ObserveTasks(job.ID, "over_budget", len(links))
+			return nil
+		}
+	}
+
+	admitted := make([]*models.Task, 0, len(links))
+	var overDepth, outOfScope int
+	for _, l := range links {
+		switch {
+		case !l.WithinDepth():
+			overDepth++
+		case job != nil && !job.InScope(l.URL):
+			outOfScope++
+		default:
+			admitted = append(admitted, l)
+		}
+	}
+	if job != nil {
+		w.metrics.Jobs.ObserveTasks(job.ID, "queued", len(admitted))
+		w.metrics.Jobs.ObserveTasks(job.ID, "over_depth", overDepth)
+		w.metrics.Jobs.ObserveTasks(job.ID, "out_of_scope", outOfScope)
+	}
+	return admitted
+}
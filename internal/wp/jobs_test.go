@@ -0,0 +1,66 @@
+package wp
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/NesterovYehor/Crawler/internal/config"
+	"github.com/NesterovYehor/Crawler/internal/jobs"
+	"github.com/NesterovYehor/Crawler/internal/models"
+	"github.com/NesterovYehor/Crawler/internal/queue"
+	"github.com/NesterovYehor/Crawler/tests/testutils/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// parkFailingStore fails to park tasks.
+type parkFailingStore struct {
+	jobs.Interface
+}
+
+func (s *parkFailingStore) Park(ctx context.Context, id string, tasks []*models.Task) error {
+	return errors.New("redis is down")
+}
+
+func TestPausedJobTaskNotAcknowledgedUnlessParked(t *testing.T) {
+	ctx := context.Background()
+	for _, tt := range []struct {
+		name   string
+		store  jobs.Interface
+		parked bool
+	}{
+		{"parked", jobs.NewMemoryStore(), true},
+		{"park fails", &parkFailingStore{Interface: jobs.NewMemoryStore()}, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			job := &models.Job{}
+			require.NoError(t, tt.store.Create(ctx, job))
+			require.NoError(t, tt.store.SetStatus(ctx, job.ID, models.JobPaused))
+
+			q := &deadLetterFailingQueue{Interface: queue.NewMemoryQueue(&config.Queue{})}
+			pool, err := NewWorkerPool(&WorkerPoolOpts{
+				Config:  &config.Workers{},
+				Queue:   q,
+				Metrics: mocks.NewNoopMetrics(),
+				Jobs:    tt.store,
+			})
+			require.NoError(t, err)
+			stopped := make(chan struct{})
+			close(stopped)
+			pool.opsDone = stopped
+			w := newWorker("high:0", pool, queue.NewSource(queue.HighPriorityQueue, pool.lanes), pool.metrics, &laneLoad{})
+
+			task := models.NewTask(processPageTask, "https://example.com/", queue.HighPriorityQueue, "")
+			task.ID, task.JobID = "1-0", job.ID
+			err = w.dispatchTask(ctx, task)
+			if tt.parked {
+				assert.NoError(t, err)
+				assert.Equal(t, []*models.Task{task}, q.acked, "a parked task is acknowledged")
+				return
+			}
+			assert.Error(t, err)
+			assert.Empty(t, q.acked, "a task that could not be parked stays pending")
+		})
+	}
+}
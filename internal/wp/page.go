@@ -0,0 +1,49 @@
+package wp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+
+	"github.com/NesterovYehor/Crawler/internal/crawler"
+	httpclient "github.com/NesterovYehor/Crawler/internal/http_client"
+	"github.com/NesterovYehor/Crawler/internal/models"
+	"github.com/NesterovYehor/Crawler/internal/parser"
+	"github.com/NesterovYehor/Crawler/internal/utils"
+)
+
+// fetchPage fetches the page at rawURL and reads it in one pass: the links
+// are extracted while the body is hashed and kept for storage. Links are
+// resolved against the URL the page finally came from. A failed fetch
+// returns a result that only tells whether it is worth retrying.
+func fetchPage(ctx context.Context, client httpclient.Interface, rawURL, domain string) (*crawler.CrawlResult, error) {
+	res, err := client.Fetch(ctx, rawURL)
+	if err != nil {
+		return &crawler.CrawlResult{Retry: utils.Retryable(err)}, err
+	}
+	defer func() {
+		if err := res.Close(); err != nil {
+			slog.Error(err.Error())
+		}
+	}()
+
+	base, err := url.Parse(res.FinalURL)
+	if err != nil || res.FinalURL == "" {
+		if base, err = url.Parse(rawURL); err != nil {
+			return &crawler.CrawlResult{}, fmt.Errorf("invalid URL: %w", err)
+		}
+	}
+
+	var links []string
+	page, err := models.ReadPage(rawURL, domain, res.Body, res.ContentLength, res.Duration, func(r io.Reader) error {
+		var err error
+		links, err = parser.GetURLsFromHTML(r, base)
+		return err
+	})
+	if err != nil {
+		return &crawler.CrawlResult{Retry: utils.Retryable(err)}, err
+	}
+	return &crawler.CrawlResult{PageData: page, Urls: links}, nil
+}
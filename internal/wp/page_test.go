@@ -0,0 +1,43 @@
+package wp
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	httpclient "github.com/NesterovYehor/Crawler/internal/http_client"
+	"github.com/NesterovYehor/Crawler/internal/utils"
+	"github.com/NesterovYehor/Crawler/tests/testutils/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetchPage(t *testing.T) {
+	const body = `<html><body><a href="next">next</a><a href="mailto:me@example.com">mail</a></body></html>`
+	client := &mocks.MockHTTPClient{
+		FetchFn: func(ctx context.Context, rawURL string) (*httpclient.FetchResult, error) {
+			switch rawURL {
+			case "https://example.com/old":
+				res := mocks.HTMLResult(rawURL, body)
+				res.FinalURL = "https://example.com/docs/"
+				return res, nil
+			case "https://example.com/busy":
+				return nil, &utils.StatusError{Code: 503}
+			}
+			return nil, errors.New("unknown URL")
+		},
+	}
+	ctx := context.Background()
+
+	result, err := fetchPage(ctx, client, "https://example.com/old", "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"https://example.com/docs/next"}, result.Urls, "links resolve against the final URL")
+	require.NotNil(t, result.PageData)
+	assert.Equal(t, body, string(result.PageData.Content))
+	assert.Equal(t, len(body), result.PageData.Metadata.ContentLen)
+	assert.Equal(t, "example.com", result.PageData.Metadata.Host)
+
+	result, err = fetchPage(ctx, client, "https://example.com/busy", "example.com")
+	assert.Error(t, err)
+	assert.True(t, result.Retry)
+}
@@ -5,15 +5,19 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
 	"sync"
 	"time"
 
 	"github.com/NesterovYehor/Crawler/internal/config"
 	httpclient "github.com/NesterovYehor/Crawler/internal/http_client"
+	"github.com/NesterovYehor/Crawler/internal/identity"
+	"github.com/NesterovYehor/Crawler/internal/jobs"
 	"github.com/NesterovYehor/Crawler/internal/metrics"
 	"github.com/NesterovYehor/Crawler/internal/models"
 	"github.com/NesterovYehor/Crawler/internal/politeness"
 	"github.com/NesterovYehor/Crawler/internal/queue"
+	"github.com/NesterovYehor/Crawler/internal/retry"
 	"github.com/NesterovYehor/Crawler/internal/storage"
 	"github.com/NesterovYehor/Crawler/internal/utils"
 )
@@ -22,6 +26,8 @@ const (
 	processRulesTask = "fetch_rules"
 	processPageTask  = "crawl_page"
 	storeDataTask    = "store_data"
+
+	defaultTaskTimeout = time.Minute
 )
 
 type OperationChan struct {
@@ -42,19 +48,94 @@ type WorkerPool struct {
 	fillInProgress map[string]bool
 	mu             sync.Mutex
 	metrics        *metrics.Metrics
+	lanes          []config.Lane
+	bp             *backpressure
+	jobs           *jobs.Tracker
+	workers        sync.WaitGroup
+	fills          sync.WaitGroup
+	draining       bool
+	stranded       []*models.Task
+	opsDone        <-chan struct{}
+	scaler         *autoscaler
+	load           map[string]*laneLoad
+	laneWorkers    map[string][]context.CancelFunc
+	spawned        map[string]int
+	handlers       map[string]Handler
+	statusMu       sync.Mutex
+	status         map[string]*workerStatus
+	adminAddr      string
+	taskTimeout    time.Duration
+	identity       identity.Identity
+	retryPolicy    *retry.Policy
 }
 
 type WorkerPoolOpts struct {
-	Config     *config.Workers
-	Queue      queue.Interface
-	ST         storage.Interface
-	PM         *politeness.PolitenessManager
-	HttpClient httpclient.Interface
-	Metrics    *metrics.Metrics
+	Config       *config.Workers
+	Queue        queue.Interface
+	ST           storage.Interface
+	PM           *politeness.PolitenessManager
+	HttpClient   httpclient.Interface
+	Metrics      *metrics.Metrics
+	Lanes        []config.Lane
+	Backpressure *config.Backpressure
+	Jobs         jobs.Interface
+	// Handlers adds or replaces topic handlers; see WorkerPool.Register.
+	Handlers map[string]Handler
+	// Admin is where the admin endpoint listens; nil or an empty port
+	// disables it.
+	Admin *config.Admin
+	// Identity is sent with every request and matched against robots.txt.
+	// Jobs may override it.
+	Identity identity.Identity
+	// Retry is the retry policy of failed tasks; nil keeps the default
+	// exponential backoff.
+	Retry *config.Retry
 }
 
 func NewWorkerPool(opts *WorkerPoolOpts) (*WorkerPool, error) {
-	return &WorkerPool{
+	lanes, err := queue.Lanes(opts.Lanes, opts.Config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid lane config: %w", err)
+	}
+	// The worker counts are clamped below; opts.Lanes stays as declared.
+	lanes = slices.Clone(lanes)
+	bp, err := newBackpressure(opts.Backpressure, lanes, opts.Queue, opts.Metrics.Queue)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backpressure config: %w", err)
+	}
+	policy, err := retry.NewPolicy(opts.Retry)
+	if err != nil {
+		return nil, fmt.Errorf("invalid retry config: %w", err)
+	}
+	var tracker *jobs.Tracker
+	if opts.Jobs != nil {
+		tracker = jobs.NewTracker(opts.Jobs, 0)
+	}
+	var adminAddr string
+	if opts.Admin != nil {
+		adminAddr = opts.Admin.Port
+	}
+	var scaler *autoscaler
+	if opts.Config.Autoscale.Enabled {
+		scaler = newAutoscaler(opts.Config.Autoscale)
+	}
+	buffers := make(map[string]chan *models.Task, len(lanes))
+	fillInProgress := make(map[string]bool, len(lanes))
+	load := make(map[string]*laneLoad, len(lanes))
+	for i, lane := range lanes {
+		// A buffer holds two tasks for each worker the lane may have.
+		most := lane.Workers
+		if scaler != nil {
+			lo, hi := laneBounds(lane)
+			lanes[i].Workers = min(max(lane.Workers, lo), hi)
+			most = hi
+		}
+		buffers[lane.Name] = make(chan *models.Task, max(most*2, 2))
+		fillInProgress[lane.Name] = false
+		load[lane.Name] = &laneLoad{}
+	}
+
+	wp := &WorkerPool{
 		st:         opts.ST,
 		queue:      opts.Queue,
 		pm:         opts.PM,
@@ -65,60 +146,128 @@ func NewWorkerPool(opts *WorkerPoolOpts) (*WorkerPool, error) {
 			delChan:   make(chan *models.Task, 7000),
 			retryChan: make(chan *models.Task, 5000),
 		},
-		buffers: map[string]chan *models.Task{
-			"queue:fetch:high":   make(chan *models.Task, opts.Config.Fetch.HighPrioretyCount*2),
-			"queue:fetch:medium": make(chan *models.Task, opts.Config.Fetch.MedPrioretyCount*2),
-			"queue:fetch:retry":  make(chan *models.Task, opts.Config.Fetch.LowPrioretyCount*2),
-			"queue:store":        make(chan *models.Task, opts.Config.Upload.Count*2),
-		},
-		cfg: opts.Config,
-		fillInProgress: map[string]bool{
-			"queue:fetch:high":   false,
-			"queue:fetch:medium": false,
-			"queue:fetch:low":    false,
-			"queue:store":        false,
-		},
-		wg: &sync.WaitGroup{},
-	}, nil
+		buffers:        buffers,
+		cfg:            opts.Config,
+		fillInProgress: fillInProgress,
+		lanes:          lanes,
+		bp:             bp,
+		jobs:           tracker,
+		scaler:         scaler,
+		load:           load,
+		laneWorkers:    make(map[string][]context.CancelFunc, len(lanes)),
+		spawned:        make(map[string]int, len(lanes)),
+		handlers:       builtinHandlers(),
+		status:         make(map[string]*workerStatus),
+		adminAddr:      adminAddr,
+		taskTimeout:    opts.Config.TaskTimeout,
+		identity:       opts.Identity,
+		retryPolicy:    policy,
+		wg:             &sync.WaitGroup{},
+	}
+	if wp.taskTimeout <= 0 {
+		wp.taskTimeout = defaultTaskTimeout
+	}
+	for topic, h := range opts.Handlers {
+		if err := wp.Register(topic, h); err != nil {
+			return nil, err
+		}
+	}
+	return wp, nil
 }
 
+// Run starts the workers and blocks until ctx is done and the pool has shut
+// down. Workers stop taking tasks as soon as ctx is done, but the tasks they
+// hold keep running on taskCtx and the operation handlers on opsCtx until
+// shutdown has drained them.
 func (wp *WorkerPool) Run(ctx context.Context) {
-	wp.spawnWorkers(wp.cfg.Fetch.HighPrioretyCount, ctx, queue.HighPriorityQueue)
-	wp.spawnWorkers(wp.cfg.Fetch.MedPrioretyCount, ctx, queue.MediumPriorityQueue)
-	wp.spawnWorkers(wp.cfg.Fetch.LowPrioretyCount, ctx, queue.RetryPriorityQueue)
-	wp.spawnWorkers(wp.cfg.Upload.Count, ctx, queue.StoreQueue)
+	taskCtx, cancelTasks := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelTasks()
+	opsCtx, stopOps := context.WithCancel(context.WithoutCancel(ctx))
+	defer stopOps()
+	wp.opsDone = opsCtx.Done()
 
-	wp.wg.Add(3)
+	for _, lane := range wp.lanes {
+		wp.spawnWorkers(lane.Workers, ctx, taskCtx, lane.Name)
+	}
+
+	wp.wg.Add(4)
+	go func() {
+		defer wp.wg.Done()
+		wp.handleAdd(opsCtx)
+	}()
 	go func() {
 		defer wp.wg.Done()
-		wp.handleAdd(ctx)
+		wp.handleDel(opsCtx)
 	}()
 	go func() {
 		defer wp.wg.Done()
-		wp.handleDel(ctx)
+		wp.handleRetry(opsCtx)
 	}()
 	go func() {
 		defer wp.wg.Done()
-		wp.handleRetry(ctx)
+		wp.queue.RunReclaimer(ctx)
+	}()
+
+	// The admin endpoint stays up while the pool drains.
+	if wp.adminAddr != "" {
+		adminCtx, stopAdmin := context.WithCancel(context.WithoutCancel(ctx))
+		defer stopAdmin()
+		go wp.serveAdmin(adminCtx)
+	}
+
+	scaled := make(chan struct{})
+	go func() {
+		defer close(scaled)
+		if wp.scaler != nil {
+			wp.runAutoscaler(ctx, taskCtx)
+		}
 	}()
 	<-ctx.Done()
+	// The autoscaler must not spawn workers while shutdown waits for them.
+	<-scaled
+	wp.shutdown(cancelTasks, stopOps)
 }
 
-func (wp *WorkerPool) spawnWorkers(count int, ctx context.Context, sourceName string) {
-	for i := range count {
-		go func(idx int) {
-			state := queue.NewSource(sourceName)
-			id := fmt.Sprintf("%v:%v", sourceName, idx)
-			w := newWorker(id, wp, state, wp.metrics)
-			w.Start(ctx)
-		}(i)
+func (wp *WorkerPool) spawnWorkers(count int, ctx, taskCtx context.Context, sourceName string) {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	wp.workers.Add(count)
+	for range count {
+		workerCtx, stop := context.WithCancel(ctx)
+		wp.laneWorkers[sourceName] = append(wp.laneWorkers[sourceName], stop)
+		idx := wp.spawned[sourceName]
+		wp.spawned[sourceName]++
+		go func() {
+			defer wp.workers.Done()
+			defer stop()
+			wp.supervise(workerCtx, taskCtx, fmt.Sprintf("%v:%v", sourceName, idx), sourceName)
+		}()
 	}
 }
 
+// retireWorkers stops the newest count workers of a lane. Each finishes its
+// current task before it exits.
+func (wp *WorkerPool) retireWorkers(count int, sourceName string) {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	workers := wp.laneWorkers[sourceName]
+	count = min(count, len(workers))
+	for _, stop := range workers[len(workers)-count:] {
+		stop()
+	}
+	wp.laneWorkers[sourceName] = workers[:len(workers)-count]
+}
+
+func (wp *WorkerPool) workerCount(sourceName string) int {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	return len(wp.laneWorkers[sourceName])
+}
+
 func (wp *WorkerPool) getNextTask(worker *Worker, ctx context.Context) *models.Task {
 	source := worker.taskSource.GetCurrentSource()
 	if len(wp.buffers[source]) < max(1, cap(wp.buffers[source])/4) {
-		wp.tryRefillQueue(ctx, worker.taskSource)
+		wp.tryRefillQueue(ctx, worker.taskSource, source)
 	}
 
 	select {
@@ -128,13 +277,15 @@ func (wp *WorkerPool) getNextTask(worker *Worker, ctx context.Context) *models.T
 		return nil
 	}
 }
-func (wp *WorkerPool) tryRefillQueue(ctx context.Context, source *queue.Source) {
+
+func (wp *WorkerPool) tryRefillQueue(ctx context.Context, source *queue.Source, lane string) {
 	wp.mu.Lock()
-	if wp.fillInProgress[source.Curr] {
+	if wp.fillInProgress[lane] || wp.draining {
 		wp.mu.Unlock()
 		return
 	}
-	wp.fillInProgress[source.Curr] = true
+	wp.fillInProgress[lane] = true
+	wp.fills.Add(1)
 	wp.mu.Unlock()
 
 	// This goroutine now performs one single refill operation and then exits.
@@ -142,8 +293,9 @@ func (wp *WorkerPool) tryRefillQueue(ctx context.Context, source *queue.Source)
 	go func() {
 		defer func() {
 			wp.mu.Lock()
-			wp.fillInProgress[source.Curr] = false
+			wp.fillInProgress[lane] = false
 			wp.mu.Unlock()
+			wp.fills.Done()
 		}()
 
 		// Check if the context is already done before starting.
@@ -151,30 +303,33 @@ func (wp *WorkerPool) tryRefillQueue(ctx context.Context, source *queue.Source)
 			return
 		}
 
-		queueToTry := source.GetCurrentSource()
-		err := wp.fillChannel(wp.buffers[queueToTry], ctx, queueToTry)
+		err := wp.fillChannel(wp.buffers[lane], ctx, lane)
 		if err != nil {
 			if errors.Is(err, utils.ErrNoTasks) {
-				source.MarkQueueFailed()
+				source.MarkQueueFailed(lane)
 			} else if !errors.Is(err, context.Canceled) {
-				slog.Error("WorkerPool: fillChannel background error", "error", err, "source", queueToTry)
-				source.MarkQueueFailed()
+				slog.Error("WorkerPool: fillChannel background error", "error", err, "source", lane)
+				source.MarkQueueFailed(lane)
 			}
+			return
 		}
+		source.MarkQueueSucceeded(lane)
 	}()
 }
 
-
 func (wp *WorkerPool) fillChannel(ch chan *models.Task, ctx context.Context, sourceName string) error {
 	messages, err := wp.queue.GetTasks(ctx, max(cap(ch)-len(ch), 1), sourceName)
 	if err != nil {
 		return err
 	}
-	for _, m := range messages {
+	for i, m := range messages {
 		if m != nil {
 			select {
 			case ch <- m:
 			case <-ctx.Done():
+				// The rest was delivered to this pool, so it is requeued on
+				// shutdown instead of waiting for the reclaimer.
+				wp.strand(messages[i:]...)
 				return ctx.Err()
 			case <-time.After(time.Second):
 				return fmt.Errorf("buffer for source %s full, failed to push task within timeout", sourceName)
@@ -192,64 +347,97 @@ func (wp *WorkerPool) handleAdd(ctx context.Context) {
 	defer ticker.Stop()
 
 	for {
+		// A nil channel is never ready, so discovery blocks on addChan while
+		// the backpressure policy holds too many tasks.
+		addChan := wp.operationChan.addChan
+		if wp.bp.blocking() {
+			addChan = nil
+		}
+
 		select {
 		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), shutdownGrace)
+			defer cancel()
+			// This handler is the only reader, so the receives never block.
+			for len(wp.operationChan.addChan) > 0 {
+				msg := <-wp.operationChan.addChan
+				buffer = wp.appendUnseen(flushCtx, buffer, msg)
+			}
+			wp.flushAdd(flushCtx, &buffer)
+			if held := wp.bp.drain(); len(held) > 0 {
+				wp.addTasks(held)
+			}
 			return
 
-		case msg, _ := <-wp.operationChan.addChan:
-			for _, m := range msg {
-				if m.Topic == processPageTask {
-					crawled, err := wp.st.ExistsInBF(ctx, m.URL)
-					if err != nil {
-						slog.Error(err.Error())
-					}
-					if !crawled {
-						buffer = append(buffer, m)
-					}
-				} else {
-					buffer = append(buffer, m)
-
-				}
-			}
-		if len(buffer) >= 1000 {
-				wp.flushAdd(&buffer)
+		case msg := <-addChan:
+			buffer = wp.appendUnseen(ctx, buffer, msg)
+			if len(buffer) >= 1000 {
+				wp.flushAdd(ctx, &buffer)
 			}
 		case <-ticker.C:
-			if len(buffer) > 0 {
-				wp.flushAdd(&buffer)
+			wp.flushAdd(ctx, &buffer)
+		}
+	}
+}
+
+// appendUnseen appends tasks to buffer, leaving out pages that were already
+// crawled.
+func (wp *WorkerPool) appendUnseen(ctx context.Context, buffer []*models.Task, tasks []*models.Task) []*models.Task {
+	for _, m := range tasks {
+		if m.Topic == processPageTask {
+			crawled, err := wp.st.Seen(ctx, m.JobID, m.URL)
+			if err != nil {
+				slog.Error(err.Error())
+			}
+			if crawled {
+				continue
 			}
 		}
+		buffer = append(buffer, m)
 	}
+	return buffer
 }
 
-func (wp *WorkerPool) flushAdd(buffer *[]*models.Task) {
-	if err := wp.queue.Add(*buffer); err != nil {
-		slog.Warn("Error while adding new messages to a queue: error", "error", err)
+func (wp *WorkerPool) flushAdd(ctx context.Context, buffer *[]*models.Task) {
+	tasks := wp.bp.admit(*buffer)
+	if wp.bp.refresh(ctx) {
+		tasks = append(tasks, wp.bp.release()...)
 	}
 	*buffer = (*buffer)[:0]
+	if len(tasks) == 0 {
+		return
+	}
+	wp.addTasks(tasks)
+}
+
+func (wp *WorkerPool) addTasks(tasks []*models.Task) {
+	if err := wp.queue.Add(tasks); err != nil {
+		slog.Warn("Error while adding new messages to a queue: error", "error", err)
+	}
 }
 
 func (wp *WorkerPool) handleDel(ctx context.Context) {
 	buffer := make([]*models.Task, 0, 500)
 	ticker := time.NewTicker(30 * time.Millisecond)
+	defer ticker.Stop()
 	for {
 		select {
 		case <-ctx.Done():
-			return
-		case msg, ok := <-wp.operationChan.delChan:
-			if !ok {
-				if len(buffer) > 100 {
-					wp.flushDel(buffer)
-					buffer = buffer[:0]
-				}
+			for len(wp.operationChan.delChan) > 0 {
+				msg := <-wp.operationChan.delChan
+				buffer = append(buffer, msg)
 			}
+			if len(buffer) > 0 {
+				wp.flushDel(buffer)
+			}
+			return
+		case msg := <-wp.operationChan.delChan:
 			buffer = append(buffer, msg)
 		case <-ticker.C:
 			if len(buffer) > 0 {
 				wp.flushDel(buffer)
 				buffer = buffer[:0]
 			}
-
 		}
 	}
 }
@@ -263,17 +451,77 @@ func (wp *WorkerPool) flushDel(buffer []*models.Task) {
 func (wp *WorkerPool) handleRetry(ctx context.Context) {
 	for {
 		select {
-
 		case <-ctx.Done():
-			return
-		default:
-			msg, ok := <-wp.operationChan.retryChan
-			if !ok {
-				return
-			}
-			if err := wp.queue.Retry(ctx, *msg); err != nil {
-				slog.Warn(fmt.Sprintf("Error while retrying models.Task: %v", err))
+			flushCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), shutdownGrace)
+			defer cancel()
+			for len(wp.operationChan.retryChan) > 0 {
+				msg := <-wp.operationChan.retryChan
+				wp.retry(flushCtx, msg)
 			}
+			return
+		case msg := <-wp.operationChan.retryChan:
+			wp.retry(ctx, msg)
 		}
 	}
 }
+
+func (wp *WorkerPool) retry(ctx context.Context, msg *models.Task) {
+	if err := wp.queue.Retry(ctx, *msg); err != nil {
+		slog.Warn(fmt.Sprintf("Error while retrying models.Task: %v", err))
+	}
+}
+
+// The operation handlers stop once shutdown has given up on the workers.
+// A worker still running after that, past the shutdown grace, queues its
+// operations itself rather than block on a channel nobody reads.
+
+// enqueue hands discovered tasks to handleAdd.
+func (wp *WorkerPool) enqueue(tasks []*models.Task) {
+	if !wp.opsStopped() {
+		select {
+		case wp.operationChan.addChan <- tasks:
+			return
+		case <-wp.opsDone:
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownGrace)
+	defer cancel()
+	if tasks = wp.appendUnseen(ctx, nil, tasks); len(tasks) > 0 {
+		wp.addTasks(tasks)
+	}
+}
+
+// ack hands a processed task to handleDel.
+func (wp *WorkerPool) ack(task *models.Task) {
+	if !wp.opsStopped() {
+		select {
+		case wp.operationChan.delChan <- task:
+			return
+		case <-wp.opsDone:
+		}
+	}
+	wp.flushDel([]*models.Task{task})
+}
+
+// scheduleRetry hands a task to handleRetry.
+func (wp *WorkerPool) scheduleRetry(task *models.Task) {
+	if !wp.opsStopped() {
+		select {
+		case wp.operationChan.retryChan <- task:
+			return
+		case <-wp.opsDone:
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownGrace)
+	defer cancel()
+	wp.retry(ctx, task)
+}
+
+func (wp *WorkerPool) opsStopped() bool {
+	select {
+	case <-wp.opsDone:
+		return true
+	default:
+		return false
+	}
+}
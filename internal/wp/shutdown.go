@@ -0,0 +1,110 @@
+package wp
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/NesterovYehor/Crawler/internal/models"
+)
+
+const (
+	defaultShutdownTimeout = 30 * time.Second
+	// shutdownGrace bounds each step after the shutdown timeout: waiting for
+	// cancelled tasks to return and the final flush of every buffer.
+	shutdownGrace = 5 * time.Second
+)
+
+// shutdown drains the pool once the context passed to Run is done. Workers
+// have already stopped taking tasks; the ones in flight may finish until the
+// shutdown timeout and are cancelled after it. Then every operation buffer
+// is flushed, and tasks that were delivered to the pool but never processed
+// are put back into the queue.
+func (wp *WorkerPool) shutdown(cancelTasks, stopOps context.CancelFunc) {
+	timeout := wp.cfg.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+	slog.Info("WorkerPool: draining", "timeout", timeout)
+
+	if !waitTimeout(&wp.workers, timeout) {
+		slog.Warn("WorkerPool: shutdown timeout reached, cancelling in-flight tasks")
+		cancelTasks()
+		if !waitTimeout(&wp.workers, shutdownGrace) {
+			slog.Error("WorkerPool: workers did not stop, their tasks are left to the reclaimer")
+		}
+	}
+
+	wp.mu.Lock()
+	wp.draining = true
+	wp.mu.Unlock()
+	wp.fills.Wait()
+
+	stopOps()
+	wp.wg.Wait()
+
+	wp.requeueStranded()
+	slog.Info("WorkerPool: stopped")
+}
+
+// strand records tasks that were delivered to the pool but will not be
+// processed by it.
+func (wp *WorkerPool) strand(tasks ...*models.Task) {
+	wp.mu.Lock()
+	wp.stranded = append(wp.stranded, tasks...)
+	wp.mu.Unlock()
+}
+
+// requeueStranded puts stranded and still buffered tasks back into the
+// queue as new entries and acknowledges the deliveries they came from. If
+// adding any of them fails the deliveries stay pending, so the reclaimer
+// hands them out again later; a task may then be crawled twice, but none is
+// lost.
+func (wp *WorkerPool) requeueStranded() {
+	wp.mu.Lock()
+	tasks := wp.stranded
+	wp.stranded = nil
+	wp.mu.Unlock()
+	for _, buf := range wp.buffers {
+	drain:
+		for {
+			select {
+			case t := <-buf:
+				tasks = append(tasks, t)
+			default:
+				break drain
+			}
+		}
+	}
+	if len(tasks) == 0 {
+		return
+	}
+
+	fresh := make([]*models.Task, len(tasks))
+	for i, t := range tasks {
+		c := *t
+		c.ID, c.Deliveries = "", 0
+		fresh[i] = &c
+	}
+	if err := wp.queue.Add(fresh); err != nil {
+		slog.Error("WorkerPool: failed to requeue unprocessed tasks", "count", len(tasks), "error", err)
+		return
+	}
+	wp.flushDel(tasks)
+	slog.Info("WorkerPool: requeued unprocessed tasks", "count", len(tasks))
+}
+
+func waitTimeout(wg *sync.WaitGroup, d time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(d):
+		return false
+	}
+}
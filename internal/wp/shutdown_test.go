@@ -0,0 +1,150 @@
+package wp
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/NesterovYehor/Crawler/internal/config"
+	"github.com/NesterovYehor/Crawler/internal/models"
+	"github.com/NesterovYehor/Crawler/internal/queue"
+	"github.com/NesterovYehor/Crawler/internal/storage"
+	"github.com/NesterovYehor/Crawler/tests/testutils/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// slowStore holds every store task until release is closed or its context
+// is cancelled.
+type slowStore struct {
+	storage.Interface
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *slowStore) GetTempByUUID(ctx context.Context, id string) (*models.PageDataModel, error) {
+	select {
+	case s.started <- struct{}{}:
+	default:
+	}
+	select {
+	case <-s.release:
+		return &models.PageDataModel{}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (s *slowStore) SaveIfNew(ctx context.Context, data *models.PageDataModel) error {
+	return nil
+}
+
+func TestWorkerPoolShutdown(t *testing.T) {
+	lanes := []config.Lane{
+		{Name: queue.HighPriorityQueue},
+		{Name: queue.MediumPriorityQueue},
+		{Name: queue.StoreQueue, Workers: 1, Weight: 1},
+		{Name: queue.RetryPriorityQueue},
+	}
+
+	for name, tc := range map[string]struct {
+		finish   bool
+		requeued int64
+	}{
+		"in_flight_task_finishes": {finish: true, requeued: 2},
+		"in_flight_task_requeued": {finish: false, requeued: 3},
+	} {
+		t.Run(name, func(t *testing.T) {
+			q := queue.NewMemoryQueue(&config.Queue{})
+			tasks := make([]*models.Task, 3)
+			for i := range tasks {
+				tasks[i] = models.NewTask(storeDataTask, fmt.Sprintf("https://example.com/%d", i), queue.StoreQueue, fmt.Sprint(i))
+			}
+			require.NoError(t, q.Add(tasks))
+
+			st := &slowStore{started: make(chan struct{}, 1), release: make(chan struct{})}
+			pool, err := NewWorkerPool(&WorkerPoolOpts{
+				Config:  &config.Workers{ShutdownTimeout: 100 * time.Millisecond},
+				Queue:   q,
+				ST:      st,
+				Metrics: mocks.NewNoopMetrics(),
+				Lanes:   lanes,
+			})
+			require.NoError(t, err)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			stopped := make(chan struct{})
+			go func() {
+				pool.Run(ctx)
+				close(stopped)
+			}()
+
+			select {
+			case <-st.started:
+			case <-time.After(5 * time.Second):
+				t.Fatal("no task was started")
+			}
+			cancel()
+			if tc.finish {
+				close(st.release)
+			}
+
+			select {
+			case <-stopped:
+			case <-time.After(10 * time.Second):
+				t.Fatal("worker pool did not stop")
+			}
+
+			n, err := q.Len(context.Background(), queue.StoreQueue)
+			require.NoError(t, err)
+			assert.Equal(t, tc.requeued, n, "unprocessed tasks should be back in the queue and nothing left pending")
+
+			got, err := q.GetTasks(context.Background(), 10, queue.StoreQueue)
+			require.NoError(t, err)
+			assert.Len(t, got, int(tc.requeued))
+			for _, task := range got {
+				assert.Equal(t, 1, task.Deliveries, "requeued tasks start over as new entries")
+			}
+		})
+	}
+}
+
+func TestOperationsAfterHandlersStop(t *testing.T) {
+	ctx := context.Background()
+	q := queue.NewMemoryQueue(&config.Queue{})
+	require.NoError(t, q.Add([]*models.Task{models.NewTask(storeDataTask, "https://example.com/a", queue.StoreQueue, "a")}))
+	delivered, err := q.GetTasks(ctx, 1, queue.StoreQueue)
+	require.NoError(t, err)
+	require.Len(t, delivered, 1)
+
+	pool, err := NewWorkerPool(&WorkerPoolOpts{Config: &config.Workers{}, Queue: q, Metrics: mocks.NewNoopMetrics()})
+	require.NoError(t, err)
+	// Unbuffered channels nobody reads, as after shutdown stopped the
+	// operation handlers.
+	pool.operationChan = &OperationChan{
+		addChan:   make(chan []*models.Task),
+		delChan:   make(chan *models.Task),
+		retryChan: make(chan *models.Task),
+	}
+	stopped := make(chan struct{})
+	close(stopped)
+	pool.opsDone = stopped
+
+	done := make(chan struct{})
+	go func() {
+		pool.ack(delivered[0])
+		pool.enqueue([]*models.Task{models.NewTask(storeDataTask, "https://example.com/b", queue.StoreQueue, "b")})
+		pool.scheduleRetry(models.NewTask(storeDataTask, "https://example.com/c", queue.StoreQueue, "c"))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("a late worker blocked on an operation channel")
+	}
+
+	n, err := q.Len(ctx, queue.StoreQueue)
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, n, "the delivery was acknowledged and the new task added")
+}
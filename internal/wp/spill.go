@@ -0,0 +1,119 @@
+package wp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/NesterovYehor/Crawler/internal/models"
+)
+
+// spillStore keeps tasks that did not fit into their lane on disk. Every
+// spill is written as its own JSON-lines segment under a directory per lane,
+// and segments are read back oldest first, so tasks return in the order they
+// were discovered.
+type spillStore struct {
+	dir string
+	seq int
+}
+
+func newSpillStore(dir string) (*spillStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create spill dir %s: %w", dir, err)
+	}
+	return &spillStore{dir: dir}, nil
+}
+
+func (s *spillStore) laneDir(lane string) string {
+	return filepath.Join(s.dir, strings.ReplaceAll(lane, ":", "_"))
+}
+
+func (s *spillStore) write(lane string, tasks []*models.Task) error {
+	dir := s.laneDir(lane)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create spill dir %s: %w", dir, err)
+	}
+	s.seq++
+	name := filepath.Join(dir, fmt.Sprintf("%020d-%06d.jsonl", time.Now().UnixNano(), s.seq))
+
+	f, err := os.CreateTemp(dir, ".segment-*")
+	if err != nil {
+		return fmt.Errorf("failed to create spill segment: %w", err)
+	}
+	w := bufio.NewWriter(f)
+	for _, t := range tasks {
+		var line []byte
+		if line, err = t.Marshal(); err != nil {
+			break
+		}
+		if _, err = w.Write(append(line, '\n')); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("failed to write spill segment: %w", err)
+	}
+	// The segment only becomes visible to read once it is complete.
+	return os.Rename(f.Name(), name)
+}
+
+// read returns the tasks of the oldest segment of lane and removes it. It
+// returns nil when nothing is spilled.
+func (s *spillStore) read(lane string) ([]*models.Task, error) {
+	dir := s.laneDir(lane)
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to list spill dir %s: %w", dir, err)
+	}
+
+	var segments []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".jsonl") {
+			segments = append(segments, e.Name())
+		}
+	}
+	if len(segments) == 0 {
+		return nil, nil
+	}
+	slices.Sort(segments)
+	name := filepath.Join(dir, segments[0])
+
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open spill segment: %w", err)
+	}
+	defer f.Close()
+
+	var tasks []*models.Task
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		t, err := models.UnmarshalTask(scanner.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode spill segment %s: %w", name, err)
+		}
+		t.SourceName = lane
+		tasks = append(tasks, t)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read spill segment %s: %w", name, err)
+	}
+	if err := os.Remove(name); err != nil {
+		return nil, fmt.Errorf("failed to remove spill segment: %w", err)
+	}
+	return tasks, nil
+}
@@ -0,0 +1,198 @@
+package wp
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NesterovYehor/Crawler/internal/models"
+	"github.com/NesterovYehor/Crawler/internal/queue"
+	"github.com/NesterovYehor/Crawler/internal/utils"
+)
+
+const (
+	WorkerIdle    = "idle"
+	WorkerBusy    = "busy"
+	WorkerRestart = "restarting"
+
+	restartDelay = time.Second
+	// maxTaskPanics is how often a task may panic before it is dead-lettered
+	// instead of retried.
+	maxTaskPanics = 2
+)
+
+// PanicRecord describes a recovered panic.
+type PanicRecord struct {
+	At    time.Time `json:"at"`
+	Topic string    `json:"topic,omitempty"`
+	URL   string    `json:"url,omitempty"`
+	Value string    `json:"value"`
+	Stack string    `json:"stack"`
+}
+
+// WorkerStatus is a snapshot of what a worker is doing and has done.
+type WorkerStatus struct {
+	ID        string       `json:"id"`
+	Lane      string       `json:"lane"`
+	State     string       `json:"state"`
+	Topic     string       `json:"topic,omitempty"`
+	URL       string       `json:"url,omitempty"`
+	StartedAt *time.Time   `json:"started_at,omitempty"`
+	Processed int64        `json:"processed"`
+	Failed    int64        `json:"failed"`
+	Panics    int64        `json:"panics"`
+	Restarts  int64        `json:"restarts"`
+	LastPanic *PanicRecord `json:"last_panic,omitempty"`
+}
+
+// workerStatus is the live status of one worker. It outlives restarts of
+// the worker it describes.
+type workerStatus struct {
+	mu sync.Mutex
+	s  WorkerStatus
+}
+
+func (ws *workerStatus) begin(task *models.Task) {
+	now := time.Now()
+	ws.mu.Lock()
+	ws.s.State, ws.s.Topic, ws.s.URL, ws.s.StartedAt = WorkerBusy, task.Topic, task.URL, &now
+	ws.mu.Unlock()
+}
+
+func (ws *workerStatus) end(failed bool) {
+	ws.mu.Lock()
+	ws.s.State, ws.s.Topic, ws.s.URL, ws.s.StartedAt = WorkerIdle, "", "", nil
+	if failed {
+		ws.s.Failed++
+	} else {
+		ws.s.Processed++
+	}
+	ws.mu.Unlock()
+}
+
+func (ws *workerStatus) panicked(rec *PanicRecord) {
+	ws.mu.Lock()
+	ws.s.State, ws.s.Topic, ws.s.URL, ws.s.StartedAt = WorkerRestart, "", "", nil
+	ws.s.Panics++
+	ws.s.LastPanic = rec
+	ws.mu.Unlock()
+}
+
+func (ws *workerStatus) restarted() {
+	ws.mu.Lock()
+	ws.s.State = WorkerIdle
+	ws.s.Restarts++
+	ws.mu.Unlock()
+}
+
+func (ws *workerStatus) snapshot() WorkerStatus {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	return ws.s
+}
+
+// supervise runs a worker until ctx is done and restarts it after a panic.
+// Its status entry is removed once it stops for good.
+func (wp *WorkerPool) supervise(ctx, taskCtx context.Context, id, lane string) {
+	status := &workerStatus{s: WorkerStatus{ID: id, Lane: lane, State: WorkerIdle}}
+	wp.statusMu.Lock()
+	wp.status[id] = status
+	wp.statusMu.Unlock()
+	defer func() {
+		wp.statusMu.Lock()
+		delete(wp.status, id)
+		wp.statusMu.Unlock()
+	}()
+
+	for {
+		w := newWorker(id, wp, queue.NewSource(lane, wp.lanes), wp.metrics, wp.load[lane])
+		w.status = status
+		if !wp.runWorker(ctx, taskCtx, w) {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(restartDelay):
+		}
+		status.restarted()
+		slog.Info("restarted worker after panic", "worker", id)
+	}
+}
+
+// runWorker runs w and reports whether it stopped because of a panic.
+// Panics inside a task are recovered by runTask; this catches the rest.
+func (wp *WorkerPool) runWorker(ctx, taskCtx context.Context, w *Worker) (panicked bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			wp.recordPanic(w, nil, r)
+			panicked = true
+		}
+	}()
+	return w.Start(ctx, taskCtx)
+}
+
+// runTask dispatches a task and recovers a panic in its handler. The task
+// is retried, or dead-lettered once it has panicked maxTaskPanics times, so
+// a poisoned page cannot crash workers forever. Only then is its delivery
+// acknowledged.
+func (w *Worker) runTask(ctx context.Context, task *models.Task) (panicked bool, err error) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		panicked = true
+		rec := w.pool.recordPanic(w, task, r)
+		err := fmt.Errorf("%w: %s", utils.ErrTaskPanicked, rec.Value)
+		task.RecordAttempt(err)
+		if countPanics(task) < maxTaskPanics {
+			w.pool.retryPolicy.Schedule(task, err)
+			w.pool.scheduleRetry(task)
+		} else if dlErr := w.pool.queue.DeadLetter(ctx, []*models.Task{task}); dlErr != nil {
+			// Left unacknowledged, the task is delivered again later.
+			slog.Error("failed to dead-letter panicking task", "url", task.URL, "error", dlErr)
+			return
+		}
+		w.pool.ack(task)
+	}()
+	return false, w.dispatchTask(ctx, task)
+}
+
+func (wp *WorkerPool) recordPanic(w *Worker, task *models.Task, r any) *PanicRecord {
+	rec := &PanicRecord{At: time.Now(), Value: fmt.Sprint(r), Stack: string(debug.Stack())}
+	if task != nil {
+		rec.Topic, rec.URL = task.Topic, task.URL
+	}
+	slog.Error("worker panicked", "worker", w.ID, "topic", rec.Topic, "url", rec.URL, "panic", rec.Value, "stack", rec.Stack)
+	w.status.panicked(rec)
+	wp.metrics.Workers.ObservePanic(w.status.snapshot().Lane, rec.Topic)
+	return rec
+}
+
+func countPanics(task *models.Task) int {
+	n := 0
+	for _, a := range task.Attempts {
+		if a.ErrorClass == utils.ErrorClassPanic {
+			n++
+		}
+	}
+	return n
+}
+
+// Status returns the status of every running worker, ordered by ID.
+func (wp *WorkerPool) Status() []WorkerStatus {
+	wp.statusMu.Lock()
+	out := make([]WorkerStatus, 0, len(wp.status))
+	for _, ws := range wp.status {
+		out = append(out, ws.snapshot())
+	}
+	wp.statusMu.Unlock()
+	slices.SortFunc(out, func(a, b WorkerStatus) int { return strings.Compare(a.ID, b.ID) })
+	return out
+}
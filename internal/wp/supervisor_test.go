@@ -0,0 +1,132 @@
+package wp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/NesterovYehor/Crawler/internal/config"
+	"github.com/NesterovYehor/Crawler/internal/models"
+	"github.com/NesterovYehor/Crawler/internal/queue"
+	"github.com/NesterovYehor/Crawler/internal/utils"
+	"github.com/NesterovYehor/Crawler/tests/testutils/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorkerSupervisorRecoversPanics(t *testing.T) {
+	q := queue.NewMemoryQueue(&config.Queue{})
+	fresh := models.NewTask("boom", "https://example.com/fresh", queue.StoreQueue, "")
+	poisoned := models.NewTask("boom", "https://example.com/poisoned", queue.StoreQueue, "")
+	poisoned.RecordAttempt(utils.ErrTaskPanicked)
+	require.NoError(t, q.Add([]*models.Task{fresh, poisoned}))
+
+	pool, err := NewWorkerPool(&WorkerPoolOpts{
+		Config:  &config.Workers{},
+		Queue:   q,
+		Metrics: mocks.NewNoopMetrics(),
+		Lanes: []config.Lane{
+			{Name: queue.HighPriorityQueue},
+			{Name: queue.MediumPriorityQueue},
+			{Name: queue.StoreQueue, Workers: 1, Weight: 1},
+			{Name: queue.RetryPriorityQueue},
+		},
+		Handlers: map[string]Handler{
+			"boom": HandlerFunc(func(ctx context.Context, tc *TaskContext, task *models.Task) error {
+				panic("parser exploded")
+			}),
+		},
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		pool.Run(ctx)
+		close(stopped)
+	}()
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+
+	require.Eventually(t, func() bool {
+		status := pool.Status()
+		return len(status) == 1 && status[0].Panics == 2 && status[0].Restarts == 2
+	}, 10*time.Second, 20*time.Millisecond, "the worker should be restarted after each panic")
+
+	status := pool.Status()[0]
+	assert.Equal(t, queue.StoreQueue+":0", status.ID)
+	require.NotNil(t, status.LastPanic)
+	assert.Equal(t, "parser exploded", status.LastPanic.Value)
+	assert.Contains(t, status.LastPanic.Stack, "supervisor_test.go")
+
+	retrying, err := q.Len(context.Background(), queue.RetryPriorityQueue)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), retrying, "a first panic should be retried")
+	dead, err := q.ListDeadLetters(context.Background(), queue.DeadLetterFilter{ErrorClass: utils.ErrorClassPanic})
+	require.NoError(t, err)
+	require.Len(t, dead, 1, "a repeated panic should be dead-lettered")
+	assert.Equal(t, poisoned.URL, dead[0].Task.URL)
+
+	rec := httptest.NewRecorder()
+	pool.StatusHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/workers", nil))
+	var served []WorkerStatus
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &served))
+	require.Len(t, served, 1)
+	assert.Equal(t, int64(2), served[0].Panics)
+}
+
+// deadLetterFailingQueue fails to dead-letter and records acknowledgements.
+type deadLetterFailingQueue struct {
+	queue.Interface
+	acked []*models.Task
+}
+
+func (q *deadLetterFailingQueue) DeadLetter(ctx context.Context, tasks []*models.Task) error {
+	return errors.New("redis is down")
+}
+
+func (q *deadLetterFailingQueue) Del(tasks []*models.Task) error {
+	q.acked = append(q.acked, tasks...)
+	return nil
+}
+
+func TestPanickingTaskAcknowledgedOnceHandledOff(t *testing.T) {
+	q := &deadLetterFailingQueue{Interface: queue.NewMemoryQueue(&config.Queue{})}
+	pool, err := NewWorkerPool(&WorkerPoolOpts{
+		Config:  &config.Workers{},
+		Queue:   q,
+		Metrics: mocks.NewNoopMetrics(),
+		Admin:   &config.Admin{Port: "127.0.0.1:2113"},
+		Handlers: map[string]Handler{
+			"boom": HandlerFunc(func(ctx context.Context, tc *TaskContext, task *models.Task) error {
+				panic("parser exploded")
+			}),
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:2113", pool.adminAddr, "the admin endpoint is configured from config.Admin")
+	// With the operation handlers stopped, operations reach the queue at once.
+	stopped := make(chan struct{})
+	close(stopped)
+	pool.opsDone = stopped
+	w := newWorker("store:0", pool, queue.NewSource(queue.StoreQueue, pool.lanes), pool.metrics, &laneLoad{})
+	w.status = &workerStatus{}
+
+	poisoned := models.NewTask("boom", "https://example.com/poisoned", queue.StoreQueue, "")
+	poisoned.ID = "1-0"
+	poisoned.RecordAttempt(utils.ErrTaskPanicked)
+	panicked, _ := w.runTask(context.Background(), poisoned)
+	assert.True(t, panicked)
+	assert.Empty(t, q.acked, "a task that could not be dead-lettered stays pending")
+
+	fresh := models.NewTask("boom", "https://example.com/fresh", queue.StoreQueue, "")
+	fresh.ID = "2-0"
+	panicked, _ = w.runTask(context.Background(), fresh)
+	assert.True(t, panicked)
+	assert.Equal(t, []*models.Task{fresh}, q.acked, "a retried task is acknowledged once")
+}
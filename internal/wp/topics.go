@@ -0,0 +1,256 @@
+package wp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/NesterovYehor/Crawler/internal/crawler"
+	"github.com/NesterovYehor/Crawler/internal/identity"
+	"github.com/NesterovYehor/Crawler/internal/models"
+	"github.com/NesterovYehor/Crawler/internal/politeness"
+	"github.com/NesterovYehor/Crawler/internal/queue"
+	"github.com/NesterovYehor/Crawler/internal/sitemap"
+	"github.com/NesterovYehor/Crawler/internal/utils"
+	"github.com/redis/go-redis/v9"
+	"github.com/temoto/robotstxt"
+)
+
+// handleRules fetches the robots.txt of a task's domain once and then hands
+// the task on as a page crawl.
+func handleRules(ctx context.Context, tc *TaskContext, task *models.Task) error {
+	domain, err := utils.GetDomain(task.URL)
+	if err != nil {
+		return fmt.Errorf("failed to parse domain: %w", err)
+	}
+
+	exists, err := tc.Storage.ExistsInBF(ctx, "robots:"+domain)
+	if err != nil {
+		tc.Retry(task, err)
+		return nil
+	}
+	if exists {
+		task.Topic = processPageTask
+		tc.Enqueue(task)
+		return nil
+	}
+
+	newTask, retry, err := fetchRobots(ctx, tc, task)
+	if err != nil {
+		slog.Error("robots.txt error", "err", err)
+		if retry {
+			tc.Retry(task, err)
+		} else {
+			if err := tc.Politeness.SaveRules(ctx, domain, ""); err != nil {
+				return err
+			}
+			task.Topic = processPageTask
+			tc.Enqueue(task)
+		}
+		return nil
+	}
+
+	tc.Enqueue(newTask)
+	return nil
+}
+
+func handlePage(ctx context.Context, tc *TaskContext, task *models.Task) error {
+	domain, err := utils.GetDomain(task.URL)
+	if err != nil {
+		return fmt.Errorf("failed to parse domain: %w", err)
+	}
+
+	if err := crawlPage(ctx, tc, task); err != nil {
+		slog.Error("page crawl error", "err", err, "url", task.URL)
+		// Whether a failure is worth retrying is up to its error class. A
+		// task cancelled by shutdown is requeued instead; one that ran past
+		// its deadline is retried like any other timeout.
+		if utils.Retryable(err) && !errors.Is(ctx.Err(), context.Canceled) {
+			tc.Retry(task, err)
+			if err := tc.Politeness.UpdateHostLimit(domain, ctx); err != nil {
+				return err
+			}
+		}
+		return err
+	}
+
+	return tc.Storage.MarkSeen(ctx, task.JobID, task.URL)
+}
+
+func handleStore(ctx context.Context, tc *TaskContext, task *models.Task) error {
+	start := time.Now()
+	data, err := tc.Storage.GetTempByUUID(ctx, task.DataID)
+	if err != nil {
+		return fmt.Errorf("Error getting temp data: %v", err)
+	}
+	if err := tc.Storage.SaveIfNew(ctx, data); err != nil {
+		tc.Metrics.Store.Update(true, time.Since(start))
+		return fmt.Errorf("Error saving data: %v", err)
+	}
+	tc.Metrics.Store.Update(false, time.Since(start))
+	return nil
+}
+
+func fetchRobots(ctx context.Context, tc *TaskContext, task *models.Task) (*models.Task, bool, error) {
+	domain, err := utils.GetDomain(task.URL)
+	if err != nil || domain == "" {
+		return nil, false, fmt.Errorf("invalid domain: %w", err)
+	}
+
+	rules, retry, siteMap, err := tc.HTTPClient.FetchRules(ctx, domain)
+	if err != nil {
+		return nil, retry, err
+	}
+
+	if err := tc.Politeness.SaveRules(ctx, domain, string(rules)); err != nil {
+		return nil, true, err
+	}
+
+	var tasks []*models.Task
+	for _, e := range siteMap {
+		child := task.Child(processPageTask, e.URL, sitemapQueue(e), models.DiscoverySitemap)
+		child.LastMod, child.ChangeFreq, child.Priority = e.LastMod, e.ChangeFreq, e.Priority
+		tasks = append(tasks, child)
+	}
+	tc.Enqueue(tc.AdmitLinks(ctx, task, tasks, false)...)
+
+	return task.Next(processPageTask, queue.MediumPriorityQueue), false, nil
+}
+
+// redirectTask returns the task of a queued redirect target. It takes the
+// place of task in its lane, with the same lineage and depth.
+func redirectTask(task *models.Task, to string) *models.Task {
+	next := task.Next(processPageTask, task.SourceName)
+	next.URL, next.Discovery = to, models.DiscoveryRedirect
+	return next
+}
+
+// sitemapQueue returns the queue of a page listed in a sitemap: the high
+// priority queue unless the sitemap ranks it below the default priority.
+func sitemapQueue(e sitemap.Entry) string {
+	if e.Priority < sitemap.DefaultPriority {
+		return queue.MediumPriorityQueue
+	}
+	return queue.HighPriorityQueue
+}
+
+func crawlPage(ctx context.Context, tc *TaskContext, task *models.Task) error {
+	timer := time.Now()
+	domain, err := utils.GetDomain(task.URL)
+	if err != nil {
+		return fmt.Errorf("invalid URL: %w", err)
+	}
+
+	rules, err := getDomainRules(ctx, tc, domain, task)
+	if err != nil {
+		return err
+	}
+
+	if !isAllowedByRobotsTxt(task.URL, identity.FromContext(ctx).Token(), rules) {
+		return fmt.Errorf("%w for domain: %v", utils.ErrRobotsDisallowed, domain)
+	}
+
+	client := newConditionalClient(tc.HTTPClient, task.URL, previousMetadata(ctx, tc, task))
+	crawlResult, err := fetchPage(ctx, client, task.URL, domain)
+	unchanged := client.unchanged()
+	redirectTo, redirected := client.queuedRedirect()
+	if unchanged || redirected {
+		err = nil
+	}
+	tc.Metrics.Crawler.Update(err != nil, time.Since(timer))
+	// A queued redirect is not a page of the job; its target is counted
+	// when it is crawled.
+	if task.JobID != "" && !redirected {
+		tc.Metrics.Jobs.ObservePage(task.JobID, err != nil)
+	}
+	if err != nil {
+		return fmt.Errorf("crawl failed: %w", err)
+	}
+
+	// The target of a redirect is crawled and deduplicated as a page of its
+	// own.
+	if redirected {
+		tc.Enqueue(tc.AdmitLinks(ctx, task, []*models.Task{redirectTask(task, redirectTo)}, false)...)
+		return nil
+	}
+
+	// An unchanged page keeps its stored content; only the crawl time and
+	// status are updated.
+	if unchanged {
+		tc.Metrics.Crawler.ObserveUnchanged()
+		if err := tc.Storage.MarkUnchanged(ctx, task.URL, time.Now()); err != nil {
+			return fmt.Errorf("%w: %w", utils.ErrRetryLater, err)
+		}
+		return nil
+	}
+
+	if crawlResult.PageData != nil {
+		client.annotate(&crawlResult.PageData.Metadata)
+	}
+	if err := processCrawledData(ctx, tc, crawlResult, task); err != nil {
+		return fmt.Errorf("processing crawled data failed: %w", err)
+	}
+
+	return nil
+}
+
+func processCrawledData(ctx context.Context, tc *TaskContext, result *crawler.CrawlResult, task *models.Task) error {
+	if result.PageData != nil {
+		result.PageData.Metadata.SetLineage(task)
+	}
+	dataID, err := tc.Storage.SaveTempWithUUID(ctx, result.PageData)
+	if err != nil {
+		return err
+	}
+
+	store := models.NewTask(storeDataTask, task.URL, queue.StoreQueue, dataID)
+	store.JobID = task.JobID
+
+	var links []*models.Task
+	for _, childURL := range result.Urls {
+		if childURL != "" {
+			links = append(links, task.Child(processPageTask, childURL, queue.MediumPriorityQueue, models.DiscoveryLink))
+		}
+	}
+	tc.Enqueue(append([]*models.Task{store}, tc.AdmitLinks(ctx, task, links, true)...)...)
+	return nil
+}
+
+func isAllowedByRobotsTxt(url, agent string, rules *politeness.RateLimitResult) bool {
+	allowed, err := processRateLimiter(url, agent, rules.Rules)
+	if err != nil {
+		return false
+	}
+	return allowed
+}
+
+func processRateLimiter(url, agent, rawRules string) (bool, error) {
+	if rawRules != "" {
+		robotsData, err := robotstxt.FromString(rawRules)
+		if err != nil {
+			return false, err
+		}
+		if robotsData != nil && !robotsData.TestAgent(url, agent) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func getDomainRules(ctx context.Context, tc *TaskContext, domain string, task *models.Task) (*politeness.RateLimitResult, error) {
+	rules, err := tc.Politeness.GetRules(domain, ctx)
+	if err != nil {
+		// A task whose host has no rules yet goes on as a rules task;
+		// one that could not read them is tried again later.
+		if err == redis.Nil {
+			task.Topic = processRulesTask
+			tc.Enqueue(task)
+			return nil, fmt.Errorf("failed to get rules: %w", err)
+		}
+		return nil, fmt.Errorf("%w: failed to get rules: %w", utils.ErrRetryLater, err)
+	}
+
+	return rules, nil
+}
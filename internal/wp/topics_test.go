@@ -0,0 +1,50 @@
+package wp
+
+import (
+	"testing"
+
+	"github.com/NesterovYehor/Crawler/internal/models"
+	"github.com/NesterovYehor/Crawler/internal/queue"
+	"github.com/NesterovYehor/Crawler/internal/sitemap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRobotsAgentMatching(t *testing.T) {
+	rules := "User-agent: *\nDisallow: /private\n\nUser-agent: Bot\nDisallow: /\n"
+
+	allowed, err := processRateLimiter("/public", "Other", rules)
+	require.NoError(t, err)
+	assert.True(t, allowed, "agents without a group use the * group")
+
+	allowed, err = processRateLimiter("/private", "Other", rules)
+	require.NoError(t, err)
+	assert.False(t, allowed)
+
+	allowed, err = processRateLimiter("/public", "Bot", rules)
+	require.NoError(t, err)
+	assert.False(t, allowed, "the agent's own group applies")
+}
+
+func TestSitemapQueue(t *testing.T) {
+	assert.Equal(t, queue.HighPriorityQueue, sitemapQueue(sitemap.Entry{Priority: sitemap.DefaultPriority}))
+	assert.Equal(t, queue.HighPriorityQueue, sitemapQueue(sitemap.Entry{Priority: 1}))
+	assert.Equal(t, queue.MediumPriorityQueue, sitemapQueue(sitemap.Entry{Priority: 0.2}))
+}
+
+func TestRedirectTask(t *testing.T) {
+	seed := models.NewSeedTask(processPageTask, "https://example.com/", queue.HighPriorityQueue, 3)
+	task := seed.Child(processPageTask, "https://example.com/old", queue.HighPriorityQueue, models.DiscoveryLink)
+	task.JobID = "job"
+	task.Retries = 2
+
+	next := redirectTask(task, "https://example.com/new")
+	assert.Equal(t, "https://example.com/new", next.URL)
+	assert.Equal(t, queue.HighPriorityQueue, next.SourceName, "the redirect keeps its lane")
+	assert.Equal(t, task.Depth, next.Depth, "a redirect is not a level deeper")
+	assert.Equal(t, task.ParentURL, next.ParentURL)
+	assert.Equal(t, task.SeedID, next.SeedID)
+	assert.Equal(t, "job", next.JobID)
+	assert.Equal(t, models.DiscoveryRedirect, next.Discovery)
+	assert.Zero(t, next.Retries)
+}
@@ -6,14 +6,10 @@ import (
 	"log/slog"
 	"time"
 
-	"github.com/NesterovYehor/Crawler/internal/crawler"
 	"github.com/NesterovYehor/Crawler/internal/metrics"
 	"github.com/NesterovYehor/Crawler/internal/models"
-	"github.com/NesterovYehor/Crawler/internal/politeness"
 	"github.com/NesterovYehor/Crawler/internal/queue"
 	"github.com/NesterovYehor/Crawler/internal/utils"
-	"github.com/redis/go-redis/v9"
-	"github.com/temoto/robotstxt"
 )
 
 var start = time.Now()
@@ -23,6 +19,9 @@ type Worker struct {
 	pool       *WorkerPool
 	taskSource *queue.Source
 	metrics    *metrics.Metrics
+	load       *laneLoad
+	tc         *TaskContext
+	status     *workerStatus
 }
 
 func newWorker(
@@ -30,28 +29,45 @@ func newWorker(
 	pool *WorkerPool,
 	taskSource *queue.Source,
 	metrics *metrics.Metrics,
+	load *laneLoad,
 ) *Worker {
-	return &Worker{
+	w := &Worker{
 		ID:         id,
 		pool:       pool,
 		taskSource: taskSource,
 		metrics:    metrics,
+		load:       load,
 	}
+	w.tc = newTaskContext(w)
+	return w
 }
 
-func (w *Worker) Start(ctx context.Context) {
+// Start takes tasks until ctx is done. Tasks run on taskCtx, so the one in
+// flight when ctx is done may still finish. It reports whether it stopped
+// because a task panicked.
+func (w *Worker) Start(ctx, taskCtx context.Context) bool {
 	for {
 		select {
 		case <-ctx.Done():
 			slog.Info(fmt.Sprintf("Stoping Worker: %v", w.ID))
-			return
+			return false
 		default:
 			task := w.pool.getNextTask(w, ctx)
 			if task == nil {
 				time.Sleep(time.Millisecond * 50)
+				w.load.addIdle(time.Millisecond * 50)
 				continue
 			}
-			err := w.dispatchTask(ctx, task)
+			started, source := time.Now(), task.SourceName
+			w.status.begin(task)
+			panicked, err := w.runTask(taskCtx, task)
+			if panicked {
+				return true
+			}
+			w.status.end(err != nil)
+			if load, ok := w.pool.load[source]; ok {
+				load.observe(time.Since(started))
+			}
 			if err != nil {
 				slog.Error("error processing task", "err", err, "url", task)
 				continue
@@ -60,196 +76,51 @@ func (w *Worker) Start(ctx context.Context) {
 	}
 }
 
+// dispatchTask runs the handler of a task and acknowledges the task. If the
+// handler panics it returns nothing and the task is left to runTask.
 func (w *Worker) dispatchTask(ctx context.Context, task *models.Task) error {
-	defer func() {
-		w.pool.operationChan.delChan <- task
-	}()
-
-	domain, err := utils.GetDomain(task.URL)
-	if err != nil {
-		return fmt.Errorf("failed to parse domain: %w", err)
-	}
-
-	switch task.Topic {
-	case processRulesTask:
-		exists, err := w.pool.st.ExistsInBF(ctx, "robots:"+domain)
-		if err != nil {
-			w.pool.operationChan.retryChan <- task
-			return nil
-		}
-		if exists {
-			task.Topic = processPageTask
-			w.addToSuccessChannel(task)
-			return nil
-		}
-
-		newTask, retry, err := w.processRobotsTask(ctx, task.URL)
-		if err != nil {
-			slog.Error("robots.txt error", "err", err)
-			if retry {
-				w.pool.operationChan.retryChan <- task
-			} else {
-				if err := w.pool.pm.SaveRules(ctx, domain, ""); err != nil {
-					return err
-				}
-				task.Topic = processPageTask
-				w.addToSuccessChannel(task)
-			}
-			return nil
-		}
-
-		w.addToSuccessChannel(newTask)
-
-	case processPageTask:
-		retry, err := w.processPageTask(ctx, task)
-		if err != nil {
-			slog.Error("page crawl error", "err", err, "url", task.URL)
-			if retry {
-				defer func() { w.pool.operationChan.retryChan <- task }()
-				if err := w.pool.pm.UpdateHostLimit(domain, ctx); err != nil {
-					return err
-				}
-			}
-			return err
-		}
-
-		if err := w.pool.st.AddToBF(ctx, task.URL); err != nil {
-			return err
-		}
-	case storeDataTask:
-		if err := w.processStoreTask(ctx, *task); err != nil {
-			return err
-		}
-
-	}
-
-	return nil
-}
-
-func (w *Worker) processRobotsTask(ctx context.Context, rawURL string) (*models.Task, bool, error) {
-	domain, err := utils.GetDomain(rawURL)
-	if err != nil || domain == "" {
-		return nil, false, fmt.Errorf("invalid domain: %w", err)
-	}
-
-	rules, retry, siteMap, err := w.pool.httpClient.FetchRules(domain)
-	if err != nil {
-		return nil, retry, err
-	}
-
-	if err := w.pool.pm.SaveRules(ctx, domain, string(rules)); err != nil {
-		return nil, true, err
-	}
-
-	var tasks []*models.Task
-	for _, url := range siteMap {
-		if url != "" {
-			tasks = append(tasks, models.NewTask(processPageTask, url, queue.HighPriorityQueue, ""))
-		}
-	}
-	w.pool.operationChan.addChan <- tasks
-
-	return models.NewTask(processPageTask, rawURL, queue.MediumPriorityQueue, ""), false, nil
-}
-
-func (w *Worker) processPageTask(ctx context.Context, task *models.Task) (bool, error) {
-	timer := time.Now()
-	domain, err := utils.GetDomain(task.URL)
-	if err != nil {
-		return false, fmt.Errorf("invalid URL: %w", err)
-	}
-
-	rules, err := w.getDomainRules(domain, task, ctx)
-	if err != nil {
-		return true, err
-	}
-
-	if !w.isAllowedByRobotsTxt(task.URL, rules) {
-		return rules.Allowed, fmt.Errorf("access disallowed by robots.txt for domain: %v", domain)
-	}
-
-	crawlResult, err := crawler.CrawlPage(task.URL, domain, w.pool.httpClient)
-	w.metrics.Crawler.Update(err != nil, time.Since(timer))
-	if err != nil {
-		return crawlResult.Retry, fmt.Errorf("crawl failed: %w", err)
-	}
-
-	if err := w.processCrawledData(ctx, crawlResult, task); err != nil {
-		return false, fmt.Errorf("processing crawled data failed: %w", err)
-	}
-
-	return false, nil
-}
-
-func (w *Worker) processStoreTask(ctx context.Context, task models.Task) error {
-	start := time.Now()
-	data, err := w.pool.st.GetTempByUUID(ctx, task.DataID)
-	if err != nil {
-		return fmt.Errorf("Error getting temp data: %v", err)
-	}
-	if err := w.pool.st.SaveIfNew(ctx, data); err != nil {
-		w.metrics.Store.Update(true, time.Since(start))
-		return fmt.Errorf("Error saving data: %v", err)
-	}
-	w.metrics.Store.Update(false, time.Since(start))
-	return nil
-}
-
-
-func (w *Worker) processCrawledData(ctx context.Context, result *crawler.CrawlResult, task *models.Task) error {
-	dataID, err := w.pool.st.SaveTempWithUUID(ctx, result.PageData)
-	if err != nil {
+	ack, err := w.handle(ctx, task)
+	// A task cut off by the shutdown deadline is requeued as it was.
+	if err != nil && ctx.Err() != nil {
+		w.pool.strand(task)
 		return err
 	}
-
-	var tasks []*models.Task
-	tasks = append(tasks, models.NewTask(storeDataTask, task.URL, queue.StoreQueue, dataID))
-
-	for _, childURL := range result.Urls {
-		if childURL != "" {
-			t := models.NewTask(processPageTask, childURL, queue.MediumPriorityQueue, "")
-			tasks = append(tasks, t)
-		}
+	if ack {
+		w.pool.ack(task)
 	}
-
-	w.pool.operationChan.addChan <- tasks
-	return nil
+	return err
 }
 
-func (w *Worker) addToSuccessChannel(task *models.Task) {
-	w.pool.operationChan.addChan <- []*models.Task{task}
-}
-
-func (w *Worker) isAllowedByRobotsTxt(url string, rules *politeness.RateLimitResult) bool {
-	allowed, err := w.processRateLimiter(url, rules.Rules)
-	if err != nil {
-		return false
+// handle runs the handler of a task and reports whether the task is done
+// with, whether it failed or not.
+func (w *Worker) handle(ctx context.Context, task *models.Task) (bool, error) {
+	if run, err := w.admitJobTask(ctx, task); !run {
+		// A task that could not be parked stays pending for the reclaimer.
+		return err == nil, err
 	}
-	return allowed
-}
 
-func (w *Worker) processRateLimiter(url, rawRules string) (bool, error) {
-	if rawRules != "" {
-		robotsData, err := robotstxt.FromString(rawRules)
-		if err != nil {
+	h, ok := w.pool.handlers[task.Topic]
+	if !ok {
+		if err := w.reject(ctx, task); err != nil {
+			// Left unacknowledged, the task is delivered again later.
 			return false, err
 		}
-		if robotsData != nil && !robotsData.TestAgent(url, "*") {
-			return false, nil
-		}
+		return true, fmt.Errorf("%w: %q", utils.ErrUnknownTopic, task.Topic)
 	}
-	return true, nil
+
+	// The deadline only bounds the handler; a task that runs past it fails
+	// like any other, while one cancelled by shutdown is requeued.
+	taskCtx, cancel := context.WithTimeout(ctx, w.pool.taskTimeout)
+	defer cancel()
+	return true, h.Handle(w.requestContext(taskCtx, task), w.tc, task)
 }
 
-func (w *Worker) getDomainRules(domain string, task *models.Task, ctx context.Context) (*politeness.RateLimitResult, error) {
-	rules, err := w.pool.pm.GetRules(domain, ctx)
-	if err != nil {
-		if err == redis.Nil {
-			task.Topic = processRulesTask
-			w.pool.operationChan.addChan <- []*models.Task{task}
-		}
-		return &politeness.RateLimitResult{Allowed: false}, fmt.Errorf("failed to get rules: %w", err)
+// reject moves a task without a handler to the dead-letter queue, where it
+// can be replayed once a handler for its topic is registered.
+func (w *Worker) reject(ctx context.Context, task *models.Task) error {
+	task.RecordAttempt(fmt.Errorf("%w: %q", utils.ErrUnknownTopic, task.Topic))
+	if err := w.pool.queue.DeadLetter(ctx, []*models.Task{task}); err != nil {
+		return fmt.Errorf("failed to reject task with topic %q: %w", task.Topic, err)
 	}
-
-	return rules, nil
+	return nil
 }
@@ -1,43 +1,59 @@
-local function politness_gate(key)
+-- Delays are kept in milliseconds: the delay field holds that of the "*"
+-- group and delay:<token> that of a product token's group, once it was
+-- worked out. The third element of the reply is 0 while the caller's is not
+-- known yet, and the "*" delay is used instead.
+local function politness_gate(key, token)
     local reply = redis.call("HGETALL", key)
     if not reply or #reply == 0 then
-        redis.log(redis.LOG_WARNING, "Key not found or empty hash: " .. key)
-        return { nil, false }
+        return nil
     end
 
     local data = {}
     for i = 1, #reply, 2 do
         data[reply[i]] = reply[i + 1]
     end
+    if not data["rules"] then
+        return nil
+    end
 
     local tokens_num = tonumber(data["tokens_num"])
     local max_tokens_num = tonumber(data["max_tokens_num"])
     local refill_time = tonumber(data["refill_time"])
     local delay = tonumber(data["delay"])
+    local known = 1
+    if token and token ~= "" then
+        local own = tonumber(data["delay:" .. token])
+        if own then
+            delay = own
+        else
+            known = 0
+        end
+    end
     local current_time = tonumber(redis.call("TIME")[1])
     if not tokens_num or not max_tokens_num or not refill_time or not delay then
         redis.log(redis.LOG_WARNING, "Invalid or missing hash fields: " .. key)
-        return { nil, false }
+        return { false, false, known }
     end
 
     if refill_time > current_time then
-        return { nil, false }
+        return { false, false, known }
     end
     if tokens_num >= max_tokens_num and max_tokens_num ~= 0 then
         redis.log(redis.LOG_WARNING, "TOKENS ARE TOO MUCH: ", tokens_num, max_tokens_num)
         tokens_num = 0
-        refill_time = current_time + delay
+        -- refill_time is in seconds.
+        refill_time = current_time + math.ceil(delay / 1000)
         redis.call("HSET", key, "tokens_num", tokens_num)
         redis.call("HSET", key, "refill_time", refill_time)
-        return { nil, false }
+        return { false, false, known }
     end
     if refill_time < current_time then
         tokens_num = tokens_num + 1
         redis.call("HSET", key, "tokens_num", tokens_num)
-        return { data["rules"], true }
+        return { data["rules"], true, known }
     end
 
-    return nil
+    return { false, false, known }
 end
 
-return politness_gate(KEYS[1])
+return politness_gate(KEYS[1], ARGV[1])
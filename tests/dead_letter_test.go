@@ -0,0 +1,91 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/NesterovYehor/Crawler/internal/config"
+	"github.com/NesterovYehor/Crawler/internal/models"
+	"github.com/NesterovYehor/Crawler/internal/queue"
+	"github.com/NesterovYehor/Crawler/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testDeadLetterQueue(ctx context.Context, t *testing.T, newQueue queueFactory) {
+	q := newQueue(t, &config.Queue{ConsumerID: "test-consumer"})
+
+	exhausted := models.NewTask("crawl_page", "https://example.com/broken", queue.MediumPriorityQueue, "")
+	for range models.MaxRetries {
+		exhausted.RecordAttempt(utils.ErrRetryLater)
+		require.NoError(t, q.Retry(ctx, *exhausted))
+		exhausted.Retries++
+	}
+	other := models.NewTask("crawl_page", "https://other.com/page", queue.MediumPriorityQueue, "")
+	other.Retries = models.MaxRetries
+	other.RecordAttempt(errors.New("boom"))
+	require.NoError(t, q.Add([]*models.Task{other}))
+
+	all, err := q.ListDeadLetters(ctx, queue.DeadLetterFilter{})
+	require.NoError(t, err)
+	require.Len(t, all, 2)
+
+	first, err := q.ListDeadLetters(ctx, queue.DeadLetterFilter{Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, first, 1)
+	next, err := q.ListDeadLetters(ctx, queue.DeadLetterFilter{After: first[0].ID})
+	require.NoError(t, err)
+	require.Len(t, next, 1)
+	assert.Equal(t, all[1].ID, next[0].ID)
+
+	byHost, err := q.ListDeadLetters(ctx, queue.DeadLetterFilter{Host: "example.com"})
+	require.NoError(t, err)
+	require.Len(t, byHost, 1)
+	assert.Equal(t, "https://example.com/broken", byHost[0].Task.URL)
+	assert.Equal(t, utils.ErrorClassRetryLater, byHost[0].ErrorClass)
+	assert.Equal(t, utils.ErrRetryLater.Error(), byHost[0].LastError)
+	assert.Len(t, byHost[0].Task.Attempts, models.MaxRetries)
+
+	replayed, err := q.ReplayDeadLetters(ctx, queue.DeadLetterFilter{ErrorClass: utils.ErrorClassRetryLater}, queue.HighPriorityQueue)
+	require.NoError(t, err)
+	assert.Equal(t, 1, replayed)
+
+	got, err := q.GetTasks(ctx, 1, queue.HighPriorityQueue)
+	require.NoError(t, err)
+	got = nonNilTasks(got)
+	require.Len(t, got, 1)
+	assert.Equal(t, "https://example.com/broken", got[0].URL)
+	assert.Equal(t, 0, got[0].Retries)
+
+	purged, err := q.PurgeDeadLetters(ctx, queue.DeadLetterFilter{Host: "other.com"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+
+	remaining, err := q.ListDeadLetters(ctx, queue.DeadLetterFilter{})
+	require.NoError(t, err)
+	assert.Empty(t, remaining)
+}
+
+func testDeadLetterReplayUsesFrontier(ctx context.Context, t *testing.T, newQueue queueFactory) {
+	q := newQueue(t, &config.Queue{ConsumerID: "test-consumer", HostDelay: time.Minute})
+
+	var dead []*models.Task
+	for _, url := range []string{"https://example.com/1", "https://example.com/2"} {
+		task := models.NewTask("crawl_page", url, queue.HighPriorityQueue, "")
+		task.RecordAttempt(errors.New("boom"))
+		dead = append(dead, task)
+	}
+	require.NoError(t, q.DeadLetter(ctx, dead))
+
+	replayed, err := q.ReplayDeadLetters(ctx, queue.DeadLetterFilter{}, queue.MediumPriorityQueue)
+	require.NoError(t, err)
+	assert.Equal(t, 2, replayed)
+
+	got, err := q.GetTasks(ctx, 10, queue.MediumPriorityQueue)
+	require.NoError(t, err)
+	got = nonNilTasks(got)
+	require.Len(t, got, 1, "replays into the medium lane are spaced by host like new links")
+	assert.Equal(t, queue.MediumPriorityQueue, got[0].SourceName)
+}
@@ -0,0 +1,257 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NesterovYehor/Crawler/internal/config"
+	"github.com/NesterovYehor/Crawler/internal/dns"
+	httpclient "github.com/NesterovYehor/Crawler/internal/http_client"
+	"github.com/NesterovYehor/Crawler/internal/identity"
+	"github.com/NesterovYehor/Crawler/internal/politeness"
+	"github.com/NesterovYehor/Crawler/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+)
+
+var latin1Page = `<meta charset="iso-8859-1"><!--` + strings.Repeat("-", 1100) + `--><p>Café crème</p>`
+
+func TestHTTPFetch(t *testing.T) {
+	const lastModified = "Mon, 02 Jan 2006 15:04:05 GMT"
+	mux := http.NewServeMux()
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, "<html></html>")
+	})
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/page", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/missing", func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
+	mux.HandleFunc("/broken", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	mux.HandleFunc("/limited", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+	mux.HandleFunc("/maintenance", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	mux.HandleFunc("/cached", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` && r.Header.Get("If-Modified-Since") == lastModified {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Last-Modified", lastModified)
+		io.WriteString(w, "<html></html>")
+	})
+	mux.HandleFunc("/whoami", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Header.Get("User-Agent")+"|"+r.Header.Get("From"))
+	})
+	mux.HandleFunc("/video", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "video/mp4")
+		w.Write(make([]byte, 1<<20))
+	})
+	mux.HandleFunc("/large", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(strings.Repeat("a", 64)))
+	})
+	mux.HandleFunc("/cp1251", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=windows-1251")
+		body, _ := charmap.Windows1251.NewEncoder().String(`<a href="/привет">Привет</a>`)
+		io.WriteString(w, body)
+	})
+	mux.HandleFunc("/sjis", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		body, _ := japanese.ShiftJIS.NewEncoder().String(`<meta charset="Shift_JIS"><p>こんにちは</p>`)
+		io.WriteString(w, body)
+	})
+	mux.HandleFunc("/latin1", func(w http.ResponseWriter, r *http.Request) {
+		// The head is ASCII; the first accented byte comes after the
+		// kilobyte that is sniffed.
+		w.Header().Set("Content-Type", "text/html")
+		body, _ := charmap.ISO8859_1.NewEncoder().String(latin1Page)
+		io.WriteString(w, body)
+	})
+	mux.HandleFunc("/undeclared", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		io.WriteString(w, "<p>Grüße</p>")
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	client := httpclient.NewHTTPClient(1)
+	ctx := context.Background()
+
+	t.Run("redirect", func(t *testing.T) {
+		res, err := client.Fetch(ctx, srv.URL+"/moved")
+		require.NoError(t, err)
+		defer res.Close()
+		assert.Equal(t, srv.URL+"/moved", res.URL)
+		assert.Equal(t, srv.URL+"/page", res.FinalURL)
+		assert.Equal(t, []string{srv.URL + "/moved"}, res.Redirects)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, "text/html; charset=utf-8", res.ContentType)
+		assert.Positive(t, res.Duration)
+		body, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "<html></html>", string(body))
+	})
+
+	t.Run("client_error", func(t *testing.T) {
+		res, err := client.Fetch(ctx, srv.URL+"/missing")
+		require.Error(t, err)
+		assert.False(t, errors.Is(err, utils.ErrRetryLater))
+		assert.Equal(t, http.StatusNotFound, res.StatusCode)
+		assert.Nil(t, res.Body)
+	})
+
+	t.Run("server_error", func(t *testing.T) {
+		res, err := client.Fetch(ctx, srv.URL+"/broken")
+		require.ErrorIs(t, err, utils.ErrRetryLater)
+		assert.Equal(t, http.StatusBadGateway, res.StatusCode)
+		assert.Equal(t, utils.ErrorClassServerError, utils.ClassifyError(err))
+	})
+
+	t.Run("retry_after", func(t *testing.T) {
+		res, err := client.Fetch(ctx, srv.URL+"/limited")
+		require.ErrorIs(t, err, utils.ErrRetryLater)
+		assert.Equal(t, http.StatusTooManyRequests, res.StatusCode)
+		assert.Equal(t, utils.ErrorClassRateLimited, utils.ClassifyError(err))
+		d, ok := utils.RetryAfter(err)
+		require.True(t, ok)
+		assert.Equal(t, 2*time.Minute, d)
+
+		_, err = client.Fetch(ctx, srv.URL+"/maintenance")
+		d, ok = utils.RetryAfter(err)
+		require.True(t, ok)
+		assert.InDelta(t, time.Hour, d, float64(5*time.Second), "an HTTP date is relative to now")
+	})
+
+	t.Run("conditional", func(t *testing.T) {
+		res, err := client.Fetch(ctx, srv.URL+"/cached")
+		require.NoError(t, err)
+		res.Close()
+		assert.Equal(t, `"v1"`, res.ETag)
+		assert.Equal(t, lastModified, res.LastModified)
+
+		res, err = client.FetchIfModified(ctx, srv.URL+"/cached", httpclient.Validators{ETag: res.ETag, LastModified: res.LastModified})
+		require.ErrorIs(t, err, utils.ErrNotModified)
+		assert.Equal(t, http.StatusNotModified, res.StatusCode)
+		assert.Nil(t, res.Body)
+	})
+
+	t.Run("identity", func(t *testing.T) {
+		for ctx, want := range map[context.Context]string{
+			ctx: "MyCrawler/1.0|",
+			identity.NewContext(ctx, identity.Identity{ProductToken: "Bot", ContactURL: "https://example.com/bot", From: "bot@example.com"}): "Bot/1.0 (+https://example.com/bot)|bot@example.com",
+		} {
+			res, err := client.Fetch(ctx, srv.URL+"/whoami")
+			require.NoError(t, err)
+			body, err := io.ReadAll(res.Body)
+			res.Close()
+			require.NoError(t, err)
+			assert.Equal(t, want, string(body))
+		}
+	})
+
+	t.Run("limits", func(t *testing.T) {
+		limited := httpclient.NewClient(&httpclient.ClientOpts{IdleConns: 1, Response: config.Response{
+			MaxBodyBytes:        16,
+			AllowedContentTypes: []string{"text/*"},
+		}})
+
+		res, err := limited.Fetch(ctx, srv.URL+"/video")
+		require.ErrorIs(t, err, utils.ErrInvalidContentType)
+		assert.Equal(t, utils.ErrorClassContentType, utils.ClassifyError(err))
+		assert.Nil(t, res.Body)
+
+		res, err = limited.Fetch(ctx, srv.URL+"/large")
+		require.NoError(t, err)
+		body, err := io.ReadAll(res.Body)
+		res.Close()
+		require.NoError(t, err)
+		assert.Len(t, body, 16)
+		assert.True(t, res.Truncated())
+
+		res, err = limited.Fetch(ctx, srv.URL+"/page")
+		require.NoError(t, err)
+		_, err = io.ReadAll(res.Body)
+		res.Close()
+		require.NoError(t, err)
+		assert.False(t, res.Truncated())
+	})
+
+	t.Run("charset", func(t *testing.T) {
+		for path, want := range map[string]struct{ charset, body string }{
+			"/cp1251":     {"windows-1251", `<a href="/привет">Привет</a>`},
+			"/sjis":       {"shift_jis", `<meta charset="Shift_JIS"><p>こんにちは</p>`},
+			"/undeclared": {"utf-8", "<p>Grüße</p>"},
+			"/latin1":     {"windows-1252", latin1Page},
+		} {
+			res, err := client.Fetch(ctx, srv.URL+path)
+			require.NoError(t, err)
+			body, err := io.ReadAll(res.Body)
+			res.Close()
+			require.NoError(t, err)
+			assert.Equal(t, want.charset, res.Charset, path)
+			assert.Equal(t, want.body, string(body), path)
+		}
+	})
+
+	t.Run("origins", func(t *testing.T) {
+		limited := httpclient.NewClient(&httpclient.ClientOpts{
+			IdleConns: 1,
+			Resolver:  dns.NewResolver(&config.DNS{}),
+			Origins:   politeness.NewOriginLimiter(&config.Politeness{ByIP: true, MaxConcurrent: 1}, nil),
+		})
+
+		res, err := limited.Fetch(ctx, srv.URL+"/page")
+		require.NoError(t, err)
+
+		// The open body holds the origin's only slot.
+		waitCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		_, err = limited.Fetch(waitCtx, srv.URL+"/page")
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		// So it does for another name of the server, whatever address the
+		// name resolves to first: the limit is on the IP dialed.
+		waitCtx, cancel = context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		_, err = limited.Fetch(waitCtx, strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)+"/page")
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+
+		res.Close()
+		res, err = limited.Fetch(ctx, srv.URL+"/missing")
+		require.Error(t, err)
+		assert.Nil(t, res.Body)
+		// An error response gives the slot back at once.
+		res, err = limited.Fetch(ctx, srv.URL+"/page")
+		require.NoError(t, err)
+		res.Close()
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		_, err := client.Fetch(ctx, srv.URL+"/slow")
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
@@ -0,0 +1,97 @@
+package tests
+
+import (
+	"context"
+	"testing"
+
+	"github.com/NesterovYehor/Crawler/internal/jobs"
+	"github.com/NesterovYehor/Crawler/internal/models"
+	"github.com/NesterovYehor/Crawler/internal/queue"
+	"github.com/NesterovYehor/Crawler/tests/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisJobStore(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client, cleanUp, err := testutils.RunRedis(ctx)
+	require.NoError(t, err)
+	defer func() {
+		if err := cleanUp(); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}()
+
+	testJobStore(ctx, t, jobs.NewStore(client))
+
+	job := &models.Job{MaxPages: 5000}
+	require.NoError(t, jobs.NewStore(client).Create(ctx, job))
+	info, err := client.BFInfoCapacity(ctx, jobs.SeenFilter(job.ID)).Result()
+	require.NoError(t, err)
+	assert.EqualValues(t, 5000, info.Capacity, "the seen-set is sized from the page budget")
+	require.NoError(t, jobs.NewStore(client).SetStatus(ctx, job.ID, models.JobCancelled))
+	n, err := client.Exists(ctx, jobs.SeenFilter(job.ID)).Result()
+	require.NoError(t, err)
+	assert.Zero(t, n, "cancelling a job deletes its seen-set")
+}
+
+func TestMemoryJobStore(t *testing.T) {
+	testJobStore(context.Background(), t, jobs.NewMemoryStore())
+}
+
+func testJobStore(ctx context.Context, t *testing.T, store jobs.Interface) {
+	job := &models.Job{Name: "docs", Seeds: []string{"https://example.com"}, MaxPages: 2}
+	require.NoError(t, store.Create(ctx, job))
+	require.NotEmpty(t, job.ID)
+	assert.Error(t, store.Create(ctx, &models.Job{ID: job.ID}), "job IDs are unique")
+
+	got, err := store.Get(ctx, job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "docs", got.Name)
+	assert.Equal(t, models.JobActive, got.Status)
+
+	_, err = store.Get(ctx, "missing")
+	assert.ErrorIs(t, err, jobs.ErrJobNotFound)
+
+	require.NoError(t, store.SetStatus(ctx, job.ID, models.JobPaused))
+	task := models.NewTask("crawl_page", "https://example.com/a", queue.MediumPriorityQueue, "")
+	task.JobID = job.ID
+	require.NoError(t, store.Park(ctx, job.ID, []*models.Task{task}))
+
+	require.NoError(t, store.SetStatus(ctx, job.ID, models.JobActive))
+	parked, err := store.Unpark(ctx, job.ID)
+	require.NoError(t, err)
+	require.Len(t, parked, 1)
+	assert.Equal(t, "https://example.com/a", parked[0].URL)
+	assert.Equal(t, job.ID, parked[0].JobID)
+	parked, err = store.Unpark(ctx, job.ID)
+	require.NoError(t, err)
+	assert.Empty(t, parked)
+
+	for want := int64(1); want <= 2; want++ {
+		n, err := store.CountPage(ctx, job.ID)
+		require.NoError(t, err)
+		assert.Equal(t, want, n)
+	}
+	assert.False(t, job.PageBudgetLeft(2))
+
+	require.NoError(t, store.SetStatus(ctx, job.ID, models.JobCancelled))
+	assert.Error(t, store.SetStatus(ctx, job.ID, models.JobActive), "a cancelled job can not be resumed")
+	n, err := store.CountPage(ctx, job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n, "cancelling a job deletes its page count")
+
+	all, err := store.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, all, 1)
+	assert.Equal(t, models.JobCancelled, all[0].Status)
+}
+
+func TestJobScope(t *testing.T) {
+	job := &models.Job{Scope: []string{"example.com"}}
+	assert.True(t, job.InScope("https://example.com/a"))
+	assert.True(t, job.InScope("https://docs.example.com/a"))
+	assert.False(t, job.InScope("https://notexample.com/a"))
+	assert.True(t, (&models.Job{}).InScope("https://any.org/"))
+}
@@ -38,12 +38,24 @@ func TestCassandraStore(t *testing.T) {
 	defer ms.Close()
 
 	testData := models.Metadata{
-		URL:        "test_url",
-		Host:       "test_host",
-		HTMLHash:   "ajsfklasjfkladsjfkla",
-		Latency:    19,
-		Timestamp:  time.Now().Truncate(time.Millisecond),
-		ContentLen: 3,
+		URL:          "test_url",
+		Host:         "test_host",
+		HTMLHash:     "ajsfklasjfkladsjfkla",
+		Latency:      19,
+		Timestamp:    time.Now().Truncate(time.Millisecond),
+		ContentLen:   3,
+		Depth:        2,
+		ParentURL:    "parent_url",
+		SeedID:       "seed",
+		Discovery:    models.DiscoveryLink,
+		JobID:        "job",
+		ETag:         `"v1"`,
+		LastModified: "Mon, 02 Jan 2006 15:04:05 GMT",
+		FetchStatus:  models.FetchStatusFetched,
+		Truncated:    true,
+		Charset:      "windows-1251",
+		FinalURL:     "https://example.com/final",
+		Redirects:    []string{"test_url", "https://example.com/moved"},
 	}
 
 	assert.NoError(t, ms.Save(ctx, testData))
@@ -56,5 +68,33 @@ func TestCassandraStore(t *testing.T) {
 	assert.Equal(t, testData.Host, data[0].Host)
 	assert.Equal(t, testData.HTMLHash, data[0].HTMLHash)
 	assert.Equal(t, testData.ContentLen, data[0].ContentLen)
+	assert.Equal(t, testData.Depth, data[0].Depth)
+	assert.Equal(t, testData.ParentURL, data[0].ParentURL)
+	assert.Equal(t, testData.SeedID, data[0].SeedID)
+	assert.Equal(t, testData.Discovery, data[0].Discovery)
+	assert.Equal(t, testData.JobID, data[0].JobID)
+	assert.Equal(t, testData.ETag, data[0].ETag)
+	assert.Equal(t, testData.LastModified, data[0].LastModified)
+	assert.Equal(t, testData.Truncated, data[0].Truncated)
+	assert.Equal(t, testData.Charset, data[0].Charset)
+	assert.Equal(t, testData.FinalURL, data[0].FinalURL)
+	assert.Equal(t, testData.Redirects, data[0].Redirects)
 	assert.WithinDuration(t, testData.Timestamp, data[0].Timestamp, time.Second)
+
+	prev, err := ms.GetByURL(ctx, testData.URL)
+	require.NoError(t, err)
+	require.NotNil(t, prev)
+	assert.Equal(t, testData.ETag, prev.ETag)
+
+	recrawled := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	require.NoError(t, ms.MarkUnchanged(ctx, testData.URL, recrawled))
+	prev, err = ms.GetByURL(ctx, testData.URL)
+	require.NoError(t, err)
+	assert.Equal(t, models.FetchStatusUnchanged, prev.FetchStatus)
+	assert.Equal(t, testData.HTMLHash, prev.HTMLHash)
+	assert.WithinDuration(t, recrawled, prev.Timestamp, time.Second)
+
+	missing, err := ms.GetByURL(ctx, "missing_url")
+	require.NoError(t, err)
+	assert.Nil(t, missing)
 }
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/NesterovYehor/Crawler/internal/config"
+	"github.com/NesterovYehor/Crawler/internal/identity"
 	"github.com/NesterovYehor/Crawler/internal/politeness"
 	"github.com/NesterovYehor/Crawler/internal/storage"
 	"github.com/NesterovYehor/Crawler/internal/storage/cache"
@@ -85,6 +86,33 @@ func TestPolitenessManager(t *testing.T) {
 		assert.True(t, r.Allowed)
 		assert.Equal(t, tc.rules, r.Rules)
 	}
+
+	// The rules were saved under one token; each reader gets the crawl
+	// delay of its own group.
+	rules := "User-agent: slowbot\nCrawl-delay: 10\n\nUser-agent: *\nDisallow: /private/\n"
+	fast := identity.NewContext(ctx, identity.Identity{ProductToken: "fastbot"})
+	slow := identity.NewContext(ctx, identity.Identity{ProductToken: "slowbot"})
+	require.NoError(t, pm.SaveRules(fast, "slow.example.com", rules))
+	delay, err := redisClient.HGet(ctx, "slow.example.com", "delay").Int64()
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, delay, "the cached delay is that of the * group")
+
+	r, err := pm.GetRules("slow.example.com", slow)
+	require.NoError(t, err)
+	assert.True(t, r.Allowed)
+	slowDelay, err := redisClient.HGet(ctx, "slow.example.com", "delay:slowbot").Int64()
+	require.NoError(t, err)
+	assert.EqualValues(t, 10000, slowDelay, "slowbot's delay is worked out once and kept in milliseconds")
+	require.NoError(t, pm.UpdateHostLimit("slow.example.com", slow))
+	r, err = pm.GetRules("slow.example.com", slow)
+	require.NoError(t, err)
+	assert.False(t, r.Allowed)
+	refill, err := redisClient.HGet(ctx, "slow.example.com", "refill_time").Int64()
+	require.NoError(t, err)
+	assert.InDelta(t, time.Now().Unix()+10, refill, 2, "slowbot waits out its own crawl delay of 10s")
+
+	_, err = pm.GetRules("unknown.example.com", ctx)
+	assert.Equal(t, redis.Nil, err, "a host without rules asks for them to be fetched")
 }
 
 func loadScripts(client *redis.Client, cfg *config.Scripts) error {
@@ -113,3 +141,32 @@ func loadScripts(client *redis.Client, cfg *config.Scripts) error {
 	}
 	return nil
 }
+
+func TestSharedOriginLimiter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client, cleanUp, err := testutils.RunRedis(ctx)
+	require.NoError(t, err)
+	defer func() {
+		if err := cleanUp(); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}()
+
+	// Two limiters stand for two crawler processes.
+	cfg := &config.Politeness{ByIP: true, MaxConcurrent: 1}
+	first := politeness.NewOriginLimiter(cfg, client)
+	second := politeness.NewOriginLimiter(cfg, client)
+
+	release, err := first.Acquire(ctx, "host:a", "ip:10.0.0.1")
+	require.NoError(t, err)
+	waitCtx, cancelWait := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancelWait()
+	_, err = second.Acquire(waitCtx, "host:b", "ip:10.0.0.1")
+	assert.ErrorIs(t, err, context.DeadlineExceeded, "the IP's slot is taken by the other process")
+
+	release()
+	release2, err := second.Acquire(ctx, "host:b", "ip:10.0.0.1")
+	require.NoError(t, err)
+	release2()
+}
@@ -3,15 +3,15 @@ package tests
 import (
 	"context"
 	"fmt"
-	"io"
-	"strings"
 	"testing"
 	"time"
 
 	"github.com/NesterovYehor/Crawler/internal/config"
+	httpclient "github.com/NesterovYehor/Crawler/internal/http_client"
 	"github.com/NesterovYehor/Crawler/internal/models"
 	"github.com/NesterovYehor/Crawler/internal/politeness"
 	"github.com/NesterovYehor/Crawler/internal/queue"
+	"github.com/NesterovYehor/Crawler/internal/sitemap"
 	"github.com/NesterovYehor/Crawler/internal/storage"
 	"github.com/NesterovYehor/Crawler/internal/utils"
 	"github.com/NesterovYehor/Crawler/internal/wp"
@@ -37,23 +37,23 @@ func TestWorkerPoolProcesses(t *testing.T) {
 		require.NoError(t, q.Add(tesdMessage))
 
 		httpMock := mocks.MockHTTPClient{
-			FetchBodyFn: func(rawURL string) (io.ReadCloser, error) {
+			FetchFn: func(ctx context.Context, rawURL string) (*httpclient.FetchResult, error) {
 				switch rawURL {
 				case "https://example.com/high":
-					return io.NopCloser(strings.NewReader(`
+					return mocks.HTMLResult(rawURL, `
                         <html>
                             <body>
                                 <a href="https://example.com/med">Medium Link</a>
                                 <a href="https://example.com/low">Low Link</a>
                             </body>
                         </html>
-                    `)), nil
+                    `), nil
 				case "https://example.com/med":
-					return io.NopCloser(strings.NewReader("<html><body>Page 2 content</body></html>")), nil
+					return mocks.HTMLResult(rawURL, "<html><body>Page 2 content</body></html>"), nil
 				case "https://example.com/low":
 					select {
 					case <-retrySignal:
-						return io.NopCloser(strings.NewReader("<html><body>Page 3 content</body></html>")), nil
+						return mocks.HTMLResult(rawURL, "<html><body>Page 3 content</body></html>"), nil
 					default:
 						retrySignal <- struct{}{}
 						return nil, utils.ErrRetryLater
@@ -62,7 +62,7 @@ func TestWorkerPoolProcesses(t *testing.T) {
 					return nil, fmt.Errorf("unknown URL: %s", rawURL)
 				}
 			},
-			FetchRulesFn: func(baseURL string) ([]byte, bool, []string, error) {
+			FetchRulesFn: func(ctx context.Context, baseURL string) ([]byte, bool, []sitemap.Entry, error) {
 				switch baseURL {
 				case "example.com":
 					rules := []byte(`User-agent: * Allow: /`)
@@ -90,10 +90,12 @@ func TestWorkerPoolProcesses(t *testing.T) {
 
 		<-newCtx.Done()
 
-		for _, source := range queue.FallbackOrder {
-			isEmpty, err := q.IsEmpty(source, context.Background()) // Use a new context for final checks
+		lanes, err := queue.Lanes(cfg.Queue.Lanes, &cfg.Workers)
+		require.NoError(t, err)
+		for _, lane := range lanes {
+			isEmpty, err := q.IsEmpty(lane.Name, context.Background()) // Use a new context for final checks
 			assert.NoError(t, err)
-			assert.True(t, isEmpty, "queue "+source+" should be empty")
+			assert.True(t, isEmpty, "queue "+lane.Name+" should be empty")
 		}
 
 		// 2. Check that the correct data was saved to storage.
@@ -0,0 +1,144 @@
+package tests
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/NesterovYehor/Crawler/internal/config"
+	httpclient "github.com/NesterovYehor/Crawler/internal/http_client"
+	"github.com/NesterovYehor/Crawler/internal/jobs"
+	"github.com/NesterovYehor/Crawler/internal/models"
+	"github.com/NesterovYehor/Crawler/internal/profiles"
+	"github.com/NesterovYehor/Crawler/tests/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestProfiles(t *testing.T) {
+	// other is a different host:port; it records what reaches it.
+	var mu sync.Mutex
+	var leaked http.Header
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		leaked = r.Header.Clone()
+		mu.Unlock()
+		io.WriteString(w, "other")
+	}))
+	defer other.Close()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/private", func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "crawler" || pass != "hunter2" || r.Header.Get("X-Crawler-Key") != "abc" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		io.WriteString(w, "secret")
+	})
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: r.URL.Query().Get("as"), Path: "/"})
+		http.Redirect(w, r, "/whoami", http.StatusFound)
+	})
+	mux.HandleFunc("/whoami", func(w http.ResponseWriter, r *http.Request) {
+		c, err := r.Cookie("session")
+		if err != nil {
+			io.WriteString(w, "anonymous")
+			return
+		}
+		io.WriteString(w, c.Value)
+	})
+	mux.HandleFunc("/away", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, other.URL+"/landing", http.StatusFound)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	t.Setenv("CRAWLER_TEST_PASSWORD", "hunter2")
+	set, err := profiles.New([]config.Profile{{
+		// Both test servers listen on 127.0.0.1, only their ports differ.
+		Domain:  "127.0.0.1",
+		Headers: map[string]string{"X-Crawler-Key": "abc"},
+		Basic:   config.BasicAuth{Username: "crawler", Password: config.Secret{Env: "CRAWLER_TEST_PASSWORD"}},
+		Cookies: true,
+	}}, nil)
+	require.NoError(t, err)
+	client := httpclient.NewClient(&httpclient.ClientOpts{IdleConns: 1, Profiles: set})
+
+	body := func(ctx context.Context, rawURL string) string {
+		res, err := client.Fetch(ctx, rawURL)
+		require.NoError(t, err)
+		defer res.Close()
+		b, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		return string(b)
+	}
+
+	t.Run("credentials", func(t *testing.T) {
+		assert.Equal(t, "secret", body(context.Background(), srv.URL+"/private"))
+		res, err := httpclient.NewHTTPClient(1).Fetch(context.Background(), srv.URL+"/private")
+		require.Error(t, err)
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	})
+
+	t.Run("cookies", func(t *testing.T) {
+		jobA := profiles.WithJob(context.Background(), "a")
+		jobB := profiles.WithJob(context.Background(), "b")
+		assert.Equal(t, "alice", body(jobA, srv.URL+"/login?as=alice"), "the cookie set by a redirect is sent on")
+		assert.Equal(t, "alice", body(jobA, srv.URL+"/whoami"))
+		assert.Equal(t, "anonymous", body(jobB, srv.URL+"/whoami"))
+	})
+
+	t.Run("redirect", func(t *testing.T) {
+		assert.Equal(t, "other", body(context.Background(), srv.URL+"/away"))
+		mu.Lock()
+		defer mu.Unlock()
+		require.NotNil(t, leaked)
+		assert.Empty(t, leaked.Get("Authorization"))
+		assert.Empty(t, leaked.Get("X-Crawler-Key"))
+	})
+}
+
+func TestSharedCookieJar(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client, cleanUp, err := testutils.RunRedis(ctx)
+	require.NoError(t, err)
+	defer func() {
+		if err := cleanUp(); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}()
+
+	store := jobs.NewStore(client)
+	job := &models.Job{}
+	require.NoError(t, store.Create(ctx, job))
+
+	// Two sets stand for two crawler processes.
+	cfg := []config.Profile{{Domain: "example.com", Cookies: true}}
+	first, err := profiles.New(cfg, client)
+	require.NoError(t, err)
+	second, err := profiles.New(cfg, client)
+	require.NoError(t, err)
+	jobCtx := profiles.WithJob(ctx, job.ID)
+	u, _ := url.Parse("https://example.com/account")
+
+	first.Jar(jobCtx).SetCookies(u, []*http.Cookie{
+		{Name: "session", Value: "1", MaxAge: 3600},
+		{Name: "theme", Value: "dark"},
+	})
+	first.Jar(jobCtx).SetCookies(u, []*http.Cookie{{Name: "theme", MaxAge: -1}})
+	cookies := second.Jar(jobCtx).Cookies(u)
+	require.Len(t, cookies, 1, "the other worker sees the job's cookies")
+	assert.Equal(t, "session", cookies[0].Name)
+	assert.Empty(t, second.Jar(profiles.WithJob(ctx, "other")).Cookies(u), "jobs do not share cookies")
+
+	require.NoError(t, store.SetStatus(ctx, job.ID, models.JobCancelled))
+	exists, err := client.Exists(ctx, jobs.CookieJar(job.ID)).Result()
+	require.NoError(t, err)
+	assert.Zero(t, exists, "the jar ends with the job")
+}
@@ -0,0 +1,94 @@
+package tests
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/NesterovYehor/Crawler/internal/config"
+	httpclient "github.com/NesterovYehor/Crawler/internal/http_client"
+	"github.com/NesterovYehor/Crawler/internal/proxy"
+	"github.com/NesterovYehor/Crawler/tests/testutils"
+	"github.com/NesterovYehor/Crawler/tests/testutils/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxyPool(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, "direct")
+	}))
+	defer origin.Close()
+	ctx := context.Background()
+
+	fetch := func(t *testing.T, client httpclient.Interface) (string, error) {
+		res, err := client.Fetch(ctx, origin.URL)
+		if err != nil {
+			return "", err
+		}
+		defer res.Close()
+		body, err := io.ReadAll(res.Body)
+		return string(body), err
+	}
+
+	t.Run("round_robin", func(t *testing.T) {
+		a, hitsA := testutils.StartHTTPProxy(t, "a")
+		b, hitsB := testutils.StartHTTPProxy(t, "b")
+		pool, err := proxy.NewPool(&config.Proxies{URLs: []string{a, b}, Strategy: proxy.RoundRobin}, mocks.NewNoopMetrics().Proxy)
+		require.NoError(t, err)
+		client := httpclient.NewClient(&httpclient.ClientOpts{IdleConns: 1, Proxies: pool})
+
+		var bodies []string
+		for range 4 {
+			body, err := fetch(t, client)
+			require.NoError(t, err)
+			bodies = append(bodies, body)
+		}
+		assert.Equal(t, []string{"via a", "via b", "via a", "via b"}, bodies)
+		assert.EqualValues(t, 2, hitsA.Load())
+		assert.EqualValues(t, 2, hitsB.Load())
+	})
+
+	t.Run("socks5", func(t *testing.T) {
+		socks, conns := testutils.StartSOCKS5(t)
+		pool, err := proxy.NewPool(&config.Proxies{URLs: []string{socks}}, mocks.NewNoopMetrics().Proxy)
+		require.NoError(t, err)
+		client := httpclient.NewClient(&httpclient.ClientOpts{IdleConns: 1, Proxies: pool})
+
+		body, err := fetch(t, client)
+		require.NoError(t, err)
+		assert.Equal(t, "direct", body)
+		assert.EqualValues(t, 1, conns.Load())
+	})
+
+	t.Run("ejects_failing_proxy", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		dead := "http://" + ln.Addr().String()
+		ln.Close()
+		alive, hits := testutils.StartHTTPProxy(t, "alive")
+
+		pool, err := proxy.NewPool(&config.Proxies{
+			URLs:        []string{dead, alive},
+			Strategy:    proxy.RoundRobin,
+			MaxFailures: 2,
+			Cooldown:    time.Minute,
+		}, mocks.NewNoopMetrics().Proxy)
+		require.NoError(t, err)
+		client := httpclient.NewClient(&httpclient.ClientOpts{IdleConns: 1, Proxies: pool})
+
+		var failures int
+		for range 6 {
+			if _, err := fetch(t, client); err != nil {
+				failures++
+			}
+		}
+		assert.Equal(t, 2, failures, "the dead proxy is ejected after max_failures")
+		assert.EqualValues(t, 4, hits.Load())
+	})
+}
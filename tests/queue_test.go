@@ -2,17 +2,124 @@ package tests
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/NesterovYehor/Crawler/internal/config"
 	"github.com/NesterovYehor/Crawler/internal/models"
 	"github.com/NesterovYehor/Crawler/internal/queue"
+	"github.com/NesterovYehor/Crawler/internal/retry"
+	"github.com/NesterovYehor/Crawler/internal/utils"
 	"github.com/NesterovYehor/Crawler/tests/testutils"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
-func TestQueueLifecycle(t *testing.T) {
+type queueFactory func(t *testing.T, cfg *config.Queue) queue.Interface
+
+type queueScenario func(ctx context.Context, t *testing.T, newQueue queueFactory)
+
+var queueScenarios = map[string]queueScenario{
+	"lifecycle":    testQueueLifecycle,
+	"reclaim":      testQueueReclaimsStaleTasks,
+	"frontier":     testQueueFrontierSchedulesHostsFairly,
+	"dead_letters": testDeadLetterQueue,
+	"replay":       testDeadLetterReplayUsesFrontier,
+	"retries":      testQueuePromotesDueRetries,
+	"length":       testQueueLength,
+	"purge_job":    testQueuePurgesJob,
+}
+
+func TestRedisQueue(t *testing.T) {
+	for name, scenario := range queueScenarios {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			client, cleanUp, err := testutils.RunRedis(ctx)
+			require.NoError(t, err)
+			defer func() {
+				if err := cleanUp(); err != nil {
+					t.Fatalf(err.Error())
+				}
+			}()
+
+			scenario(ctx, t, func(t *testing.T, cfg *config.Queue) queue.Interface {
+				q, err := queue.NewQueue(ctx, client, cfg)
+				require.NoError(t, err)
+				return q
+			})
+		})
+	}
+}
+
+func TestMemoryQueue(t *testing.T) {
+	for name, scenario := range queueScenarios {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			scenario(ctx, t, func(t *testing.T, cfg *config.Queue) queue.Interface {
+				cfg.Backend = queue.BackendMemory
+				q, err := queue.New(ctx, nil, cfg)
+				require.NoError(t, err)
+				return q
+			})
+		})
+	}
+}
+
+// Only Redis queues share their streams between consumers: a live consumer
+// claims the entry a crashed one left pending.
+func TestRedisQueueReclaimsAcrossConsumers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client, cleanUp, err := testutils.RunRedis(ctx)
+	require.NoError(t, err)
+	defer func() {
+		if err := cleanUp(); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}()
+
+	crashed, err := queue.NewQueue(ctx, client, &config.Queue{ConsumerID: "crashed-consumer"})
+	require.NoError(t, err)
+	live, err := queue.NewQueue(ctx, client, &config.Queue{
+		ConsumerID:        "live-consumer",
+		VisibilityTimeout: 200 * time.Millisecond,
+		ReclaimInterval:   50 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, crashed.Add([]*models.Task{models.NewTask("test-topic", "https://example.com", queue.HighPriorityQueue, "")}))
+
+	got, err := crashed.GetTasks(ctx, 1, queue.HighPriorityQueue)
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+	assert.Equal(t, 1, got[0].Deliveries)
+
+	reclaimCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	go live.RunReclaimer(reclaimCtx)
+
+	var reclaimed []*models.Task
+	require.Eventually(t, func() bool {
+		reclaimed, err = live.GetTasks(ctx, 1, queue.HighPriorityQueue)
+		return err == nil && len(reclaimed) == 1 && reclaimed[0] != nil
+	}, 5*time.Second, 100*time.Millisecond)
+
+	assert.Equal(t, got[0].ID, reclaimed[0].ID)
+	assert.Equal(t, "https://example.com", reclaimed[0].URL)
+	assert.Equal(t, 2, reclaimed[0].Deliveries)
+
+	require.NoError(t, live.Del(reclaimed))
+	isEmpty, err := live.IsEmpty(queue.HighPriorityQueue, ctx)
+	require.NoError(t, err)
+	assert.True(t, isEmpty)
+}
+
+func testQueueLifecycle(ctx context.Context, t *testing.T, newQueue queueFactory) {
 	testCases := []struct {
 		name    string
 		message *models.Task
@@ -23,26 +130,14 @@ func TestQueueLifecycle(t *testing.T) {
 			message: models.NewTask("test-topic", "https://example.com", queue.HighPriorityQueue, ""),
 		},
 	}
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	client, cleanUp, err := testutils.RunRedis(ctx)
-	require.NoError(t, err)
 
-	qCfg := &config.Queue{
+	q := newQueue(t, &config.Queue{
 		ConsumerID: "test-consumer",
-	}
+	})
 
-	q, err := queue.NewQueue(ctx, client, qCfg)
-	assert.NoError(t, err)
-
-	defer func() {
-		if err := cleanUp(); err != nil {
-			t.Fatalf(err.Error())
-		}
-	}()
 	for _, tc := range testCases {
 		msg := tc.message
-		err = q.Add([]*models.Task{msg})
+		err := q.Add([]*models.Task{msg})
 		assert.NoError(t, err)
 
 		got, err := q.GetTasks(ctx, 1, queue.HighPriorityQueue)
@@ -56,3 +151,272 @@ func TestQueueLifecycle(t *testing.T) {
 
 	}
 }
+
+func testQueueReclaimsStaleTasks(ctx context.Context, t *testing.T, newQueue queueFactory) {
+	q := newQueue(t, &config.Queue{
+		ConsumerID:        "test-consumer",
+		VisibilityTimeout: 200 * time.Millisecond,
+		ReclaimInterval:   50 * time.Millisecond,
+	})
+
+	require.NoError(t, q.Add([]*models.Task{models.NewTask("test-topic", "https://example.com", queue.HighPriorityQueue, "")}))
+
+	// The first delivery is never acknowledged, as if its worker crashed.
+	got, err := q.GetTasks(ctx, 1, queue.HighPriorityQueue)
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+	assert.Equal(t, 1, got[0].Deliveries)
+
+	reclaimCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	go q.RunReclaimer(reclaimCtx)
+
+	var reclaimed []*models.Task
+	require.Eventually(t, func() bool {
+		reclaimed, err = q.GetTasks(ctx, 1, queue.HighPriorityQueue)
+		return err == nil && len(reclaimed) == 1 && reclaimed[0] != nil
+	}, 5*time.Second, 100*time.Millisecond)
+
+	assert.Equal(t, got[0].ID, reclaimed[0].ID)
+	assert.Equal(t, "https://example.com", reclaimed[0].URL)
+	assert.Equal(t, 2, reclaimed[0].Deliveries)
+
+	require.NoError(t, q.Del(reclaimed))
+	isEmpty, err := q.IsEmpty(queue.HighPriorityQueue, ctx)
+	require.NoError(t, err)
+	assert.True(t, isEmpty)
+}
+
+func testQueueFrontierSchedulesHostsFairly(ctx context.Context, t *testing.T, newQueue queueFactory) {
+	q := newQueue(t, &config.Queue{
+		ConsumerID: "test-consumer",
+		HostDelay:  time.Second,
+	})
+
+	var tasks []*models.Task
+	for i := range 5 {
+		tasks = append(tasks, models.NewTask("crawl_page", fmt.Sprintf("https://big.com/%d", i), queue.MediumPriorityQueue, ""))
+	}
+	tasks = append(tasks,
+		models.NewTask("crawl_page", "https://a.com/", queue.MediumPriorityQueue, ""),
+		models.NewTask("crawl_page", "https://b.com/", queue.MediumPriorityQueue, ""),
+	)
+	require.NoError(t, q.Add(tasks))
+
+	got, err := q.GetTasks(ctx, 10, queue.MediumPriorityQueue)
+	require.NoError(t, err)
+	got = nonNilTasks(got)
+	hosts := map[string]int{}
+	for _, task := range got {
+		host, err := utils.GetDomain(task.URL)
+		require.NoError(t, err)
+		hosts[host]++
+	}
+	assert.Equal(t, map[string]int{"big.com": 1, "a.com": 1, "b.com": 1}, hosts)
+	require.NoError(t, q.Del(got))
+
+	_, err = q.GetTasks(ctx, 10, queue.MediumPriorityQueue)
+	assert.ErrorIs(t, err, utils.ErrNoTasks, "big.com must wait for its host delay")
+
+	time.Sleep(1100 * time.Millisecond)
+	got, err = q.GetTasks(ctx, 10, queue.MediumPriorityQueue)
+	require.NoError(t, err)
+	got = nonNilTasks(got)
+	require.Len(t, got, 1)
+	assert.Equal(t, "https://big.com/1", got[0].URL)
+}
+
+func TestRedisFrontierUsesHostDelay(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client, cleanUp, err := testutils.RunRedis(ctx)
+	require.NoError(t, err)
+	defer func() {
+		if err := cleanUp(); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}()
+	q, err := queue.NewQueue(ctx, client, &config.Queue{ConsumerID: "test-consumer", HostDelay: time.Second})
+	require.NoError(t, err)
+
+	// The politeness hash of a host whose robots.txt sets a crawl delay.
+	require.NoError(t, client.HSet(ctx, "slow.com", "delay", 60000, "refill_time", 0).Err())
+	require.NoError(t, q.Add([]*models.Task{
+		models.NewTask("crawl_page", "https://slow.com/1", queue.MediumPriorityQueue, ""),
+		models.NewTask("crawl_page", "https://slow.com/2", queue.MediumPriorityQueue, ""),
+	}))
+	got, err := q.GetTasks(ctx, 10, queue.MediumPriorityQueue)
+	require.NoError(t, err)
+	require.Len(t, nonNilTasks(got), 1)
+
+	next, err := client.ZScore(ctx, "frontier:ready", "slow.com").Result()
+	require.NoError(t, err)
+	assert.Greater(t, next, float64(time.Now().Add(50*time.Second).UnixMilli()), "the crawl delay outweighs the host delay")
+}
+
+func TestRedisQueueAddReportsFailures(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client, cleanUp, err := testutils.RunRedis(ctx)
+	require.NoError(t, err)
+	defer func() {
+		if err := cleanUp(); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}()
+	q, err := queue.NewQueue(ctx, client, &config.Queue{ConsumerID: "test-consumer"})
+	require.NoError(t, err)
+
+	// A key of the wrong type makes every XADD to the high lane fail.
+	require.NoError(t, client.Del(ctx, queue.HighPriorityQueue).Err())
+	require.NoError(t, client.Set(ctx, queue.HighPriorityQueue, "not a stream", 0).Err())
+	err = q.Add([]*models.Task{
+		models.NewTask("crawl_page", "https://example.com/lost", queue.HighPriorityQueue, ""),
+		models.NewTask("store_data", "https://example.com/kept", queue.StoreQueue, ""),
+	})
+	assert.Error(t, err, "a task that was not added is reported")
+	n, err := q.Len(ctx, queue.StoreQueue)
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, n, "the other tasks are still added")
+}
+
+// failAttempt records a failed attempt of task and schedules its retry by
+// the default policy, as a handler does.
+func failAttempt(task *models.Task, err error) {
+	task.RecordAttempt(err)
+	policy, _ := retry.NewPolicy(nil)
+	policy.Schedule(task, err)
+}
+
+func testQueuePromotesDueRetries(ctx context.Context, t *testing.T, newQueue queueFactory) {
+	q := newQueue(t, &config.Queue{
+		ConsumerID: "test-consumer",
+		RetrySets:  []string{utils.ErrorClassRetryLater},
+	})
+
+	task := models.NewTask("crawl_page", "https://example.com/flaky", queue.HighPriorityQueue, "")
+	failAttempt(task, utils.ErrRetryLater)
+	require.NoError(t, q.Retry(ctx, *task))
+
+	_, err := q.GetTasks(ctx, 10, queue.RetryPriorityQueue)
+	assert.ErrorIs(t, err, utils.ErrNoTasks)
+	_, err = q.GetTasks(ctx, 10, queue.HighPriorityQueue)
+	assert.ErrorIs(t, err, utils.ErrNoTasks, "retry must not be promoted before it is due")
+
+	var got []*models.Task
+	require.Eventually(t, func() bool {
+		got, err = q.GetTasks(ctx, 10, queue.RetryPriorityQueue)
+		got = nonNilTasks(got)
+		return err == nil && len(got) == 1
+	}, 5*time.Second, 200*time.Millisecond, "the retry lane hands out the tasks it promotes")
+
+	assert.Equal(t, "https://example.com/flaky", got[0].URL)
+	assert.Equal(t, queue.HighPriorityQueue, got[0].SourceName)
+	assert.Equal(t, 1, got[0].Retries)
+	require.Len(t, got[0].Attempts, 1)
+	assert.Equal(t, utils.ErrorClassRetryLater, got[0].Attempts[0].ErrorClass)
+	require.NoError(t, q.Del(got))
+
+	// Retries of the medium lane wait in the host frontier like new links.
+	task = models.NewTask("crawl_page", "https://example.com/medium", queue.MediumPriorityQueue, "")
+	failAttempt(task, utils.ErrRetryLater)
+	require.NoError(t, q.Retry(ctx, *task))
+	require.Eventually(t, func() bool {
+		_, err = q.GetTasks(ctx, 10, queue.RetryPriorityQueue)
+		n, _ := q.Len(ctx, queue.RetryPriorityQueue)
+		return errors.Is(err, utils.ErrNoTasks) && n == 0
+	}, 5*time.Second, 200*time.Millisecond)
+	got, err = q.GetTasks(ctx, 10, queue.MediumPriorityQueue)
+	require.NoError(t, err)
+	got = nonNilTasks(got)
+	require.Len(t, got, 1)
+	assert.Equal(t, "https://example.com/medium", got[0].URL)
+	require.NoError(t, q.Del(got))
+}
+
+func testQueueLength(ctx context.Context, t *testing.T, newQueue queueFactory) {
+	q := newQueue(t, &config.Queue{
+		ConsumerID: "test-consumer",
+	})
+
+	require.NoError(t, q.Add([]*models.Task{
+		models.NewTask("crawl_page", "https://example.com/1", queue.HighPriorityQueue, ""),
+		models.NewTask("crawl_page", "https://example.com/2", queue.HighPriorityQueue, ""),
+		models.NewTask("crawl_page", "https://a.com/", queue.MediumPriorityQueue, ""),
+		models.NewTask("crawl_page", "https://a.com/next", queue.MediumPriorityQueue, ""),
+	}))
+
+	n, err := q.Len(ctx, queue.HighPriorityQueue)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+	n, err = q.Len(ctx, queue.MediumPriorityQueue)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), n, "tasks waiting in the frontier count towards the lane")
+
+	// Delivered tasks are held until they are acknowledged.
+	got, err := q.GetTasks(ctx, 10, queue.MediumPriorityQueue)
+	require.NoError(t, err)
+	got = nonNilTasks(got)
+	require.Len(t, got, 1)
+	n, err = q.Len(ctx, queue.MediumPriorityQueue)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+
+	require.NoError(t, q.Del(got))
+	n, err = q.Len(ctx, queue.MediumPriorityQueue)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+}
+
+func testQueuePurgesJob(ctx context.Context, t *testing.T, newQueue queueFactory) {
+	q := newQueue(t, &config.Queue{
+		ConsumerID: "test-consumer",
+	})
+
+	jobTask := func(job, url, source string) *models.Task {
+		task := models.NewTask("crawl_page", url, source, "")
+		task.JobID = job
+		return task
+	}
+	require.NoError(t, q.Add([]*models.Task{
+		jobTask("a", "https://a.com/1", queue.HighPriorityQueue),
+		jobTask("b", "https://b.com/1", queue.HighPriorityQueue),
+		jobTask("a", "https://a.com/2", queue.MediumPriorityQueue),
+		jobTask("a", "https://a.com/3", queue.MediumPriorityQueue),
+		jobTask("b", "https://b.com/2", queue.MediumPriorityQueue),
+	}))
+	retry := jobTask("a", "https://a.com/4", queue.HighPriorityQueue)
+	failAttempt(retry, utils.ErrRetryLater)
+	require.NoError(t, q.Retry(ctx, *retry))
+
+	n, err := q.PurgeJob(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, 4, n)
+
+	high, err := q.GetTasks(ctx, 10, queue.HighPriorityQueue)
+	require.NoError(t, err)
+	high = nonNilTasks(high)
+	require.Len(t, high, 1)
+	assert.Equal(t, "b", high[0].JobID)
+
+	medium, err := q.GetTasks(ctx, 10, queue.MediumPriorityQueue)
+	require.NoError(t, err)
+	medium = nonNilTasks(medium)
+	require.Len(t, medium, 1)
+	assert.Equal(t, "https://b.com/2", medium[0].URL)
+
+	retries, err := q.Len(ctx, queue.RetryPriorityQueue)
+	require.NoError(t, err)
+	assert.Zero(t, retries)
+	require.NoError(t, q.Del(append(high, medium...)))
+}
+
+func nonNilTasks(tasks []*models.Task) []*models.Task {
+	res := make([]*models.Task, 0, len(tasks))
+	for _, t := range tasks {
+		if t != nil {
+			res = append(res, t)
+		}
+	}
+	return res
+}
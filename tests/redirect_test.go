@@ -0,0 +1,102 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/NesterovYehor/Crawler/internal/config"
+	httpclient "github.com/NesterovYehor/Crawler/internal/http_client"
+	"github.com/NesterovYehor/Crawler/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedirectPolicy(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hop/{n}", func(w http.ResponseWriter, r *http.Request) {
+		n, _ := strconv.Atoi(r.PathValue("n"))
+		if n == 0 {
+			io.WriteString(w, "landed")
+			return
+		}
+		http.Redirect(w, r, "/hop/"+strconv.Itoa(n-1), http.StatusFound)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	// The same server under another host name.
+	elsewhere := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+	mux.HandleFunc("/away", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, elsewhere+"/hop/0", http.StatusMovedPermanently)
+	})
+
+	client := func(cfg config.Redirects) httpclient.Interface {
+		policy, err := httpclient.NewRedirectPolicy(cfg)
+		require.NoError(t, err)
+		return httpclient.NewClient(&httpclient.ClientOpts{IdleConns: 1, Redirects: policy})
+	}
+	stopped := func(t *testing.T, res *httpclient.FetchResult, err error, reason string) {
+		t.Helper()
+		var redirect *utils.RedirectError
+		require.True(t, errors.As(err, &redirect), "got %v", err)
+		assert.Equal(t, reason, redirect.Reason)
+		assert.Equal(t, redirect.To, res.RedirectTo)
+		assert.Nil(t, res.Body)
+		assert.Equal(t, utils.ErrorClassRedirect, utils.ClassifyError(err))
+	}
+	ctx := context.Background()
+
+	t.Run("chain", func(t *testing.T) {
+		res, err := client(config.Redirects{}).Fetch(ctx, srv.URL+"/hop/3")
+		require.NoError(t, err)
+		defer res.Close()
+		assert.Equal(t, srv.URL+"/hop/0", res.FinalURL)
+		assert.Equal(t, []string{srv.URL + "/hop/3", srv.URL + "/hop/2", srv.URL + "/hop/1"}, res.Redirects)
+	})
+
+	t.Run("max_hops", func(t *testing.T) {
+		res, err := client(config.Redirects{MaxHops: 2}).Fetch(ctx, srv.URL+"/hop/3")
+		stopped(t, res, err, httpclient.RedirectMaxHops)
+		assert.Equal(t, http.StatusFound, res.StatusCode)
+		assert.Equal(t, srv.URL+"/hop/1", res.FinalURL)
+		assert.Equal(t, []string{srv.URL + "/hop/3", srv.URL + "/hop/2"}, res.Redirects)
+		assert.Equal(t, srv.URL+"/hop/0", res.RedirectTo)
+	})
+
+	t.Run("scope", func(t *testing.T) {
+		for _, scope := range []string{httpclient.RedirectScopeSameHost, httpclient.RedirectScopeSameSite} {
+			res, err := client(config.Redirects{Scope: scope}).Fetch(ctx, srv.URL+"/away")
+			stopped(t, res, err, httpclient.RedirectOffScope)
+			assert.Equal(t, elsewhere+"/hop/0", res.RedirectTo)
+		}
+		res, err := client(config.Redirects{Scope: httpclient.RedirectScopeSameHost}).Fetch(ctx, srv.URL+"/hop/1")
+		require.NoError(t, err, "redirects within the host are followed")
+		res.Close()
+
+		_, err = httpclient.NewRedirectPolicy(config.Redirects{Scope: "same_planet"})
+		assert.Error(t, err)
+	})
+
+	t.Run("job_scope", func(t *testing.T) {
+		jobCtx := httpclient.WithScope(ctx, func(u string) bool { return !strings.Contains(u, "localhost") })
+		res, err := client(config.Redirects{}).Fetch(jobCtx, srv.URL+"/away")
+		stopped(t, res, err, httpclient.RedirectOffJob)
+	})
+
+	t.Run("enqueue", func(t *testing.T) {
+		c := client(config.Redirects{Enqueue: true})
+		res, err := c.Fetch(httpclient.QueueRedirects(ctx), srv.URL+"/hop/2")
+		stopped(t, res, err, httpclient.RedirectQueued)
+		assert.Equal(t, srv.URL+"/hop/1", res.RedirectTo)
+		assert.Empty(t, res.Redirects)
+
+		res, err = c.Fetch(ctx, srv.URL+"/hop/2")
+		require.NoError(t, err, "callers that cannot queue targets still follow redirects")
+		res.Close()
+	})
+}
@@ -0,0 +1,61 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/NesterovYehor/Crawler/internal/config"
+	"github.com/NesterovYehor/Crawler/internal/queue"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSourceWeightedSelection(t *testing.T) {
+	lanes := []config.Lane{
+		{Name: queue.HighPriorityQueue, Workers: 1, Weight: 0},
+		{Name: queue.MediumPriorityQueue, Workers: 1, Weight: 3},
+		{Name: queue.StoreQueue, Workers: 1, Weight: 1},
+		{Name: queue.RetryPriorityQueue, Workers: 1, Weight: 1},
+		{Name: "queue:fetch:recrawl", Workers: 0, Weight: 5},
+	}
+	_, err := queue.Lanes(lanes, nil)
+	require.NoError(t, err)
+
+	s := queue.NewSource(queue.RetryPriorityQueue, lanes)
+	assert.Equal(t, queue.RetryPriorityQueue, s.GetCurrentSource(), "own lane is preferred while available")
+
+	s.MarkQueueFailed(queue.RetryPriorityQueue)
+	picks := map[string]int{}
+	for range 90 {
+		picks[s.GetCurrentSource()]++
+	}
+	assert.Equal(t, map[string]int{
+		queue.MediumPriorityQueue: 30,
+		queue.StoreQueue:          10,
+		"queue:fetch:recrawl":     50,
+	}, picks, "lanes are picked by weight and zero-weight lanes are never stolen from")
+}
+
+func TestLanesValidation(t *testing.T) {
+	_, err := queue.Lanes([]config.Lane{{Name: queue.HighPriorityQueue, Weight: 1}}, nil)
+	assert.Error(t, err, "built-in lanes are required")
+
+	_, err = queue.Lanes([]config.Lane{
+		{Name: queue.HighPriorityQueue, MinWorkers: 5, MaxWorkers: 2},
+		{Name: queue.MediumPriorityQueue},
+		{Name: queue.StoreQueue},
+		{Name: queue.RetryPriorityQueue},
+	}, nil)
+	assert.Error(t, err, "min_workers may not exceed max_workers")
+
+	lanes, err := queue.Lanes(nil, &config.Workers{
+		Fetch:  config.Fetch{HighPrioretyCount: 3, MedPrioretyCount: 2, LowPrioretyCount: 1},
+		Upload: config.Upload{Count: 4},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []config.Lane{
+		{Name: queue.HighPriorityQueue, Workers: 3, Weight: 8},
+		{Name: queue.MediumPriorityQueue, Workers: 2, Weight: 4},
+		{Name: queue.StoreQueue, Workers: 4, Weight: 2},
+		{Name: queue.RetryPriorityQueue, Workers: 1, Weight: 1},
+	}, lanes)
+}
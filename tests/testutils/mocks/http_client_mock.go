@@ -1,28 +1,57 @@
 package mocks
 
 import (
+	"context"
 	"errors"
 	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	httpclient "github.com/NesterovYehor/Crawler/internal/http_client"
+	"github.com/NesterovYehor/Crawler/internal/sitemap"
 )
 
 type MockHTTPClient struct {
-	FetchBodyFn  func(rawURL string) (io.ReadCloser, error)
-	FetchRulesFn func(baseURL string) ([]byte, bool, []string, error)
+	FetchFn           func(ctx context.Context, rawURL string) (*httpclient.FetchResult, error)
+	FetchIfModifiedFn func(ctx context.Context, rawURL string, v httpclient.Validators) (*httpclient.FetchResult, error)
+	FetchRulesFn      func(ctx context.Context, baseURL string) ([]byte, bool, []sitemap.Entry, error)
+}
+
+// Fetch mocks the Fetch method.
+func (m *MockHTTPClient) Fetch(ctx context.Context, rawURL string) (*httpclient.FetchResult, error) {
+	if m.FetchFn != nil {
+		return m.FetchFn(ctx, rawURL)
+	}
+	return nil, errors.New("FetchFn not set")
 }
 
-// FetchBody mocks the FetchBody method.
-func (m *MockHTTPClient) FetchBody(rawURL string) (io.ReadCloser, error) {
-	if m.FetchBodyFn != nil {
-		body, err := m.FetchBodyFn(rawURL)
-		return body, err
+// FetchIfModified mocks the FetchIfModified method. Without
+// FetchIfModifiedFn it falls back to FetchFn.
+func (m *MockHTTPClient) FetchIfModified(ctx context.Context, rawURL string, v httpclient.Validators) (*httpclient.FetchResult, error) {
+	if m.FetchIfModifiedFn != nil {
+		return m.FetchIfModifiedFn(ctx, rawURL, v)
 	}
-	return nil, errors.New("FetchBodyFn not set")
+	return m.Fetch(ctx, rawURL)
 }
 
 // FetchRules mocks the FetchRules method.
-func (m *MockHTTPClient) FetchRules(baseURL string) ([]byte, bool, []string, error) {
+func (m *MockHTTPClient) FetchRules(ctx context.Context, baseURL string) ([]byte, bool, []sitemap.Entry, error) {
 	if m.FetchRulesFn != nil {
-		return m.FetchRulesFn(baseURL)
+		return m.FetchRulesFn(ctx, baseURL)
 	}
 	return nil, false, nil, errors.New("FetchRulesFn not set")
 }
+
+// HTMLResult returns a 200 response with an HTML body, as Fetch would.
+func HTMLResult(rawURL, body string) *httpclient.FetchResult {
+	return &httpclient.FetchResult{
+		URL:         rawURL,
+		FinalURL:    rawURL,
+		StatusCode:  http.StatusOK,
+		Header:      http.Header{"Content-Type": []string{"text/html; charset=utf-8"}},
+		ContentType: "text/html; charset=utf-8",
+		Body:        io.NopCloser(strings.NewReader(body)),
+		FetchedAt:   time.Now(),
+	}
+}
@@ -10,6 +10,9 @@ func NewNoopMetrics() *metrics.Metrics {
 	return &metrics.Metrics{
 		Crawler: &CrawlerNoopMetrics{},
 		Queue:   &QueueNoopMetrics{},
+		Jobs:    &JobNoopMetrics{},
+		Workers: &WorkerNoopMetrics{},
+		Proxy:   &ProxyNoopMetrics{},
 		Store: &StoreNoopMetrics{
 			DB: &DBNoopMetrics{},
 			Cache: &CacheNoopMetrics{
@@ -35,6 +38,7 @@ func (m *StoreNoopMetrics) DBMetrics() metrics.DBMetrics       { return m.DB }
 type CrawlerNoopMetrics struct{}
 
 func (m *CrawlerNoopMetrics) Update(_ bool, _ time.Duration) {}
+func (m *CrawlerNoopMetrics) ObserveUnchanged()              {}
 
 // --- DB ---
 type DBNoopMetrics struct{}
@@ -44,9 +48,31 @@ func (m *DBNoopMetrics) Update(_ bool, _ time.Duration) {}
 // --- Queue ---
 type QueueNoopMetrics struct{}
 
-func (m *QueueNoopMetrics) ObserveAdd(_ string)   {}
-func (m *QueueNoopMetrics) ObserveFailure()       {}
-func (m *QueueNoopMetrics) ObserveFetch(_ string) {}
+func (m *QueueNoopMetrics) ObserveAdd(_ string)                             {}
+func (m *QueueNoopMetrics) ObserveFailure()                                 {}
+func (m *QueueNoopMetrics) ObserveFetch(_ string)                           {}
+func (m *QueueNoopMetrics) ObserveBackpressure(_ string, _ float64, _ bool) {}
+func (m *QueueNoopMetrics) ObserveShed(_, _ string, _ int)                  {}
+
+// --- Jobs ---
+type JobNoopMetrics struct{}
+
+func (m *JobNoopMetrics) ObservePage(_ string, _ bool)    {}
+func (m *JobNoopMetrics) ObserveTasks(_, _ string, _ int) {}
+
+// --- Workers ---
+type WorkerNoopMetrics struct{}
+
+func (m *WorkerNoopMetrics) ObserveScale(_, _, _ string, _ int) {}
+func (m *WorkerNoopMetrics) ObservePanic(_, _ string)           {}
+func (m *WorkerNoopMetrics) ObserveRetry(_, _, _ string)        {}
+
+// --- Proxies ---
+type ProxyNoopMetrics struct{}
+
+func (m *ProxyNoopMetrics) ObserveRequest(_ string, _ bool, _ time.Duration) {}
+func (m *ProxyNoopMetrics) ObserveEjection(_ string)                         {}
+func (m *ProxyNoopMetrics) SetHealthy(_ string, _ bool)                      {}
 
 // --- Cache ---
 type CacheNoopMetrics struct {